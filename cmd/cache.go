@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kumorilabs/konvert/internal/cache"
+	"github.com/kumorilabs/konvert/internal/functions"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const defaultPruneOlderThan = 30 * 24 * time.Hour
+
+type cacheCommand struct {
+	olderThan time.Duration
+	fnOptions *functions.Options
+}
+
+func newCacheCommand(fnOptions *functions.Options) *cobra.Command {
+	c := &cacheCommand{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "manage the chart cache",
+		Long: `cache manages the on-disk cache of chart archives and repository indexes
+shared across konvert runs. The cache directory defaults to konvert in the user
+cache directory and can be changed with --cache-dir or KONVERT_CACHE_DIR.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "list cached charts",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := c.list(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "remove charts and indexes that have not been used recently",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := c.prune(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+	pruneCmd.Flags().DurationVar(&c.olderThan, "older-than", defaultPruneOlderThan, "remove entries not used for longer than this duration.")
+
+	clearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "remove everything from the cache",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := c.clear(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.AddCommand(listCmd, pruneCmd, clearCmd)
+	return cmd
+}
+
+func (c *cacheCommand) list(out io.Writer) error {
+	chartCache, err := cache.Open(c.fnOptions.CacheDir)
+	if err != nil {
+		return err
+	}
+	entries, err := chartCache.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tCHART\tVERSION\tDIGEST\tSIZE\tLAST USED")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			entry.Repo,
+			entry.Chart,
+			entry.Version,
+			shortDigest(entry.Digest),
+			entry.Size,
+			entry.LastUsed.Format(time.RFC3339),
+		)
+	}
+	return w.Flush()
+}
+
+func (c *cacheCommand) prune(out io.Writer) error {
+	chartCache, err := cache.Open(c.fnOptions.CacheDir)
+	if err != nil {
+		return err
+	}
+	removed, err := chartCache.Prune(c.olderThan)
+	if err != nil {
+		return err
+	}
+	for _, entry := range removed {
+		fmt.Fprintf(out, "removed %s %s %s\n", entry.Repo, entry.Chart, entry.Version)
+	}
+	return nil
+}
+
+func (c *cacheCommand) clear(out io.Writer) error {
+	chartCache, err := cache.Open(c.fnOptions.CacheDir)
+	if err != nil {
+		return err
+	}
+	if err := chartCache.Clear(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "cleared %s\n", chartCache.Dir)
+	return nil
+}
+
+func shortDigest(digest string) string {
+	const length = len("sha256:") + 12
+	if len(digest) > length {
+		return digest[:length]
+	}
+	return digest
+}
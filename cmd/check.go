@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/kumorilabs/konvert/internal/konvert"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type check struct {
+	filepath  string
+	fnOptions *functions.Options
+}
+
+func newCheckCommand(fnOptions *functions.Options) *cobra.Command {
+	check := &check{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "check that rendered charts are up to date",
+		Long: `check renders the Konvert configuration(s) in memory and fails if the files on
+disk do not match byte-for-byte. It reports which Konvert files are stale and
+why. It exits with 1 if any Konvert file is stale and 2 if an error occurred.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			stale, err := check.run(cmd.OutOrStdout())
+			if err != nil {
+				log.Error(err)
+				os.Exit(exitCodeError)
+			}
+			if stale {
+				os.Exit(exitCodeDiff)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&check.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+
+	return cmd
+}
+
+func (c *check) run(out io.Writer) (bool, error) {
+	results, err := konvert.Check(c.filepath, *c.fnOptions)
+	if err != nil {
+		return false, err
+	}
+
+	for _, result := range results {
+		var reasons []string
+		for _, reason := range result.Reasons() {
+			reasons = append(reasons, string(reason))
+		}
+		fmt.Fprintf(out, "%s is stale (%s)\n", result.KonvertFile, strings.Join(reasons, ", "))
+		for _, file := range result.Files {
+			fmt.Fprintf(out, "  %s %s: %s: %s\n", file.Status, file.Path, file.Reason, file.Detail)
+		}
+	}
+
+	return len(results) > 0, nil
+}
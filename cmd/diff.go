@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/kumorilabs/konvert/internal/konvert"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// exit code when there are differences between the rendered charts and
+	// what is on disk
+	exitCodeDiff = 1
+	// exit code when konvert was not able to render the charts
+	exitCodeError = 2
+)
+
+type diff struct {
+	filepath  string
+	fnOptions *functions.Options
+}
+
+func newDiffCommand(fnOptions *functions.Options) *cobra.Command {
+	diff := &diff{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "show changes konvert would make without writing them",
+		Long: `diff renders the Konvert configuration(s) in memory and prints a unified diff
+against the files on disk. It exits with 1 if there are differences and 2 if
+an error occurred.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			changed, err := diff.run(cmd.OutOrStdout())
+			if err != nil {
+				log.Error(err)
+				os.Exit(exitCodeError)
+			}
+			if changed {
+				os.Exit(exitCodeDiff)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&diff.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+
+	return cmd
+}
+
+func (d *diff) run(out io.Writer) (bool, error) {
+	k, err := konvert.New(d.filepath, *d.fnOptions)
+	if err != nil {
+		return false, err
+	}
+
+	diffs, err := k.Diff()
+	if err != nil {
+		return false, err
+	}
+
+	for _, fd := range diffs {
+		unified, err := fd.Unified()
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(out, "# %s %s\n%s", fd.Status, fd.Path, unified)
+	}
+
+	return len(diffs) > 0, nil
+}
@@ -6,9 +6,13 @@ import (
 	"sigs.k8s.io/kustomize/kyaml/fn/framework/command"
 )
 
-func newFnCommand() *cobra.Command {
+func newFnCommand(fnOptions *functions.Options) *cobra.Command {
 	kp := functions.KonvertProcessor{}
 	cmd := command.Build(&kp, command.StandaloneEnabled, false)
+	// the flags are parsed after the processor is built
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		kp.Options = *fnOptions
+	}
 	cmd.Use = "fn"
 	// TODO: usage
 	cmd.Short = "konvert kpt function"
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/kumorilabs/konvert/internal/konvert"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+type outdated struct {
+	filepath  string
+	fnOptions *functions.Options
+	output    string
+}
+
+func newOutdatedCommand(fnOptions *functions.Options) *cobra.Command {
+	outdated := &outdated{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "outdated",
+		Short: "report newer chart versions",
+		Long: `outdated compares the chart version of each Konvert configuration with the
+versions in its repository index (or OCI registry tags) and reports the latest
+patch, minor and major version it can be updated to. Versions not allowed by
+spec.versionConstraint are ignored. Local charts are skipped.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := outdated.run(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&outdated.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+	cmd.Flags().StringVarP(&outdated.output, "output", "o", outputTable, "the output format, table or json.")
+
+	return cmd
+}
+
+func (o *outdated) run(out io.Writer) error {
+	if o.output != outputTable && o.output != outputJSON {
+		return fmt.Errorf("unsupported output format %q", o.output)
+	}
+
+	results, err := konvert.Outdated(o.filepath, *o.fnOptions)
+	if err != nil {
+		return err
+	}
+
+	if o.output == outputJSON {
+		if results == nil {
+			results = []konvert.OutdatedResult{}
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KONVERT FILE\tCHART\tCURRENT\tPATCH\tMINOR\tMAJOR")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.KonvertFile,
+			result.Chart,
+			result.Current,
+			versionOrDash(result.Patch),
+			versionOrDash(result.Minor),
+			versionOrDash(result.Major),
+		)
+	}
+	return w.Flush()
+}
+
+func versionOrDash(version string) string {
+	if version == "" {
+		return "-"
+	}
+	return version
+}
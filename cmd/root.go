@@ -5,9 +5,11 @@ import (
 	"os"
 
 	termutil "github.com/andrew-d/go-termutil"
+	"github.com/kumorilabs/konvert/internal/functions"
 	"github.com/kumorilabs/konvert/internal/konvert"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -19,10 +21,18 @@ var (
 
 type root struct {
 	filepath string
+	options  functions.Options
+}
+
+// addGlobalFlags adds the flags shared by the standalone and fn modes
+func (r *root) addGlobalFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&r.options.CacheDir, "cache-dir", "", "the chart cache directory (defaults to $KONVERT_CACHE_DIR or the user cache directory).")
+	flags.BoolVar(&r.options.Offline, "offline", false, "never access the network, only render charts that are vendored or cached (or set KONVERT_OFFLINE=true).")
 }
 
 func newRootCommand() *cobra.Command {
-	fncommand := newFnCommand()
+	root := &root{}
+	fncommand := newFnCommand(&root.options)
 
 	// Check if KONVERT_FORCE_STANDALONE is set (any value). This allows konvert
 	// to run in standalone mode even when stdin is not a TTY, which is useful for
@@ -30,10 +40,10 @@ func newRootCommand() *cobra.Command {
 	_, forceStandalone := os.LookupEnv("KONVERT_FORCE_STANDALONE")
 	if !forceStandalone && !termutil.Isatty(os.Stdin.Fd()) {
 		log.Info("running in fn mode")
+		root.addGlobalFlags(fncommand.Flags())
 		return fncommand
 	}
 
-	root := &root{}
 	rootCmd := &cobra.Command{
 		Use:   "konvert",
 		Short: "konvert generates kustomize bases or kubernetes manifests",
@@ -51,15 +61,22 @@ func newRootCommand() *cobra.Command {
 
 	rootCmd.SetVersionTemplate(`{{.Version}}`)
 	rootCmd.AddCommand(fncommand)
+	rootCmd.AddCommand(newDiffCommand(&root.options))
+	rootCmd.AddCommand(newCheckCommand(&root.options))
+	rootCmd.AddCommand(newCacheCommand(&root.options))
+	rootCmd.AddCommand(newVendorCommand(&root.options))
+	rootCmd.AddCommand(newOutdatedCommand(&root.options))
+	rootCmd.AddCommand(newUpgradeCommand(&root.options))
 
 	rootCmd.Flags().StringVarP(&root.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+	root.addGlobalFlags(rootCmd.PersistentFlags())
 
 	return rootCmd
 }
 
 func (r *root) run() error {
 	log.Info("running in standalone mode")
-	return konvert.Konvert(r.filepath)
+	return konvert.Konvert(r.filepath, r.options)
 }
 
 // Execute runs the root command
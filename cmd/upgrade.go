@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/kumorilabs/konvert/internal/konvert"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type upgrade struct {
+	filepath  string
+	options   konvert.UpgradeOptions
+	fnOptions *functions.Options
+}
+
+func newUpgradeCommand(fnOptions *functions.Options) *cobra.Command {
+	upgrade := &upgrade{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "upgrade chart versions and re-render them",
+		Long: `upgrade rewrites spec.version of each Konvert configuration to the latest
+chart version allowed by spec.versionConstraint (or the latest patch or minor
+version, or an exact version) and re-renders the upgraded charts. Only the
+version is changed in the Konvert files, comments and formatting are kept.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := upgrade.run(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&upgrade.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+	cmd.Flags().BoolVar(&upgrade.options.Patch, "patch", false, "only upgrade to the latest patch version.")
+	cmd.Flags().BoolVar(&upgrade.options.Minor, "minor", false, "only upgrade to the latest minor version.")
+	cmd.Flags().StringVar(&upgrade.options.To, "to", "", "upgrade to this exact version (requires a single Konvert file, see --name).")
+	cmd.Flags().StringVar(&upgrade.options.Name, "name", "", "only upgrade the Konvert configurations with this name.")
+	cmd.MarkFlagsMutuallyExclusive("patch", "minor", "to")
+
+	return cmd
+}
+
+func (u *upgrade) run(out io.Writer) error {
+	results, err := konvert.Upgrade(u.filepath, *u.fnOptions, u.options)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(out, "all charts are up to date")
+		return nil
+	}
+	for _, result := range results {
+		fmt.Fprintf(out, "%s: upgraded %s from %s to %s\n", result.KonvertFile, result.Chart, result.From, result.To)
+	}
+	return nil
+}
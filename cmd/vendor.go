@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/kumorilabs/konvert/internal/konvert"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type vendor struct {
+	filepath  string
+	fnOptions *functions.Options
+}
+
+func newVendorCommand(fnOptions *functions.Options) *cobra.Command {
+	vendor := &vendor{fnOptions: fnOptions}
+	cmd := &cobra.Command{
+		Use:   "vendor",
+		Short: "download chart archives into the repository",
+		Long: `vendor downloads the chart referenced by each Konvert configuration into its
+vendor directory (spec.vendorDir, charts next to the Konvert file by default)
+as <chart>-<version>.tgz. Vendored archives are preferred over the network when
+rendering, which makes builds reproducible even if a chart version is deleted
+upstream.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := vendor.run(cmd.OutOrStdout()); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&vendor.filepath, "file", "f", "konvert.yaml", "the path to the konvert configuration.")
+
+	return cmd
+}
+
+func (v *vendor) run(out io.Writer) error {
+	results, err := konvert.Vendor(v.filepath, *v.fnOptions)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		switch {
+		case result.Archive == "":
+			fmt.Fprintf(out, "%s: local chart %s, nothing to vendor\n", result.KonvertFile, result.Chart)
+		case result.Added:
+			fmt.Fprintf(out, "%s: vendored %s\n", result.KonvertFile, result.Archive)
+		default:
+			fmt.Fprintf(out, "%s: %s is up to date\n", result.KonvertFile, result.Archive)
+		}
+	}
+	return nil
+}
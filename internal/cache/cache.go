@@ -0,0 +1,394 @@
+// Package cache implements the on-disk chart cache shared across konvert runs.
+//
+// Chart archives are stored content-addressed by their sha256 digest under
+// blobs/, and referenced by entries under charts/ keyed by repo, chart and
+// version. Repository indexes are stored under index/ and are considered fresh
+// for the configured TTL.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// EnvDir overrides the cache directory
+	EnvDir = "KONVERT_CACHE_DIR"
+	// EnvIndexTTL overrides how long a repository index is considered fresh
+	EnvIndexTTL = "KONVERT_CACHE_INDEX_TTL"
+	// DefaultIndexTTL is how long a repository index is considered fresh
+	DefaultIndexTTL = time.Hour
+
+	blobsDir  = "blobs"
+	chartsDir = "charts"
+	indexDir  = "index"
+	entryExt  = ".json"
+	indexExt  = ".yaml"
+	// provenanceExt is the extension Helm gives chart provenance files
+	provenanceExt = ".prov"
+	digestAlgo    = "sha256"
+)
+
+// Cache is an on-disk chart cache
+type Cache struct {
+	Dir      string
+	IndexTTL time.Duration
+}
+
+// Entry is a cached chart archive
+type Entry struct {
+	Repo     string    `json:"repo,omitempty"`
+	Chart    string    `json:"chart"`
+	Version  string    `json:"version,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"-"`
+	// Provenance is the digest of the chart's provenance file, if any
+	Provenance string `json:"provenance,omitempty"`
+	// Path is the path of the cached archive
+	Path string `json:"-"`
+	// ProvenancePath is the path of the cached provenance file, empty when
+	// the chart was cached without one
+	ProvenancePath string `json:"-"`
+}
+
+// New returns a cache in dir using the default index TTL
+func New(dir string) *Cache {
+	return &Cache{
+		Dir:      dir,
+		IndexTTL: DefaultIndexTTL,
+	}
+}
+
+// Default returns the cache configured with the environment, defaulting to a
+// konvert directory in the user cache directory
+func Default() (*Cache, error) {
+	return Open("")
+}
+
+// Open returns the cache in dir, or in DefaultDir when dir is empty, with the
+// index TTL configured with the environment
+func Open(dir string) (*Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	c := New(dir)
+	if value, ok := os.LookupEnv(EnvIndexTTL); ok {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", EnvIndexTTL)
+		}
+		c.IndexTTL = ttl
+	}
+	return c, nil
+}
+
+// DefaultDir returns the cache directory configured with the environment,
+// defaulting to a konvert directory in the user cache directory
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(EnvDir); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine user cache directory")
+	}
+	return filepath.Join(dir, "konvert"), nil
+}
+
+// Chart returns the cached chart for repo, chart and version, or nil when it
+// is not cached. Entries whose archive is missing or corrupted are treated as
+// a miss.
+func (c *Cache) Chart(repo, chart, version string) (*Entry, error) {
+	entryPath := c.entryPath(repo, chart, version)
+	entry, err := c.readEntry(entryPath)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest, _, err := digestFile(entry.Path)
+	if err != nil || digest != entry.Digest {
+		// the archive is gone or was modified, forget about the entry
+		return nil, os.Remove(entryPath)
+	}
+	if entry.ProvenancePath != "" {
+		digest, _, err := digestFile(entry.ProvenancePath)
+		if err != nil || digest != entry.Provenance {
+			return nil, os.Remove(entryPath)
+		}
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(entryPath, now, now); err != nil {
+		return nil, errors.Wrap(err, "unable to update cache entry")
+	}
+	return &entry, nil
+}
+
+// PutChart stores the archive for repo, chart and version, downloaded from
+// url, and returns the path of the cached archive. The provenance file next
+// to the archive (<archive>.prov) is stored with it when there is one.
+func (c *Cache) PutChart(repo, chart, version, url, archive string) (string, error) {
+	digest, size, err := c.putBlob(archive)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to cache chart archive")
+	}
+
+	var provenance string
+	if _, err := os.Stat(archive + provenanceExt); err == nil {
+		provenance, _, err = c.putBlob(archive + provenanceExt)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to cache chart provenance")
+		}
+	}
+
+	data, err := json.MarshalIndent(Entry{
+		Repo:       repo,
+		Chart:      chart,
+		Version:    version,
+		URL:        url,
+		Digest:     digest,
+		Size:       size,
+		Created:    time.Now().UTC(),
+		Provenance: provenance,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFile(c.entryPath(repo, chart, version), data); err != nil {
+		return "", errors.Wrap(err, "unable to write cache entry")
+	}
+	return c.blobPath(digest), nil
+}
+
+// putBlob stores the content of path and returns its digest and size
+func (c *Cache) putBlob(path string) (string, int64, error) {
+	digest, size, err := digestFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	blob := c.blobPath(digest)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := copyFile(path, blob); err != nil {
+			return "", 0, err
+		}
+	}
+	return digest, size, nil
+}
+
+// Index returns the path of the cached index for repo and whether it is still
+// fresh. The path is empty when the index is not cached.
+func (c *Cache) Index(repo string) (string, bool) {
+	path := c.indexPath(repo)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	return path, time.Since(info.ModTime()) < c.IndexTTL
+}
+
+// PutIndex stores the index for repo and returns its path
+func (c *Cache) PutIndex(repo string, data []byte) (string, error) {
+	path := c.indexPath(repo)
+	if err := writeFile(path, data); err != nil {
+		return "", errors.Wrap(err, "unable to cache repository index")
+	}
+	return path, nil
+}
+
+// List returns the cached charts sorted by repo, chart and version
+func (c *Cache) List() ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.Dir, chartsDir, "*"+entryExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, path := range paths {
+		entry, err := c.readEntry(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return a.Version < b.Version
+	})
+	return entries, nil
+}
+
+// Prune removes the charts and indexes that have not been used for longer
+// than olderThan, and any archive no longer referenced by a chart. It returns
+// the removed charts.
+func (c *Cache) Prune(olderThan time.Duration) ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Entry
+	referenced := make(map[string]bool)
+	for _, entry := range entries {
+		if time.Since(entry.LastUsed) > olderThan {
+			if err := os.Remove(c.entryPath(entry.Repo, entry.Chart, entry.Version)); err != nil {
+				return removed, err
+			}
+			removed = append(removed, entry)
+			continue
+		}
+		referenced[entry.Digest] = true
+		if entry.Provenance != "" {
+			referenced[entry.Provenance] = true
+		}
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(c.Dir, blobsDir, digestAlgo, "*"))
+	if err != nil {
+		return removed, err
+	}
+	for _, blob := range blobs {
+		if !referenced[digestAlgo+":"+filepath.Base(blob)] {
+			if err := os.Remove(blob); err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	indexes, err := filepath.Glob(filepath.Join(c.Dir, indexDir, "*"+indexExt))
+	if err != nil {
+		return removed, err
+	}
+	for _, index := range indexes {
+		info, err := os.Stat(index)
+		if err != nil {
+			return removed, err
+		}
+		if time.Since(info.ModTime()) > olderThan {
+			if err := os.Remove(index); err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// Clear removes everything from the cache. Only the directories managed by
+// the cache are removed, in case Dir points somewhere unexpected.
+func (c *Cache) Clear() error {
+	for _, dir := range []string{blobsDir, chartsDir, indexDir} {
+		if err := os.RemoveAll(filepath.Join(c.Dir, dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) entryPath(repo, chart, version string) string {
+	return filepath.Join(c.Dir, chartsDir, hash(repo, chart, version)+entryExt)
+}
+
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.Dir, blobsDir, digestAlgo, strings.TrimPrefix(digest, digestAlgo+":"))
+}
+
+func (c *Cache) indexPath(repo string) string {
+	return filepath.Join(c.Dir, indexDir, hash(strings.TrimSuffix(repo, "/"))+indexExt)
+}
+
+func hash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) readEntry(path string) (Entry, error) {
+	var entry Entry
+	info, err := os.Stat(path)
+	if err != nil {
+		return entry, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, errors.Wrapf(err, "unable to parse cache entry %q", path)
+	}
+	entry.LastUsed = info.ModTime()
+	entry.Path = c.blobPath(entry.Digest)
+	if entry.Provenance != "" {
+		entry.ProvenancePath = c.blobPath(entry.Provenance)
+	}
+	return entry, nil
+}
+
+func digestFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s:%x", digestAlgo, h.Sum(nil)), size, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFile(dst, data)
+}
+
+// writeFile writes data atomically so concurrent runs never see partial files
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
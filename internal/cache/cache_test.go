@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheChart(t *testing.T) {
+	c := New(t.TempDir())
+	archive := filepath.Join(t.TempDir(), "chart-0.1.0.tgz")
+	require.NoError(t, os.WriteFile(archive, []byte("chart"), 0644), "WriteFile")
+
+	entry, err := c.Chart("https://charts.example.com", "chart", "0.1.0")
+	require.NoError(t, err, "Chart")
+	assert.Nil(t, entry, "empty cache")
+
+	cached, err := c.PutChart("https://charts.example.com", "chart", "0.1.0", "https://charts.example.com/chart-0.1.0.tgz", archive)
+	require.NoError(t, err, "PutChart")
+	// the same content is stored once
+	_, err = c.PutChart("https://mirror.example.com", "chart", "0.1.0", "https://mirror.example.com/chart-0.1.0.tgz", archive)
+	require.NoError(t, err, "PutChart")
+	blobs, err := filepath.Glob(filepath.Join(c.Dir, blobsDir, digestAlgo, "*"))
+	require.NoError(t, err, "Glob")
+	assert.Len(t, blobs, 1, "content-addressed blobs")
+
+	entry, err = c.Chart("https://charts.example.com", "chart", "0.1.0")
+	require.NoError(t, err, "Chart")
+	require.NotNil(t, entry, "cached")
+	assert.Equal(t, cached, entry.Path, "cached path")
+	assert.Equal(t, "https://charts.example.com/chart-0.1.0.tgz", entry.URL, "url")
+
+	assert.Empty(t, entry.ProvenancePath, "no provenance")
+
+	// the provenance file is cached with the archive
+	require.NoError(t, os.WriteFile(archive+".prov", []byte("signature"), 0644), "WriteFile")
+	_, err = c.PutChart("https://charts.example.com", "chart", "0.1.0", "https://charts.example.com/chart-0.1.0.tgz", archive)
+	require.NoError(t, err, "PutChart")
+	entry, err = c.Chart("https://charts.example.com", "chart", "0.1.0")
+	require.NoError(t, err, "Chart")
+	require.NotNil(t, entry, "cached")
+	require.NotEmpty(t, entry.ProvenancePath, "provenance")
+	provenance, err := os.ReadFile(entry.ProvenancePath)
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, "signature", string(provenance), "provenance")
+
+	entry, err = c.Chart("https://charts.example.com", "chart", "0.2.0")
+	require.NoError(t, err, "Chart")
+	assert.Nil(t, entry, "other version")
+
+	// a corrupted archive is a miss
+	require.NoError(t, os.WriteFile(cached, []byte("corrupted"), 0644), "WriteFile")
+	entry, err = c.Chart("https://charts.example.com", "chart", "0.1.0")
+	require.NoError(t, err, "Chart")
+	assert.Nil(t, entry, "corrupted")
+}
+
+func TestCacheIndex(t *testing.T) {
+	c := New(t.TempDir())
+
+	path, fresh := c.Index("https://charts.example.com")
+	assert.Empty(t, path, "not cached")
+	assert.False(t, fresh, "not cached")
+
+	cached, err := c.PutIndex("https://charts.example.com/", []byte("apiVersion: v1\n"))
+	require.NoError(t, err, "PutIndex")
+
+	path, fresh = c.Index("https://charts.example.com")
+	assert.Equal(t, cached, path, "trailing slash is ignored")
+	assert.True(t, fresh, "fresh")
+
+	c.IndexTTL = 0
+	path, fresh = c.Index("https://charts.example.com")
+	assert.Equal(t, cached, path, "stale index is still returned")
+	assert.False(t, fresh, "stale")
+}
+
+func TestCacheListPruneClear(t *testing.T) {
+	c := New(t.TempDir())
+	for _, version := range []string{"0.2.0", "0.1.0"} {
+		archive := filepath.Join(t.TempDir(), "chart.tgz")
+		require.NoError(t, os.WriteFile(archive, []byte(version), 0644), "WriteFile")
+		_, err := c.PutChart("https://charts.example.com", "chart", version, "", archive)
+		require.NoError(t, err, "PutChart")
+	}
+	_, err := c.PutIndex("https://charts.example.com", []byte("apiVersion: v1\n"))
+	require.NoError(t, err, "PutIndex")
+
+	entries, err := c.List()
+	require.NoError(t, err, "List")
+	require.Len(t, entries, 2, "entries")
+	assert.Equal(t, "0.1.0", entries[0].Version, "sorted")
+	assert.Equal(t, int64(5), entries[0].Size, "size")
+
+	// age the 0.1.0 entry and the index
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(c.entryPath("https://charts.example.com", "chart", "0.1.0"), old, old), "Chtimes")
+	require.NoError(t, os.Chtimes(c.indexPath("https://charts.example.com"), old, old), "Chtimes")
+
+	removed, err := c.Prune(24 * time.Hour)
+	require.NoError(t, err, "Prune")
+	require.Len(t, removed, 1, "removed")
+	assert.Equal(t, "0.1.0", removed[0].Version, "removed")
+
+	blobs, err := filepath.Glob(filepath.Join(c.Dir, blobsDir, digestAlgo, "*"))
+	require.NoError(t, err, "Glob")
+	assert.Len(t, blobs, 1, "unreferenced blob removed")
+	path, _ := c.Index("https://charts.example.com")
+	assert.Empty(t, path, "old index removed")
+
+	unrelated := filepath.Join(c.Dir, "unrelated")
+	require.NoError(t, os.WriteFile(unrelated, []byte("keep"), 0644), "WriteFile")
+	require.NoError(t, c.Clear(), "Clear")
+	entries, err = c.List()
+	require.NoError(t, err, "List")
+	assert.Empty(t, entries, "cleared")
+	assert.FileExists(t, unrelated, "only cache directories are removed")
+}
+
+func TestDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvDir, dir)
+	t.Setenv(EnvIndexTTL, "5m")
+
+	c, err := Default()
+	require.NoError(t, err, "Default")
+	assert.Equal(t, dir, c.Dir, "dir")
+	assert.Equal(t, 5*time.Minute, c.IndexTTL, "ttl")
+
+	t.Setenv(EnvIndexTTL, "soon")
+	_, err = Default()
+	assert.Error(t, err, "invalid ttl")
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv(EnvDir, t.TempDir())
+	dir := t.TempDir()
+
+	c, err := Open(dir)
+	require.NoError(t, err, "Open")
+	assert.Equal(t, dir, c.Dir, "the directory overrides the environment")
+}
@@ -0,0 +1,235 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// ChartUpdates are the newer versions a chart can be updated to. A version is
+// empty when there is no newer version of that kind.
+type ChartUpdates struct {
+	// Current is the version rendered today, the latest version matching
+	// the spec version when it is a range
+	Current string `json:"current"`
+	// Patch is the latest version with the same major and minor version
+	Patch string `json:"patch,omitempty"`
+	// Minor is the latest version with the same major version
+	Minor string `json:"minor,omitempty"`
+	// Major is the latest version
+	Major string `json:"major,omitempty"`
+}
+
+// Outdated returns true if there is a newer version of any kind
+func (u ChartUpdates) Outdated() bool {
+	return u.Patch != "" || u.Minor != "" || u.Major != ""
+}
+
+// ChartUpdates returns the newer versions of the chart allowed by the version
+// constraint, or nil for charts that are not in a repository
+func (f *KonvertFunction) ChartUpdates() (*ChartUpdates, error) {
+	versions, err := f.ChartVersions()
+	if err != nil || versions == nil {
+		return nil, err
+	}
+	return newChartUpdates(f.Version, f.VersionConstraint, versions)
+}
+
+// ChartVersions returns the released versions of the chart, see
+// RenderHelmChartFunction.ChartVersions
+func (f *KonvertFunction) ChartVersions() ([]*semver.Version, error) {
+	renderHelmChart := f.renderHelmChartFunction()
+	return renderHelmChart.ChartVersions()
+}
+
+// ChartVersions returns the released versions of the chart, newest first,
+// from the repository index or the OCI registry tags. Pre-releases are
+// ignored. Charts that are not in a repository have no versions and return
+// nil.
+func (f *RenderHelmChartFunction) ChartVersions() ([]*semver.Version, error) {
+	if f.Chart == "" {
+		return nil, fmt.Errorf("chart cannot be empty")
+	}
+
+	var (
+		tags []string
+		err  error
+	)
+	switch {
+	case registry.IsOCI(f.Repo) || (f.Repo == "" && registry.IsOCI(f.Chart)):
+		tags, err = f.registryTags()
+	case f.Repo != "":
+		tags, err = f.indexVersions()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*semver.Version
+	for _, tag := range tags {
+		version, err := semver.NewVersion(tag)
+		if err != nil || version.Prerelease() != "" {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+	return versions, nil
+}
+
+// indexVersions lists the chart versions in the repository index, using the
+// cached index while it is fresh (or however old it is when offline)
+func (f *RenderHelmChartFunction) indexVersions() ([]string, error) {
+	chartCache, err := f.options.chartCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to configure chart cache")
+	}
+	offline, err := f.options.offline()
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath, fresh := chartCache.Index(f.Repo)
+	if offline && indexPath == "" {
+		return nil, errors.Errorf("the index of %s is not available offline: it is not cached in %s", f.Repo, chartCache.Dir)
+	}
+	if !fresh && !offline {
+		tmpdir, err := os.MkdirTemp("", "konvert-helm-")
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create temp directory for helm config and cache")
+		}
+		defer cleanupTmpDir(tmpdir, log.WithField("fn", f.Name()))
+
+		settings := newHelmSettings(tmpdir)
+		creds, err := f.Auth.credentials(f.BaseDirectory)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to resolve repository credentials")
+		}
+		indexPath, err = f.downloadIndex(chartCache, creds, settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load the index of %s", f.Repo)
+	}
+	entries, ok := index.Entries[f.Chart]
+	if !ok {
+		return nil, errors.Errorf("chart %q not found in %s repository", f.Chart, f.Repo)
+	}
+	var versions []string
+	for _, entry := range entries {
+		versions = append(versions, entry.Version)
+	}
+	return versions, nil
+}
+
+// registryTags lists the tags of the chart repository in the OCI registry,
+// from the repo and chart or a full oci:// chart reference
+func (f *RenderHelmChartFunction) registryTags() ([]string, error) {
+	offline, err := f.options.offline()
+	if err != nil {
+		return nil, err
+	}
+	if offline {
+		return nil, errors.Errorf("the tags of %s cannot be listed offline", f.Repo)
+	}
+
+	regcreds, err := f.Registry.credentials(f.BaseDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve registry credentials")
+	}
+	tmpdir, err := os.MkdirTemp("", "konvert-helm-")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temp directory for helm config and cache")
+	}
+	defer cleanupTmpDir(tmpdir, log.WithField("fn", f.Name()))
+
+	regclient, err := regcreds.client(newHelmSettings(tmpdir).RegistryConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting registry client")
+	}
+
+	ref := strings.SplitN(f.Chart, "@", 2)[0]
+	if f.Repo != "" {
+		ref = strings.TrimSuffix(f.Repo, "/") + "/" + ref
+	}
+	ref = strings.TrimPrefix(ref, "oci://")
+	tags, err := regclient.Tags(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the tags of %s", ref)
+	}
+	return tags, nil
+}
+
+// newChartUpdates finds the newer patch, minor and major versions than the
+// version rendered for spec, among versions (sorted newest first) allowed by
+// constraint
+func newChartUpdates(spec, constraint string, versions []*semver.Version) (*ChartUpdates, error) {
+	var allowed *semver.Constraints
+	if constraint != "" {
+		c, err := semver.NewConstraint(constraint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version constraint %q", constraint)
+		}
+		allowed = c
+	}
+
+	current, err := currentVersion(spec, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := &ChartUpdates{Current: current.Original()}
+	for _, version := range versions {
+		if !version.GreaterThan(current) {
+			break
+		}
+		if allowed != nil && !allowed.Check(version) {
+			continue
+		}
+		if updates.Major == "" {
+			updates.Major = version.Original()
+		}
+		if updates.Minor == "" && version.Major() == current.Major() {
+			updates.Minor = version.Original()
+		}
+		if updates.Patch == "" && version.Major() == current.Major() && version.Minor() == current.Minor() {
+			updates.Patch = version.Original()
+		}
+	}
+	return updates, nil
+}
+
+// currentVersion returns the version rendered for spec: the version itself
+// when it is exact, the latest matching version when it is a range
+func currentVersion(spec string, versions []*semver.Version) (*semver.Version, error) {
+	if isExactVersion(spec) {
+		return semver.NewVersion(spec)
+	}
+
+	if spec == "" {
+		spec = "*"
+	}
+	constraint, err := semver.NewConstraint(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid version %q", spec)
+	}
+	for _, version := range versions {
+		if constraint.Check(version) {
+			return version, nil
+		}
+	}
+	return nil, errors.Errorf("no version matches %q", spec)
+}
@@ -0,0 +1,155 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChartUpdates(t *testing.T) {
+	var versions []*semver.Version
+	for _, v := range []string{"2.1.0", "2.0.0", "1.3.1", "1.3.0", "1.2.5", "1.2.4", "1.2.3", "1.0.0"} {
+		versions = append(versions, semver.MustParse(v))
+	}
+
+	var tests = []struct {
+		name          string
+		version       string
+		constraint    string
+		expected      ChartUpdates
+		expectedError string
+	}{
+		{
+			name:     "exact-version",
+			version:  "1.2.3",
+			expected: ChartUpdates{Current: "1.2.3", Patch: "1.2.5", Minor: "1.3.1", Major: "2.1.0"},
+		},
+		{
+			name:     "version-range",
+			version:  "~1.2.0",
+			expected: ChartUpdates{Current: "1.2.5", Minor: "1.3.1", Major: "2.1.0"},
+		},
+		{
+			name:     "partial-version",
+			version:  "1.2",
+			expected: ChartUpdates{Current: "1.2.5", Minor: "1.3.1", Major: "2.1.0"},
+		},
+		{
+			name:     "latest",
+			version:  "2.1.0",
+			expected: ChartUpdates{Current: "2.1.0"},
+		},
+		{
+			name:     "no-version",
+			expected: ChartUpdates{Current: "2.1.0"},
+		},
+		{
+			name:       "constraint",
+			version:    "1.2.3",
+			constraint: "<2.0.0",
+			expected:   ChartUpdates{Current: "1.2.3", Patch: "1.2.5", Minor: "1.3.1", Major: "1.3.1"},
+		},
+		{
+			name:       "constraint-patch-only",
+			version:    "1.2.3",
+			constraint: "~1.2.3",
+			expected:   ChartUpdates{Current: "1.2.3", Patch: "1.2.5", Minor: "1.2.5", Major: "1.2.5"},
+		},
+		{
+			name:          "invalid-constraint",
+			version:       "1.2.3",
+			constraint:    "newest",
+			expectedError: "invalid version constraint",
+		},
+		{
+			name:          "no-matching-version",
+			version:       "~3.0.0",
+			expectedError: "no version matches",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updates, err := newChartUpdates(test.version, test.constraint, versions)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expected, *updates, test.name)
+			assert.Equal(t, test.expected.Patch != "" || test.expected.Major != "", updates.Outdated(), "outdated")
+		})
+	}
+}
+
+func TestKonvertChartUpdates(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0", "0.1.1", "0.2.0", "1.0.0", "2.0.0-rc.1")
+
+	var fn KonvertFunction
+	fn.Repo = chartRepo.URL
+	fn.Chart = "local-chart"
+	fn.Version = "0.1.0"
+	updates, err := fn.ChartUpdates()
+	require.NoError(t, err, "ChartUpdates")
+	assert.Equal(t, ChartUpdates{Current: "0.1.0", Patch: "0.1.1", Minor: "0.2.0", Major: "1.0.0"}, *updates, "pre-releases are ignored")
+
+	fn.VersionConstraint = "<1.0.0"
+	updates, err = fn.ChartUpdates()
+	require.NoError(t, err, "ChartUpdates")
+	assert.Equal(t, "0.2.0", updates.Major, "constraint")
+
+	// the cached index is used offline
+	chartRepo.Close()
+	fn.SetOptions(Options{Offline: true})
+	_, err = fn.ChartUpdates()
+	require.NoError(t, err, "offline")
+
+	fn.Chart = "missing-chart"
+	_, err = fn.ChartUpdates()
+	require.NotNil(t, err, "missing chart")
+	assert.Contains(t, err.Error(), "not found", "missing chart")
+
+	fn.Repo = ""
+	fn.Chart = "./local-chart"
+	updates, err = fn.ChartUpdates()
+	require.NoError(t, err, "local chart")
+	assert.Nil(t, updates, "local chart")
+}
+
+func TestKonvertChartUpdatesWithRegistry(t *testing.T) {
+	registry := newTestOCIRegistry(t, false, "", "", "0.1.0", "0.1.2", "0.3.0")
+
+	var tests = []struct {
+		name  string
+		repo  string
+		chart string
+	}{
+		{
+			name:  "repo",
+			repo:  "oci://" + registry.host() + "/charts",
+			chart: "local-chart",
+		},
+		{
+			name:  "chart-url",
+			chart: "oci://" + registry.host() + "/charts/local-chart",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fn KonvertFunction
+			fn.Repo = test.repo
+			fn.Chart = test.chart
+			fn.Version = "0.1.0"
+			fn.Registry = &RegistryAuth{PlainHTTP: true}
+
+			updates, err := fn.ChartUpdates()
+			require.NoError(t, err, test.name)
+			assert.Equal(t, ChartUpdates{Current: "0.1.0", Patch: "0.1.2", Minor: "0.3.0", Major: "0.3.0"}, *updates, test.name)
+		})
+	}
+}
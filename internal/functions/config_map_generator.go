@@ -0,0 +1,310 @@
+package functions
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// the keys of rendered ConfigMaps can be written as files of a
+// configMapGenerator so changes to embedded config (nginx.conf, my.cnf...) are
+// reviewed as plain files. Functions only produce resources, the files travel
+// through the pipeline as File resources that the writer of the package
+// writes as is, see IsFile.
+
+const (
+	fnFileKind            = "File"
+	annotationLocalConfig = "config.kubernetes.io/local-config"
+	// generatedFileSuffix is added to the name of the files that would be
+	// read back as part of the package
+	generatedFileSuffix = ".txt"
+)
+
+// IsFile returns true if item is a file to write as is at its path
+// annotation, see FileContent
+func IsFile(item *kyaml.RNode) bool {
+	return item.GetKind() == fnFileKind && item.GetApiVersion() == fnConfigAPIVersion
+}
+
+// FileContent returns the content of a file, see IsFile
+func FileContent(item *kyaml.RNode) ([]byte, error) {
+	if binaryData := item.Field("binaryData"); binaryData != nil {
+		content, err := base64.StdEncoding.DecodeString(binaryData.Value.YNode().Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode file %s", item.GetName())
+		}
+		return content, nil
+	}
+	if data := item.Field("data"); data != nil {
+		return []byte(data.Value.YNode().Value), nil
+	}
+	return nil, nil
+}
+
+func newFileNode(path string, content []byte, annotationName, annotationValue string) (*kyaml.RNode, error) {
+	node := kyaml.NewMapRNode(nil)
+	node.SetApiVersion(fnConfigAPIVersion)
+	node.SetKind(fnFileKind)
+	if err := node.SetName(filepath.ToSlash(path)); err != nil {
+		return nil, errors.Wrap(err, "unable to set file name")
+	}
+	field, value := "data", string(content)
+	if !utf8.Valid(content) {
+		field, value = "binaryData", base64.StdEncoding.EncodeToString(content)
+	}
+	if err := node.PipeE(kyaml.SetField(field, kyaml.NewStringRNode(value))); err != nil {
+		return nil, errors.Wrapf(err, "unable to set %s of file %s", field, path)
+	}
+	if err := setAnnotations(node, map[string]string{
+		annotationLocalConfig:  "true",
+		annotationName:         annotationValue,
+		kioutil.PathAnnotation: path,
+	}); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// generatedFileName returns the name of the file of a ConfigMap key, which
+// must not be read back as part of the package
+func generatedFileName(key string) string {
+	for _, glob := range kio.DefaultMatch {
+		if matched, _ := filepath.Match(glob, key); matched {
+			return key + generatedFileSuffix
+		}
+	}
+	return key
+}
+
+// validateFileKey rejects the ConfigMap keys that would not be written as a
+// file in the directory of the ConfigMap
+func validateFileKey(key string) error {
+	if key == "" || key == "." || strings.HasPrefix(key, "..") || strings.ContainsAny(key, `/\`) || path.Clean(key) != key {
+		return fmt.Errorf("key %q cannot be written as a file", key)
+	}
+	return nil
+}
+
+func isConfigMap(node *kyaml.RNode) bool {
+	return node.GetApiVersion() == "v1" && node.GetKind() == "ConfigMap"
+}
+
+// generateConfigMap returns the configMapGenerator entry of the ConfigMap
+// node and the files of its keys, written to a directory named after the file
+// of the ConfigMap
+func (f *KustomizerFunction) generateConfigMap(node *kyaml.RNode) (*kyaml.RNode, []*kyaml.RNode, error) {
+	path := node.GetAnnotations()[kioutil.PathAnnotation]
+	dir := strings.TrimSuffix(path, filepath.Ext(path))
+
+	content := make(map[string][]byte)
+	for _, field := range []string{"data", "binaryData"} {
+		values := node.Field(field)
+		if values == nil {
+			continue
+		}
+		err := values.Value.VisitFields(func(key *kyaml.MapNode) error {
+			value := key.Value.YNode().Value
+			if field == "binaryData" {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return errors.Wrapf(err, "unable to decode binaryData %s", key.Key.YNode().Value)
+				}
+				content[key.Key.YNode().Value] = decoded
+				return nil
+			}
+			content[key.Key.YNode().Value] = []byte(value)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "ConfigMap %s", node.GetName())
+		}
+	}
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := validateFileKey(key); err != nil {
+			return nil, nil, errors.Wrapf(err, "ConfigMap %s", node.GetName())
+		}
+	}
+
+	var (
+		files   []*kyaml.RNode
+		entries []string
+	)
+	for _, key := range keys {
+		filePath := filepath.Join(dir, generatedFileName(key))
+		file, err := newFileNode(filePath, content[key], f.ResourceAnnotationName, f.ResourceAnnotationValue)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		entry := f.resourcePath(filePath)
+		if filepath.Base(entry) != key {
+			entry = fmt.Sprintf("%s=%s", key, entry)
+		}
+		entries = append(entries, entry)
+	}
+
+	entry := kyaml.NewMapRNode(nil)
+	name := kyaml.NewScalarRNode(node.GetName())
+	name.YNode().LineComment = fmt.Sprintf("%s: %s", f.ResourceAnnotationName, f.ResourceAnnotationValue)
+	if err := entry.PipeE(kyaml.SetField("name", name)); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to set configMapGenerator name")
+	}
+	if namespace := node.GetNamespace(); namespace != "" {
+		if err := entry.PipeE(kyaml.SetField("namespace", kyaml.NewScalarRNode(namespace))); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to set configMapGenerator namespace")
+		}
+	}
+	if len(entries) > 0 {
+		if err := entry.PipeE(kyaml.SetField("files", kyaml.NewListRNode(entries...))); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to set configMapGenerator files")
+		}
+	}
+
+	// the name is kept as is, the workloads of the chart reference it
+	options := kyaml.NewMapRNode(nil)
+	if err := options.PipeE(kyaml.SetField("disableNameSuffixHash", newBoolRNode(true))); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to set configMapGenerator options")
+	}
+	if labels := node.GetLabels(); len(labels) > 0 {
+		if err := options.PipeE(kyaml.SetField("labels", newSortedMapRNode(labels))); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to set configMapGenerator labels")
+		}
+	}
+	annotations := make(map[string]string)
+	for key, value := range node.GetAnnotations() {
+		if !strings.HasPrefix(key, "config.kubernetes.io/") && !strings.HasPrefix(key, "internal.config.kubernetes.io/") {
+			annotations[key] = value
+		}
+	}
+	if len(annotations) > 0 {
+		if err := options.PipeE(kyaml.SetField("annotations", newSortedMapRNode(annotations))); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to set configMapGenerator annotations")
+		}
+	}
+	if immutable := node.Field("immutable"); immutable != nil && immutable.Value.YNode().Value == "true" {
+		if err := options.PipeE(kyaml.SetField("immutable", newBoolRNode(true))); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to set configMapGenerator immutable")
+		}
+	}
+	if err := entry.PipeE(kyaml.SetField("options", options)); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to set configMapGenerator options")
+	}
+
+	return entry, files, nil
+}
+
+// GeneratedFiles returns the files, relative to the package, of the
+// configMapGenerator entries written by konvert to the kustomizations of
+// nodes, so the files of a ConfigMap no longer rendered can be removed
+func GeneratedFiles(nodes []*kyaml.RNode) ([]string, error) {
+	var files []string
+	for _, node := range nodes {
+		if node.GetApiVersion() != fnKustomizeConfigAPIVersion || node.GetKind() != fnKustomizeConfigKind {
+			continue
+		}
+		generator := node.Field("configMapGenerator")
+		if generator == nil {
+			continue
+		}
+		elements, err := generator.Value.Elements()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read elements from kustomization configMapGenerator")
+		}
+		dir := filepath.Dir(node.GetAnnotations()[kioutil.PathAnnotation])
+		for _, element := range elements {
+			name := element.Field("name")
+			if name == nil || !strings.HasPrefix(strings.TrimPrefix(name.Value.YNode().LineComment, "# "), annotationKonvertChart+": ") {
+				continue
+			}
+			entries := element.Field("files")
+			if entries == nil {
+				continue
+			}
+			values, err := entries.Value.Elements()
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to read files of configMapGenerator %s", name.Value.YNode().Value)
+			}
+			for _, v := range values {
+				value := v.YNode().Value
+				if i := strings.Index(value, "="); i >= 0 {
+					value = value[i+1:]
+				}
+				files = append(files, filepath.Join(dir, value))
+			}
+		}
+	}
+	return files, nil
+}
+
+// newSortedMapRNode returns a map node with the keys of values in order, so
+// it is written the same way on every run
+func newSortedMapRNode(values map[string]string) *kyaml.RNode {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	node := kyaml.NewMapRNode(nil)
+	for _, key := range keys {
+		node.YNode().Content = append(node.YNode().Content, kyaml.NewStringRNode(key).YNode(), kyaml.NewStringRNode(values[key]).YNode())
+	}
+	return node
+}
+
+func newBoolRNode(value bool) *kyaml.RNode {
+	node := kyaml.NewScalarRNode(fmt.Sprint(value))
+	node.YNode().Tag = kyaml.NodeTagBool
+	return node
+}
+
+// kustomizeConfigMapGenerator replaces the configMapGenerator entries of the
+// resources annotated with ResourceAnnotationName=ResourceAnnotationValue
+func (f *KustomizerFunction) kustomizeConfigMapGenerator(kustnode *kyaml.RNode, generated []*kyaml.RNode) error {
+	resourceComment := fmt.Sprintf("%s: %s", f.ResourceAnnotationName, f.ResourceAnnotationValue)
+	var entries []*kyaml.Node
+	if generator := kustnode.Field("configMapGenerator"); generator != nil {
+		elements, err := generator.Value.Elements()
+		if err != nil {
+			return errors.Wrap(err, "unable to read elements from kustomization configMapGenerator")
+		}
+		for _, element := range elements {
+			if name := element.Field("name"); name != nil && strings.TrimPrefix(name.Value.YNode().LineComment, "# ") == resourceComment {
+				continue
+			}
+			entries = append(entries, element.YNode())
+		}
+	}
+	sort.SliceStable(generated, func(i, j int) bool {
+		return generated[i].Field("name").Value.YNode().Value < generated[j].Field("name").Value.YNode().Value
+	})
+	for _, entry := range generated {
+		entries = append(entries, entry.YNode())
+	}
+
+	if err := kustnode.PipeE(kyaml.Clear("configMapGenerator")); err != nil {
+		return errors.Wrap(err, "unable to clear configMapGenerator field")
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	generator := kyaml.NewListRNode()
+	generator.YNode().Content = entries
+	if err := kustnode.PipeE(kyaml.SetField("configMapGenerator", generator)); err != nil {
+		return errors.Wrap(err, "unable to set kustomization configMapGenerator")
+	}
+	return nil
+}
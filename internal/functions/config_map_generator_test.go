@@ -0,0 +1,260 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testConfigMapGeneratorInput = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mysql
+  namespace: db
+  labels:
+    app.kubernetes.io/name: mysql
+  annotations:
+    internal.config.kubernetes.io/path: 'upstream/configmap-mysql.yaml'
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+data:
+  my.cnf: |
+    [mysqld]
+    default_authentication_plugin=mysql_native_password
+  config.yaml: |
+    replicas: 1
+binaryData:
+  logo.png: iVBORw0KGgo=
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: mysql
+  annotations:
+    internal.config.kubernetes.io/path: 'upstream/service-mysql.yaml'
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+spec:
+  type: ClusterIP
+---
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  name: kustomization
+  annotations:
+    config.kubernetes.io/local-config: 'true'
+    internal.config.kubernetes.io/path: upstream/kustomization.yaml
+resources:
+- extra.yaml
+configMapGenerator:
+- name: extra
+  literals:
+  - key=value
+- name: previous # konvert.kumorilabs.io/chart: https://charts.bitnami.com/bitnami,mysql
+  files:
+  - previous/key
+`
+
+func TestKustomizerFilterConfigMapGenerator(t *testing.T) {
+	fn := KustomizerFunction{
+		Path:                    "upstream",
+		ResourceAnnotationName:  annotationKonvertChart,
+		ResourceAnnotationValue: "https://charts.bitnami.com/bitnami,mysql",
+		ConfigMapGenerator:      true,
+		standalone:              true,
+	}
+	input, err := kio.ParseAll(testConfigMapGeneratorInput)
+	require.NoError(t, err, "ParseAll")
+
+	output, err := fn.Filter(input)
+	require.NoError(t, err, "Filter")
+
+	files := make(map[string]string)
+	var kustomization *kyaml.RNode
+	for _, node := range output {
+		assert.NotEqual(t, "ConfigMap", node.GetKind(), "configmap is generated")
+		if IsFile(node) {
+			content, err := FileContent(node)
+			require.NoError(t, err, "FileContent")
+			files[node.GetAnnotations()[kioutil.PathAnnotation]] = string(content)
+			assert.Equal(t, "https://charts.bitnami.com/bitnami,mysql", node.GetAnnotations()[annotationKonvertChart], "file chart annotation")
+		}
+		if node.GetKind() == fnKustomizeConfigKind {
+			kustomization = node
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"upstream/configmap-mysql/my.cnf":          "[mysqld]\ndefault_authentication_plugin=mysql_native_password\n",
+		"upstream/configmap-mysql/config.yaml.txt": "replicas: 1\n",
+		"upstream/configmap-mysql/logo.png":        "\x89PNG\r\n\x1a\n",
+	}, files, "files")
+
+	require.NotNil(t, kustomization, "kustomization")
+	require.NoError(t, kustomization.PipeE(kyaml.ClearAnnotation(kioutil.IndexAnnotation)), "ClearAnnotation")
+	require.NoError(t, kustomization.PipeE(kyaml.ClearAnnotation(kioutil.LegacyIndexAnnotation)), "ClearAnnotation") //nolint:staticcheck
+	require.NoError(t, kustomization.PipeE(kyaml.ClearAnnotation(kioutil.LegacyPathAnnotation)), "ClearAnnotation")  //nolint:staticcheck
+	assert.Equal(t, `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  name: kustomization
+  annotations:
+    config.kubernetes.io/local-config: 'true'
+    internal.config.kubernetes.io/path: upstream/kustomization.yaml
+resources:
+- extra.yaml
+- service-mysql.yaml # konvert.kumorilabs.io/chart: https://charts.bitnami.com/bitnami,mysql
+configMapGenerator:
+- name: extra
+  literals:
+  - key=value
+- name: mysql # konvert.kumorilabs.io/chart: https://charts.bitnami.com/bitnami,mysql
+  namespace: db
+  files:
+  - config.yaml=configmap-mysql/config.yaml.txt
+  - configmap-mysql/logo.png
+  - configmap-mysql/my.cnf
+  options:
+    disableNameSuffixHash: true
+    labels:
+      app.kubernetes.io/name: mysql
+    annotations:
+      konvert.kumorilabs.io/chart: https://charts.bitnami.com/bitnami,mysql
+`, kustomization.MustString(), "kustomization")
+
+	// the entries of the chart are removed when the option is turned off
+	fn.ConfigMapGenerator = false
+	output, err = fn.Filter(output)
+	require.NoError(t, err, "Filter")
+	generator, err := kustomization.Pipe(kyaml.Lookup("configMapGenerator"))
+	require.NoError(t, err, "Lookup")
+	names, err := generator.ElementValues("name")
+	require.NoError(t, err, "ElementValues")
+	assert.Equal(t, []string{"extra"}, names, "configMapGenerator")
+}
+
+func TestKustomizerFilterConfigMapGeneratorKeys(t *testing.T) {
+	var tests = []struct {
+		name          string
+		key           string
+		expectedError string
+	}{
+		{name: "file", key: "nginx.conf"},
+		{name: "hidden", key: ".env"},
+		{name: "dot", key: ".", expectedError: `ConfigMap web: key "." cannot be written as a file`},
+		{name: "dot-dot", key: "..", expectedError: `ConfigMap web: key ".." cannot be written as a file`},
+		{name: "dot-dot-prefix", key: "..data", expectedError: `ConfigMap web: key "..data" cannot be written as a file`},
+		{name: "parent", key: "../web.yaml", expectedError: `ConfigMap web: key "../web.yaml" cannot be written as a file`},
+		{name: "unclean", key: "./web.conf", expectedError: `ConfigMap web: key "./web.conf" cannot be written as a file`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := KustomizerFunction{
+				Path:                    "upstream",
+				ResourceAnnotationName:  annotationKonvertChart,
+				ResourceAnnotationValue: "web",
+				ConfigMapGenerator:      true,
+				standalone:              true,
+			}
+			input, err := kio.ParseAll(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  annotations:
+    internal.config.kubernetes.io/path: 'upstream/configmap-web.yaml'
+    konvert.kumorilabs.io/chart: web
+data:
+  ` + test.key + `: value
+`)
+			require.NoError(t, err, "ParseAll")
+
+			output, err := fn.Filter(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			var files []string
+			for _, node := range output {
+				if IsFile(node) {
+					files = append(files, node.GetAnnotations()[kioutil.PathAnnotation])
+				}
+			}
+			assert.Equal(t, []string{"upstream/configmap-web/" + test.key}, files, test.name)
+		})
+	}
+}
+
+func TestGeneratedFileName(t *testing.T) {
+	assert.Equal(t, "nginx.conf", generatedFileName("nginx.conf"), "nginx.conf")
+	assert.Equal(t, "values.yaml.txt", generatedFileName("values.yaml"), "yaml")
+	assert.Equal(t, "rules.yml.txt", generatedFileName("rules.yml"), "yml")
+}
+
+func TestKonvertConfigMapGeneratorConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		filePath      string
+		spec          string
+		expectedError string
+	}{
+		{name: "kustomize", filePath: "./examples/konvert.yaml", spec: "  kustomize: true\n  configMapGenerator: true\n"},
+		{name: "without-kustomize", filePath: "./examples/konvert.yaml", spec: "  configMapGenerator: true\n", expectedError: "spec.configMapGenerator requires spec.kustomize"},
+		{name: "function", spec: "  kustomize: true\n  configMapGenerator: true\n", expectedError: "spec.configMapGenerator is not supported when konvert runs as a function"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: ./local-chart
+  kubeVersion: "1.27"
+` + test.spec)
+			require.NoError(t, err, "Parse")
+			err = Konvert(test.filePath).Config(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+		})
+	}
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	nodes, err := kio.ParseAll(`apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  annotations:
+    internal.config.kubernetes.io/path: upstream/kustomization.yaml
+configMapGenerator:
+- name: mysql # konvert.kumorilabs.io/chart: https://charts.bitnami.com/bitnami,mysql
+  files:
+  - configmap-mysql/my.cnf
+  - values.yaml=configmap-mysql/values.yaml.txt
+- name: custom
+  files:
+  - custom/app.conf
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+  annotations:
+    internal.config.kubernetes.io/path: upstream/configmap-other.yaml
+`)
+	require.NoError(t, err, "ParseAll")
+	files, err := GeneratedFiles(nodes)
+	require.NoError(t, err, "GeneratedFiles")
+	assert.Equal(t, []string{
+		"upstream/configmap-mysql/my.cnf",
+		"upstream/configmap-mysql/values.yaml.txt",
+	}, files, "konvert entries only")
+}
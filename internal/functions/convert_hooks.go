@@ -0,0 +1,487 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	fnConvertHooksName = "convert-hooks"
+	fnConvertHooksKind = "ConvertHooks"
+
+	HooksModeKeep   = "keep"
+	HooksModeDrop   = "drop"
+	HooksModeArgoCD = "argocd"
+	HooksModeFlux   = "flux"
+	HooksModeKapp   = "kapp"
+
+	annotationHelmHook             = "helm.sh/hook"
+	annotationHelmHookWeight       = "helm.sh/hook-weight"
+	annotationHelmHookDeletePolicy = "helm.sh/hook-delete-policy"
+
+	annotationArgoCDHook             = "argocd.argoproj.io/hook"
+	annotationArgoCDHookDeletePolicy = "argocd.argoproj.io/hook-delete-policy"
+	annotationArgoCDSyncWave         = "argocd.argoproj.io/sync-wave"
+
+	annotationFluxForce = "kustomize.toolkit.fluxcd.io/force"
+
+	annotationKappChangeGroup    = "kapp.k14s.io/change-group"
+	annotationKappChangeRule     = "kapp.k14s.io/change-rule"
+	annotationKappUpdateStrategy = "kapp.k14s.io/update-strategy"
+	annotationKappNonce          = "kapp.k14s.io/nonce"
+
+	// hooksDirectory is the directory of the flux hook stages, relative to
+	// the path of the chart
+	hooksDirectory = "hooks"
+	phasePre       = "pre-hooks"
+	phasePost      = "post-hooks"
+	kappGroupApp   = "resources"
+)
+
+var hooksModes = []string{HooksModeKeep, HooksModeDrop, HooksModeArgoCD, HooksModeFlux, HooksModeKapp}
+
+var argoCDHookEvents = map[string]string{
+	"pre-install":  "PreSync",
+	"pre-upgrade":  "PreSync",
+	"post-install": "PostSync",
+	"post-upgrade": "PostSync",
+	"post-delete":  "PostDelete",
+}
+
+var argoCDHookDeletePolicies = map[string]string{
+	"before-hook-creation": "BeforeHookCreation",
+	"hook-succeeded":       "HookSucceeded",
+	"hook-failed":          "HookFailed",
+}
+
+// Hooks configures how the Helm hooks of a chart are written
+type Hooks struct {
+	// Mode is one of keep (default), drop, argocd, flux or kapp
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+func validateHooksMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, m := range hooksModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid hooks mode %q, must be one of %s", mode, strings.Join(hooksModes, ", "))
+}
+
+type ConvertHooksProcessor struct{}
+
+func (p *ConvertHooksProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&ConvertHooksFunction{}, resourceList)
+}
+
+// ConvertHooksFunction translates the Helm hooks inlined by
+// RenderHelmChartFunction into the ordering primitives of a GitOps tool, since
+// the helm.sh/hook annotations mean nothing once the chart is applied as plain
+// manifests
+type ConvertHooksFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+	Mode               string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Path is the directory of the rendered resources, the flux stages are
+	// written under its hooks directory
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// ReleaseName scopes the kapp change groups
+	ReleaseName string `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
+	// results reports the hooks dropped by the last Filter
+	results framework.Results
+}
+
+func (f *ConvertHooksFunction) Name() string {
+	return fnConvertHooksName
+}
+
+func (f *ConvertHooksFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *ConvertHooksFunction) Config(rn *kyaml.RNode) error {
+	if err := loadConfig(f, rn, fnConvertHooksKind); err != nil {
+		return err
+	}
+	return validateHooksMode(f.Mode)
+}
+
+// Results reports the hooks dropped by the last Filter
+func (f *ConvertHooksFunction) Results() framework.Results {
+	return f.results
+}
+
+// helmHook is a resource rendered from a Helm hook
+type helmHook struct {
+	node           *kyaml.RNode
+	events         []string
+	weight         int
+	deletePolicies []string
+}
+
+func (h helmHook) hasEvent(events ...string) bool {
+	for _, e := range h.events {
+		for _, event := range events {
+			if e == event {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// phase returns when a GitOps tool applies the hook: pre-hooks before the
+// other resources of the chart, post-hooks after them. Hooks running both
+// before and after only need to exist before, they are never deleted.
+func (h helmHook) phase() string {
+	switch {
+	case h.hasEvent("pre-install", "pre-upgrade"):
+		return phasePre
+	case h.hasEvent("post-install", "post-upgrade"):
+		return phasePost
+	}
+	return ""
+}
+
+func (h helmHook) stage() string {
+	return hookStage(h.phase(), h.weight)
+}
+
+func hookStage(phase string, weight int) string {
+	if weight == 0 {
+		return phase
+	}
+	return fmt.Sprintf("%s.%d", phase, weight)
+}
+
+func parseHelmHook(node *kyaml.RNode) (*helmHook, error) {
+	annotations := node.GetAnnotations()
+	events, ok := annotations[annotationHelmHook]
+	if !ok {
+		return nil, nil
+	}
+	hook := &helmHook{
+		node:           node,
+		events:         splitAnnotationList(events),
+		deletePolicies: splitAnnotationList(annotations[annotationHelmHookDeletePolicy]),
+	}
+	if weight, ok := annotations[annotationHelmHookWeight]; ok {
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil {
+			return nil, errors.Errorf("invalid %s %q of %s %s", annotationHelmHookWeight, weight, node.GetKind(), node.GetName())
+		}
+		hook.weight = w
+	}
+	return hook, nil
+}
+
+func splitAnnotationList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (f *ConvertHooksFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.results = nil
+	if err := validateHooksMode(f.Mode); err != nil {
+		return items, err
+	}
+	if f.Mode == "" || f.Mode == HooksModeKeep {
+		return items, nil
+	}
+
+	var (
+		kept      []*kyaml.RNode
+		resources []*kyaml.RNode
+		hooks     []*helmHook
+	)
+	for _, item := range items {
+		hook, err := parseHelmHook(item)
+		if err != nil {
+			return items, err
+		}
+		if hook == nil {
+			kept = append(kept, item)
+			resources = append(resources, item)
+			continue
+		}
+		if reason := f.dropReason(hook); reason != "" {
+			f.drop(hook, reason)
+			continue
+		}
+		kept = append(kept, item)
+		hooks = append(hooks, hook)
+	}
+
+	for _, hook := range hooks {
+		for _, key := range []string{annotationHelmHook, annotationHelmHookWeight, annotationHelmHookDeletePolicy} {
+			if _, err := hook.node.Pipe(kyaml.ClearAnnotation(key)); err != nil {
+				return items, errors.Wrapf(err, "unable to clear %s", key)
+			}
+		}
+	}
+
+	var err error
+	switch f.Mode {
+	case HooksModeArgoCD:
+		err = f.argoCD(hooks)
+	case HooksModeFlux:
+		err = f.flux(hooks)
+	case HooksModeKapp:
+		err = f.kapp(hooks, resources)
+	}
+	if err != nil {
+		return items, err
+	}
+	return kept, nil
+}
+
+// dropReason returns why hook is dropped, or an empty string when it is
+// converted
+func (f *ConvertHooksFunction) dropReason(hook *helmHook) string {
+	if f.Mode == HooksModeDrop {
+		return "dropped, helm hooks are dropped by spec.hooks"
+	}
+	if f.Mode == HooksModeArgoCD {
+		for _, event := range hook.events {
+			if _, ok := argoCDHookEvents[event]; ok {
+				return ""
+			}
+		}
+	} else if hook.phase() != "" {
+		return ""
+	}
+	return fmt.Sprintf("dropped, helm %s hook has no %s equivalent", strings.Join(hook.events, ","), f.Mode)
+}
+
+func (f *ConvertHooksFunction) drop(hook *helmHook, reason string) {
+	log.WithFields(log.Fields{
+		"kind": hook.node.GetKind(),
+		"name": hook.node.GetName(),
+	}).Info(reason)
+	severity := framework.Warning
+	if f.Mode == HooksModeDrop || hook.hasEvent("test", "test-success") {
+		// tests are kept unless skipTests, they are dropped on purpose
+		severity = framework.Info
+	}
+	f.results = append(f.results, resourceResult(hook.node, severity, reason))
+}
+
+func (f *ConvertHooksFunction) argoCD(hooks []*helmHook) error {
+	for _, hook := range hooks {
+		var types []string
+		for _, event := range hook.events {
+			if t, ok := argoCDHookEvents[event]; ok && !slices.Contains(types, t) {
+				types = append(types, t)
+			}
+		}
+		var policies []string
+		for _, policy := range hook.deletePolicies {
+			if p, ok := argoCDHookDeletePolicies[policy]; ok {
+				policies = append(policies, p)
+			}
+		}
+		annotations := map[string]string{
+			annotationArgoCDHook: strings.Join(types, ","),
+		}
+		if len(policies) > 0 {
+			annotations[annotationArgoCDHookDeletePolicy] = strings.Join(policies, ",")
+		}
+		if hook.weight != 0 {
+			annotations[annotationArgoCDSyncWave] = strconv.Itoa(hook.weight)
+		}
+		if err := setAnnotations(hook.node, annotations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flux moves every hook stage to its own directory under the hooks directory
+// of Path, to be applied by its own Flux Kustomization depending on the
+// previous stage
+func (f *ConvertHooksFunction) flux(hooks []*helmHook) error {
+	stages := fluxStageDirectories(hooks)
+	for _, hook := range hooks {
+		path := hook.node.GetAnnotations()[kioutil.PathAnnotation]
+		if path == "" {
+			return errors.Errorf("%s %s has no path", hook.node.GetKind(), hook.node.GetName())
+		}
+		stageDir := filepath.Join(normalizePath(f.Path), hooksDirectory, stages[hook.stage()])
+		annotations := map[string]string{
+			kioutil.PathAnnotation: filepath.Join(stageDir, relativePath(f.Path, path)),
+		}
+		if slices.Contains(hook.deletePolicies, "before-hook-creation") {
+			// hooks are recreated on every release, jobs are immutable
+			annotations[annotationFluxForce] = "enabled"
+		}
+		if err := setAnnotations(hook.node, annotations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kapp assigns every hook stage and the other resources of the chart to change
+// groups, each group upserted after the previous one
+func (f *ConvertHooksFunction) kapp(hooks []*helmHook, resources []*kyaml.RNode) error {
+	pre, post := hookStages(hooks, phasePre), hookStages(hooks, phasePost)
+	groups := append(append(pre, kappGroupApp), post...)
+	after := make(map[string]string, len(groups))
+	for i := 1; i < len(groups); i++ {
+		after[groups[i]] = f.kappGroup(groups[i-1])
+	}
+	rule := func(group string) map[string]string {
+		annotations := map[string]string{annotationKappChangeGroup: f.kappGroup(group)}
+		if previous, ok := after[group]; ok {
+			annotations[annotationKappChangeRule] = "upsert after upserting " + previous
+		}
+		return annotations
+	}
+
+	if len(hooks) > 0 {
+		for _, node := range resources {
+			if err := setAnnotations(node, rule(kappGroupApp)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, hook := range hooks {
+		annotations := rule(hook.stage())
+		if slices.Contains(hook.deletePolicies, "before-hook-creation") {
+			// hooks are recreated on every release, jobs are immutable
+			annotations[annotationKappUpdateStrategy] = "always-replace"
+		}
+		if hook.hasEvent("pre-upgrade", "post-upgrade") && (hook.node.GetKind() == "Job" || hook.node.GetKind() == "Pod") {
+			// run again on every deploy like helm runs it on every upgrade
+			annotations[annotationKappNonce] = ""
+		}
+		if err := setAnnotations(hook.node, annotations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *ConvertHooksFunction) kappGroup(group string) string {
+	if f.ReleaseName == "" {
+		return "konvert.kumorilabs.io/" + group
+	}
+	return fmt.Sprintf("konvert.kumorilabs.io/%s.%s", f.ReleaseName, group)
+}
+
+// hookStages returns the stages of phase ordered by weight
+func hookStages(hooks []*helmHook, phase string) []string {
+	var weights []int
+	for _, hook := range hooks {
+		if hook.phase() == phase && !slices.Contains(weights, hook.weight) {
+			weights = append(weights, hook.weight)
+		}
+	}
+	sort.Ints(weights)
+	var stages []string
+	for _, weight := range weights {
+		stages = append(stages, hookStage(phase, weight))
+	}
+	return stages
+}
+
+// fluxStageDirectories returns the directory name of every stage of hooks,
+// the phase followed by the zero-padded index of the stage in the phase, so
+// that the directories sort in the order of the weights, negative included
+func fluxStageDirectories(hooks []*helmHook) map[string]string {
+	dirs := make(map[string]string)
+	for _, phase := range []string{phasePre, phasePost} {
+		stages := hookStages(hooks, phase)
+		width := len(strconv.Itoa(len(stages) - 1))
+		for i, stage := range stages {
+			dirs[stage] = fmt.Sprintf("%s.%0*d", phase, width, i)
+		}
+	}
+	return dirs
+}
+
+// hookStageDirectories returns the flux stage directories of the resources
+// rendered under path, ordered by name
+func hookStageDirectories(nodes []*kyaml.RNode, path string) []string {
+	var dirs []string
+	for _, node := range nodes {
+		if dir := hookStageDirectory(node, path); dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// hookStageDirectory returns the flux stage directory of node, empty when it
+// is not written to the hooks directory of path
+func hookStageDirectory(node *kyaml.RNode, path string) string {
+	hooksDir := filepath.Join(normalizePath(path), hooksDirectory)
+	rel, err := filepath.Rel(hooksDir, node.GetAnnotations()[kioutil.PathAnnotation])
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") || !strings.Contains(rel, "/") {
+		return ""
+	}
+	return filepath.Join(hooksDir, strings.SplitN(rel, "/", 2)[0])
+}
+
+// hooksMode returns the mode of the hooks of the chart
+func (f *KonvertFunction) hooksMode() string {
+	if f.Hooks == nil {
+		return ""
+	}
+	return f.Hooks.Mode
+}
+
+// kustomizeHookStages writes a kustomization for every flux hook stage of the
+// chart, each one applied by its own Flux Kustomization
+func (f *KonvertFunction) kustomizeHookStages(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	if f.hooksMode() != HooksModeFlux {
+		return nodes, nil
+	}
+	for _, dir := range hookStageDirectories(chartResources(nodes, f.Repo, f.Chart), f.Path) {
+		dir := dir
+		kustomizer := KustomizerFunction{
+			Path:                    dir,
+			Namespace:               f.Namespace,
+			ResourceAnnotationName:  annotationKonvertChart,
+			ResourceAnnotationValue: konvertChartAnnotationValue(f.Repo, f.Chart),
+			selects: func(node *kyaml.RNode) bool {
+				return hookStageDirectory(node, f.Path) == dir
+			},
+			standalone: true,
+		}
+		var err error
+		nodes, err = kustomizer.Filter(nodes)
+		if err != nil {
+			return nodes, errors.Wrapf(err, "unable to run kustomizer function for %s", dir)
+		}
+	}
+	return nodes, nil
+}
+
+func setAnnotations(node *kyaml.RNode, annotations map[string]string) error {
+	for _, key := range sortedKeys(annotations) {
+		if _, err := node.Pipe(kyaml.SetAnnotation(key, annotations[key])); err != nil {
+			return errors.Wrapf(err, "unable to set %s on %s %s", key, node.GetKind(), node.GetName())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// testIngressNginxHooks parses the ingress-nginx fixture, whose admission
+// webhook resources are helm hooks, as written by SetPathAnnotationFunction
+func testIngressNginxHooks(t *testing.T) []*kyaml.RNode {
+	t.Helper()
+	data, err := os.ReadFile("./fixtures/render_helm_chart/ingress-nginx-4.0.16/fixture.yaml")
+	require.NoError(t, err, "ReadFile")
+	nodes, err := kio.ParseAll(string(data))
+	require.NoError(t, err, "ParseAll")
+	fn := SetPathAnnotationFunction{Path: "upstream"}
+	nodes, err = fn.Filter(nodes)
+	require.NoError(t, err, "Filter")
+	return nodes
+}
+
+// testResource returns the resource of kind and name
+func testResource(t *testing.T, nodes []*kyaml.RNode, kind, name string) *kyaml.RNode {
+	t.Helper()
+	for _, node := range nodes {
+		if node.GetKind() == kind && node.GetName() == name {
+			return node
+		}
+	}
+	require.Failf(t, "resource not found", "%s %s", kind, name)
+	return nil
+}
+
+func TestConvertHooksFunctionConfig(t *testing.T) {
+	input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: ConvertHooks
+metadata:
+  name: fnconfig
+spec:
+  mode: argocd
+  path: upstream
+`)
+	require.NoError(t, err, "Parse")
+
+	var fn ConvertHooksFunction
+	require.NoError(t, fn.Config(input), "Config")
+	assert.Equal(t, HooksModeArgoCD, fn.Mode, "mode")
+	assert.Equal(t, "upstream", fn.Path, "path")
+
+	input, err = kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: ConvertHooks
+metadata:
+  name: fnconfig
+spec:
+  mode: helmfile
+`)
+	require.NoError(t, err, "Parse")
+	err = (&ConvertHooksFunction{}).Config(input)
+	require.NotNil(t, err, "invalid mode")
+	assert.Contains(t, err.Error(), `invalid hooks mode "helmfile"`, "invalid mode")
+}
+
+func TestConvertHooksFilter(t *testing.T) {
+	var tests = []struct {
+		name string
+		mode string
+		// expected maps kind/name to the expected annotations, without the
+		// annotations of kio
+		expected map[string]map[string]string
+		paths    map[string]string
+		dropped  []string
+	}{
+		{
+			name: "keep",
+			mode: HooksModeKeep,
+			expected: map[string]map[string]string{
+				"Job/ingress-nginx-admission-create": {
+					annotationHelmHook:             "pre-install,pre-upgrade",
+					annotationHelmHookDeletePolicy: "before-hook-creation,hook-succeeded",
+				},
+				"Deployment/ingress-nginx-controller": nil,
+			},
+		},
+		{
+			name: "drop",
+			mode: HooksModeDrop,
+			expected: map[string]map[string]string{
+				"Deployment/ingress-nginx-controller": nil,
+			},
+			dropped: []string{
+				"ServiceAccount/ingress-nginx-admission",
+				"ClusterRole/ingress-nginx-admission",
+				"ClusterRoleBinding/ingress-nginx-admission",
+				"Role/ingress-nginx-admission",
+				"RoleBinding/ingress-nginx-admission",
+				"Job/ingress-nginx-admission-create",
+				"Job/ingress-nginx-admission-patch",
+			},
+		},
+		{
+			name: "argocd",
+			mode: HooksModeArgoCD,
+			expected: map[string]map[string]string{
+				"ClusterRole/ingress-nginx-admission": {
+					annotationArgoCDHook:             "PreSync,PostSync",
+					annotationArgoCDHookDeletePolicy: "BeforeHookCreation,HookSucceeded",
+				},
+				"Job/ingress-nginx-admission-create": {
+					annotationArgoCDHook:             "PreSync",
+					annotationArgoCDHookDeletePolicy: "BeforeHookCreation,HookSucceeded",
+				},
+				"Job/ingress-nginx-admission-patch": {
+					annotationArgoCDHook:             "PostSync",
+					annotationArgoCDHookDeletePolicy: "BeforeHookCreation,HookSucceeded",
+				},
+				"Deployment/ingress-nginx-controller": nil,
+			},
+		},
+		{
+			name: "flux",
+			mode: HooksModeFlux,
+			expected: map[string]map[string]string{
+				"ClusterRole/ingress-nginx-admission": {
+					annotationFluxForce: "enabled",
+				},
+				"Job/ingress-nginx-admission-patch": {
+					annotationFluxForce: "enabled",
+				},
+				"Deployment/ingress-nginx-controller": nil,
+			},
+			paths: map[string]string{
+				"ClusterRole/ingress-nginx-admission":    "upstream/hooks/pre-hooks.0/clusterrole-ingress-nginx-admission.yaml",
+				"Job/ingress-nginx-admission-create":     "upstream/hooks/pre-hooks.0/job-ingress-nginx-admission-create.yaml",
+				"Job/ingress-nginx-admission-patch":      "upstream/hooks/post-hooks.0/job-ingress-nginx-admission-patch.yaml",
+				"Deployment/ingress-nginx-controller":    "upstream/deployment-ingress-nginx-controller.yaml",
+				"ServiceAccount/ingress-nginx-admission": "upstream/hooks/pre-hooks.0/serviceaccount-ingress-nginx-admission.yaml",
+			},
+		},
+		{
+			name: "kapp",
+			mode: HooksModeKapp,
+			expected: map[string]map[string]string{
+				"ClusterRole/ingress-nginx-admission": {
+					annotationKappChangeGroup:    "konvert.kumorilabs.io/ingress-nginx.pre-hooks",
+					annotationKappUpdateStrategy: "always-replace",
+				},
+				"Job/ingress-nginx-admission-create": {
+					annotationKappChangeGroup:    "konvert.kumorilabs.io/ingress-nginx.pre-hooks",
+					annotationKappUpdateStrategy: "always-replace",
+					annotationKappNonce:          "",
+				},
+				"Job/ingress-nginx-admission-patch": {
+					annotationKappChangeGroup:    "konvert.kumorilabs.io/ingress-nginx.post-hooks",
+					annotationKappChangeRule:     "upsert after upserting konvert.kumorilabs.io/ingress-nginx.resources",
+					annotationKappUpdateStrategy: "always-replace",
+					annotationKappNonce:          "",
+				},
+				"Deployment/ingress-nginx-controller": {
+					annotationKappChangeGroup: "konvert.kumorilabs.io/ingress-nginx.resources",
+					annotationKappChangeRule:  "upsert after upserting konvert.kumorilabs.io/ingress-nginx.pre-hooks",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := ConvertHooksFunction{Mode: test.mode, Path: "upstream", ReleaseName: "ingress-nginx"}
+			input := testIngressNginxHooks(t)
+			output, err := fn.Filter(input)
+			require.NoError(t, err, test.name)
+
+			for resource, annotations := range test.expected {
+				kind, name := splitTestResource(resource)
+				actual := testResource(t, output, kind, name).GetAnnotations()
+				for key := range actual {
+					if strings.HasPrefix(key, "config.kubernetes.io/") || strings.HasPrefix(key, "internal.config.kubernetes.io/") {
+						delete(actual, key)
+					}
+				}
+				if len(annotations) == 0 {
+					assert.Empty(t, actual, resource)
+					continue
+				}
+				assert.Equal(t, annotations, actual, resource)
+			}
+			for resource, path := range test.paths {
+				kind, name := splitTestResource(resource)
+				assert.Equal(t, path, testResource(t, output, kind, name).GetAnnotations()[kioutil.PathAnnotation], resource)
+			}
+
+			var dropped []string
+			for _, result := range fn.Results() {
+				assert.Equal(t, framework.Info, result.Severity, test.name)
+				dropped = append(dropped, result.ResourceRef.Kind+"/"+result.ResourceRef.Name)
+			}
+			assert.Equal(t, test.dropped, dropped, test.name)
+			assert.Len(t, output, len(input)-len(test.dropped), test.name)
+		})
+	}
+}
+
+func splitTestResource(resource string) (string, string) {
+	kind, name := filepath.Split(resource)
+	return kind[:len(kind)-1], name
+}
+
+func TestConvertHooksFilterWeights(t *testing.T) {
+	input, err := kio.ParseAll(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-weight: "5"
+    internal.config.kubernetes.io/path: job-migrate.yaml
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+    helm.sh/hook-weight: "-5"
+    internal.config.kubernetes.io/path: secret-migrate.yaml
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-test
+  annotations:
+    helm.sh/hook: test
+    internal.config.kubernetes.io/path: pod-smoke-test.yaml
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: cleanup
+  annotations:
+    helm.sh/hook: pre-delete
+    internal.config.kubernetes.io/path: job-cleanup.yaml
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    internal.config.kubernetes.io/path: deployment-app.yaml
+`)
+	require.NoError(t, err, "ParseAll")
+
+	argocd := ConvertHooksFunction{Mode: HooksModeArgoCD}
+	output, err := argocd.Filter(input)
+	require.NoError(t, err, "argocd")
+	assert.Equal(t, "5", testResource(t, output, "Job", "migrate").GetAnnotations()[annotationArgoCDSyncWave], "sync wave")
+	assert.Equal(t, "-5", testResource(t, output, "Secret", "migrate").GetAnnotations()[annotationArgoCDSyncWave], "sync wave")
+	require.Len(t, argocd.Results(), 2, "argocd results")
+	assert.Equal(t, framework.Info, argocd.Results()[0].Severity, "test hook")
+	assert.Equal(t, "dropped, helm test hook has no argocd equivalent", argocd.Results()[0].Message, "test hook")
+	assert.Equal(t, framework.Warning, argocd.Results()[1].Severity, "delete hook")
+	assert.Equal(t, "dropped, helm pre-delete hook has no argocd equivalent", argocd.Results()[1].Message, "delete hook")
+
+	hooks := `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-weight: "5"
+    internal.config.kubernetes.io/path: job-migrate.yaml
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+    helm.sh/hook-weight: "-5"
+    internal.config.kubernetes.io/path: secret-migrate.yaml
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    internal.config.kubernetes.io/path: deployment-app.yaml
+`
+	input, err = kio.ParseAll(hooks)
+	require.NoError(t, err, "ParseAll")
+	kapp := ConvertHooksFunction{Mode: HooksModeKapp}
+	output, err = kapp.Filter(input)
+	require.NoError(t, err, "kapp")
+	secret := testResource(t, output, "Secret", "migrate").GetAnnotations()
+	assert.Equal(t, "konvert.kumorilabs.io/pre-hooks.-5", secret[annotationKappChangeGroup], "first stage")
+	assert.NotContains(t, secret, annotationKappChangeRule, "first stage")
+	job := testResource(t, output, "Job", "migrate").GetAnnotations()
+	assert.Equal(t, "konvert.kumorilabs.io/pre-hooks.5", job[annotationKappChangeGroup], "second stage")
+	assert.Equal(t, "upsert after upserting konvert.kumorilabs.io/pre-hooks.-5", job[annotationKappChangeRule], "second stage")
+	deployment := testResource(t, output, "Deployment", "app").GetAnnotations()
+	assert.Equal(t, "upsert after upserting konvert.kumorilabs.io/pre-hooks.5", deployment[annotationKappChangeRule], "resources")
+
+	input, err = kio.ParseAll(hooks)
+	require.NoError(t, err, "ParseAll")
+	flux := ConvertHooksFunction{Mode: HooksModeFlux}
+	output, err = flux.Filter(input)
+	require.NoError(t, err, "flux")
+	assert.Equal(t, []string{"hooks/pre-hooks.0", "hooks/pre-hooks.1"}, hookStageDirectories(output, "."), "flux stages")
+}
+
+func TestFluxStageDirectories(t *testing.T) {
+	hooks := func(weights ...int) []*helmHook {
+		var hooks []*helmHook
+		for _, weight := range weights {
+			hooks = append(hooks, &helmHook{events: []string{"pre-install"}, weight: weight})
+		}
+		return append(hooks, &helmHook{events: []string{"post-install"}, weight: 3})
+	}
+	tests := []struct {
+		name     string
+		hooks    []*helmHook
+		expected map[string]string
+	}{
+		{
+			name:  "unweighted",
+			hooks: hooks(0),
+			expected: map[string]string{
+				"pre-hooks":    "pre-hooks.0",
+				"post-hooks.3": "post-hooks.0",
+			},
+		},
+		{
+			name:  "negative-and-multi-digit",
+			hooks: hooks(10, 5, -5, -10, 0, 5),
+			expected: map[string]string{
+				"pre-hooks.-10": "pre-hooks.0",
+				"pre-hooks.-5":  "pre-hooks.1",
+				"pre-hooks":     "pre-hooks.2",
+				"pre-hooks.5":   "pre-hooks.3",
+				"pre-hooks.10":  "pre-hooks.4",
+				"post-hooks.3":  "post-hooks.0",
+			},
+		},
+		{
+			name:  "padded",
+			hooks: hooks(-1, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+			expected: map[string]string{
+				"pre-hooks.-1": "pre-hooks.00",
+				"pre-hooks":    "pre-hooks.01",
+				"pre-hooks.1":  "pre-hooks.02",
+				"pre-hooks.2":  "pre-hooks.03",
+				"pre-hooks.3":  "pre-hooks.04",
+				"pre-hooks.4":  "pre-hooks.05",
+				"pre-hooks.5":  "pre-hooks.06",
+				"pre-hooks.6":  "pre-hooks.07",
+				"pre-hooks.7":  "pre-hooks.08",
+				"pre-hooks.8":  "pre-hooks.09",
+				"pre-hooks.9":  "pre-hooks.10",
+				"pre-hooks.10": "pre-hooks.11",
+				"post-hooks.3": "post-hooks.0",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dirs := fluxStageDirectories(test.hooks)
+			assert.Equal(t, test.expected, dirs)
+			stages := hookStages(test.hooks, phasePre)
+			var names []string
+			for _, stage := range stages {
+				names = append(names, dirs[stage])
+			}
+			assert.True(t, sort.StringsAreSorted(names), "lexical order is weight order")
+		})
+	}
+}
+
+func TestConvertHooksFilterInvalidWeight(t *testing.T) {
+	input, err := kio.ParseAll(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-weight: first
+`)
+	require.NoError(t, err, "ParseAll")
+	fn := ConvertHooksFunction{Mode: HooksModeArgoCD}
+	_, err = fn.Filter(input)
+	require.NotNil(t, err, "invalid weight")
+	assert.Contains(t, err.Error(), `invalid helm.sh/hook-weight "first" of Job migrate`, "invalid weight")
+}
+
+// testHooksChart saves the local-chart example with a pre-install hook and
+// returns its directory
+func testHooksChart(t *testing.T) string {
+	t.Helper()
+	c, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/migrate-job.yaml",
+		Data: []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Release.Name }}-migrate
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-delete-policy": before-hook-creation
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: busybox
+`),
+	})
+	dir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, dir), "SaveDir")
+	return filepath.Join(dir, c.Name())
+}
+
+func TestKonvertFilterHooksFlux(t *testing.T) {
+	fn := Konvert("./examples/konvert.yaml")
+	fn.ResourceMeta.Name = "web"
+	fn.Chart = testHooksChart(t)
+	fn.Path = "upstream"
+	fn.Kustomize = true
+	fn.SkipTests = true
+	fn.Hooks = &Hooks{Mode: HooksModeFlux}
+
+	nodes, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	paths := make(map[string]*kyaml.RNode)
+	for _, node := range nodes {
+		paths[node.GetAnnotations()[kioutil.PathAnnotation]] = node
+	}
+	require.Contains(t, paths, "upstream/hooks/pre-hooks.0/job-web-migrate.yaml", "hook stage")
+	job := paths["upstream/hooks/pre-hooks.0/job-web-migrate.yaml"]
+	assert.NotContains(t, job.GetAnnotations(), annotationHelmHook, "helm annotations")
+	assert.Equal(t, "enabled", job.GetAnnotations()[annotationFluxForce], "force")
+
+	resources := func(path string) []string {
+		t.Helper()
+		require.Contains(t, paths, path, "kustomization")
+		elements, err := paths[path].Pipe(kyaml.Lookup("resources"))
+		require.NoError(t, err, "Lookup")
+		var values []string
+		for _, element := range elements.Content() {
+			values = append(values, element.Value)
+		}
+		return values
+	}
+	assert.Equal(t, []string{"job-web-migrate.yaml"}, resources("upstream/hooks/pre-hooks.0/kustomization.yaml"), "stage kustomization")
+	assert.NotContains(t, resources("upstream/kustomization.yaml"), "hooks/pre-hooks.0/job-web-migrate.yaml", "chart kustomization")
+	assert.Contains(t, resources("upstream/kustomization.yaml"), "deployment-web-local-chart.yaml", "chart kustomization")
+	assert.Equal(t, []string{"upstream"}, resources("kustomization.yaml"), "base kustomization")
+}
+
+func TestKonvertHooksConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		spec          string
+		expectedError string
+	}{
+		{name: "argocd", spec: "  hooks:\n    mode: argocd\n"},
+		{name: "invalid-mode", spec: "  hooks:\n    mode: helmfile\n", expectedError: `spec.hooks: invalid hooks mode "helmfile"`},
+		{name: "skip-hooks", spec: "  skipHooks: true\n  hooks:\n    mode: drop\n", expectedError: "spec.hooks cannot be set with spec.skipHooks"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: ./local-chart
+  kubeVersion: "1.27"
+` + test.spec)
+			require.NoError(t, err, "Parse")
+			err = Konvert("./examples/konvert.yaml").Config(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package functions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// charts that create Secrets can be used without committing their values:
+// every rendered Secret is replaced by a resource creating it from a secret
+// store (ExternalSecret) or from values sealed for the cluster (SealedSecret)
+
+const (
+	fnConvertSecretsName = "convert-secrets"
+	fnConvertSecretsKind = "ConvertSecrets"
+
+	ExternalSecretKind            = "ExternalSecret"
+	ExternalSecretAPIVersion      = "external-secrets.io/v1"
+	SealedSecretKind              = "SealedSecret"
+	SealedSecretAPIVersion        = "bitnami.com/v1alpha1"
+	defaultSecretStoreKind        = "SecretStore"
+	defaultExternalSecretKey      = "{{ .Name }}"
+	defaultExternalSecretProp     = "{{ .Key }}"
+	secretTypeServiceAccountToken = "kubernetes.io/service-account-token"
+
+	// annotationKonvertSealedDigests are the salted scrypt digests of the
+	// plaintext of each sealed key of a SealedSecret, as key=digest separated
+	// by commas, to tell the sealed values are stale
+	annotationKonvertSealedDigests = fnConfigGroup + "/sealed-digests"
+)
+
+// ExternalSecrets configures the resources replacing the rendered Secrets
+type ExternalSecrets struct {
+	// Kind is ExternalSecret (default) or SealedSecret
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// SecretStoreRef is the store the ExternalSecrets read from
+	SecretStoreRef SecretStoreRef `json:"secretStoreRef,omitempty" yaml:"secretStoreRef,omitempty"`
+	// RefreshInterval of the ExternalSecrets, the default of external-secrets
+	// when empty
+	RefreshInterval string `json:"refreshInterval,omitempty" yaml:"refreshInterval,omitempty"`
+	// RemoteKey and Property are the templates of the remote reference of
+	// each key of a Secret, see remoteRefTemplateData
+	RemoteKey string `json:"remoteKey,omitempty" yaml:"remoteKey,omitempty"`
+	Property  string `json:"property,omitempty" yaml:"property,omitempty"`
+}
+
+type SecretStoreRef struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Kind is SecretStore (default) or ClusterSecretStore
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+}
+
+// remoteRefTemplateData is the data of the RemoteKey and Property templates
+type remoteRefTemplateData struct {
+	// Release is the release name of the chart
+	Release   string
+	Namespace string
+	// Name is the name of the Secret
+	Name   string
+	Labels map[string]string
+	// Key is the key of the Secret
+	Key string
+}
+
+// validate sets the defaults of e and checks its templates
+func (e *ExternalSecrets) validate() error {
+	switch e.Kind {
+	case "":
+		e.Kind = ExternalSecretKind
+	case ExternalSecretKind, SealedSecretKind:
+	default:
+		return fmt.Errorf("invalid kind %q, must be %s or %s", e.Kind, ExternalSecretKind, SealedSecretKind)
+	}
+	if e.Kind == SealedSecretKind {
+		return nil
+	}
+	if e.SecretStoreRef.Name == "" {
+		return fmt.Errorf("secretStoreRef.name is required")
+	}
+	if e.SecretStoreRef.Kind == "" {
+		e.SecretStoreRef.Kind = defaultSecretStoreKind
+	}
+	if e.RemoteKey == "" {
+		e.RemoteKey = defaultExternalSecretKey
+		if e.Property == "" {
+			e.Property = defaultExternalSecretProp
+		}
+	}
+	if _, err := parseRemoteRefTemplate("remoteKey", e.RemoteKey); err != nil {
+		return err
+	}
+	if _, err := parseRemoteRefTemplate("property", e.Property); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseRemoteRefTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(pathTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s template %q", name, text)
+	}
+	return tmpl, nil
+}
+
+// externalSecrets returns spec.externalSecrets, empty when the Secrets are
+// kept
+func (f *KonvertFunction) externalSecrets() ExternalSecrets {
+	if f.ExternalSecrets == nil {
+		return ExternalSecrets{}
+	}
+	return *f.ExternalSecrets
+}
+
+type ConvertSecretsProcessor struct{}
+
+func (p *ConvertSecretsProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&ConvertSecretsFunction{}, resourceList)
+}
+
+// ConvertSecretsFunction replaces the Secrets by ExternalSecrets reading each
+// key from a secret store, or by SealedSecrets keeping the values sealed
+// since the previous run. The values of the Secrets are never written.
+type ConvertSecretsFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+	ExternalSecrets    ExternalSecrets `json:"externalSecrets,omitempty" yaml:"externalSecrets,omitempty"`
+	// ReleaseName is the release of the remote reference templates
+	ReleaseName string `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
+	// previous are the resources rendered by the previous run, the sealed
+	// values of their SealedSecrets are kept
+	previous []*kyaml.RNode
+	// results reports the keys of SealedSecrets left to seal by the last
+	// Filter
+	results framework.Results
+}
+
+func (f *ConvertSecretsFunction) Name() string {
+	return fnConvertSecretsName
+}
+
+func (f *ConvertSecretsFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *ConvertSecretsFunction) Config(rn *kyaml.RNode) error {
+	if err := loadConfig(f, rn, fnConvertSecretsKind); err != nil {
+		return err
+	}
+	return f.ExternalSecrets.validate()
+}
+
+// Results reports the keys of SealedSecrets left to seal by the last Filter
+func (f *ConvertSecretsFunction) Results() framework.Results {
+	return f.results
+}
+
+func (f *ConvertSecretsFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.results = nil
+	if f.ExternalSecrets == (ExternalSecrets{}) {
+		return items, nil
+	}
+	if err := f.ExternalSecrets.validate(); err != nil {
+		return items, err
+	}
+	for i, item := range items {
+		if !isSecret(item) {
+			continue
+		}
+		keys, err := secretKeys(item)
+		if err != nil {
+			return items, err
+		}
+		// the values of these Secrets are not provided by the chart
+		if len(keys) == 0 || secretType(item) == secretTypeServiceAccountToken {
+			continue
+		}
+		var converted *kyaml.RNode
+		if f.ExternalSecrets.Kind == SealedSecretKind {
+			converted, err = f.sealedSecret(item, keys)
+		} else {
+			converted, err = f.externalSecret(item, keys)
+		}
+		if err != nil {
+			return items, errors.Wrapf(err, "unable to convert Secret %s", item.GetName())
+		}
+		items[i] = converted
+	}
+	return items, nil
+}
+
+// secretKeys returns the keys of data and stringData of a Secret, in order
+func secretKeys(node *kyaml.RNode) ([]string, error) {
+	var keys []string
+	for _, field := range []string{"data", "stringData"} {
+		values := node.Field(field)
+		if values == nil {
+			continue
+		}
+		fieldKeys, err := values.Value.Fields()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s of Secret %s", field, node.GetName())
+		}
+		for _, key := range fieldKeys {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func secretType(node *kyaml.RNode) string {
+	if field := node.Field("type"); field != nil {
+		return field.Value.YNode().Value
+	}
+	return ""
+}
+
+// secretSkeleton returns a copy of the Secret node with its metadata as a
+// resource of kind, without the values of the Secret
+func secretSkeleton(node *kyaml.RNode, apiVersion, kind string) (*kyaml.RNode, error) {
+	skeleton := node.Copy()
+	skeleton.SetApiVersion(apiVersion)
+	skeleton.SetKind(kind)
+	for _, field := range []string{"type", "data", "stringData", "immutable"} {
+		if err := skeleton.PipeE(kyaml.Clear(field)); err != nil {
+			return nil, errors.Wrapf(err, "unable to clear %s", field)
+		}
+	}
+	return skeleton, nil
+}
+
+type externalSecretSpec struct {
+	RefreshInterval string               `yaml:"refreshInterval,omitempty"`
+	SecretStoreRef  SecretStoreRef       `yaml:"secretStoreRef"`
+	Target          secretTarget         `yaml:"target"`
+	Data            []externalSecretData `yaml:"data"`
+}
+
+type secretTarget struct {
+	Name     string          `yaml:"name"`
+	Template *secretTemplate `yaml:"template,omitempty"`
+}
+
+// secretTemplate is the template of the Secret created by an ExternalSecret
+// or a SealedSecret
+type secretTemplate struct {
+	Type      string                  `yaml:"type,omitempty"`
+	Immutable bool                    `yaml:"immutable,omitempty"`
+	Metadata  *secretTemplateMetadata `yaml:"metadata,omitempty"`
+}
+
+type secretTemplateMetadata struct {
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+type externalSecretData struct {
+	SecretKey string                  `yaml:"secretKey"`
+	RemoteRef externalSecretRemoteRef `yaml:"remoteRef"`
+}
+
+type externalSecretRemoteRef struct {
+	Key      string `yaml:"key"`
+	Property string `yaml:"property,omitempty"`
+}
+
+// newSecretTemplate returns the template creating a Secret like node, or nil
+// if the defaults create the same Secret
+func newSecretTemplate(node *kyaml.RNode) *secretTemplate {
+	var tmpl secretTemplate
+	if t := secretType(node); t != "Opaque" {
+		tmpl.Type = t
+	}
+	if immutable := node.Field("immutable"); immutable != nil && immutable.Value.YNode().Value == "true" {
+		tmpl.Immutable = true
+	}
+	if labels := node.GetLabels(); len(labels) > 0 {
+		tmpl.Metadata = &secretTemplateMetadata{Labels: labels}
+	}
+	if tmpl == (secretTemplate{}) {
+		return nil
+	}
+	return &tmpl
+}
+
+func (f *ConvertSecretsFunction) externalSecret(node *kyaml.RNode, keys []string) (*kyaml.RNode, error) {
+	remoteKey, err := parseRemoteRefTemplate("remoteKey", f.ExternalSecrets.RemoteKey)
+	if err != nil {
+		return nil, err
+	}
+	property, err := parseRemoteRefTemplate("property", f.ExternalSecrets.Property)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := externalSecretSpec{
+		RefreshInterval: f.ExternalSecrets.RefreshInterval,
+		SecretStoreRef:  f.ExternalSecrets.SecretStoreRef,
+		Target: secretTarget{
+			Name:     node.GetName(),
+			Template: newSecretTemplate(node),
+		},
+	}
+	for _, key := range keys {
+		data := remoteRefTemplateData{
+			Release:   f.ReleaseName,
+			Namespace: node.GetNamespace(),
+			Name:      node.GetName(),
+			Labels:    node.GetLabels(),
+			Key:       key,
+		}
+		var ref externalSecretRemoteRef
+		if ref.Key, err = executeRemoteRefTemplate(remoteKey, data); err != nil {
+			return nil, err
+		}
+		if ref.Key == "" {
+			return nil, fmt.Errorf("remoteKey template %q maps key %s to an empty remote key", f.ExternalSecrets.RemoteKey, key)
+		}
+		if ref.Property, err = executeRemoteRefTemplate(property, data); err != nil {
+			return nil, err
+		}
+		spec.Data = append(spec.Data, externalSecretData{SecretKey: key, RemoteRef: ref})
+	}
+
+	externalSecret, err := secretSkeleton(node, ExternalSecretAPIVersion, ExternalSecretKind)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSpec(externalSecret, spec); err != nil {
+		return nil, err
+	}
+	return externalSecret, nil
+}
+
+func executeRemoteRefTemplate(tmpl *template.Template, data remoteRefTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", errors.Wrapf(err, "unable to execute %s template for key %s", tmpl.Name(), data.Key)
+	}
+	return sb.String(), nil
+}
+
+type sealedSecretSpec struct {
+	EncryptedData map[string]string `yaml:"encryptedData"`
+	Template      *secretTemplate   `yaml:"template,omitempty"`
+}
+
+// sealedSecret returns a SealedSecret with the values sealed in the previous
+// version for the keys of the Secret whose plaintext did not change, the
+// other keys are reported to be sealed
+func (f *ConvertSecretsFunction) sealedSecret(node *kyaml.RNode, keys []string) (*kyaml.RNode, error) {
+	previous, previousDigests, err := f.previousSealedData(node)
+	if err != nil {
+		return nil, err
+	}
+	values, err := secretValues(node)
+	if err != nil {
+		return nil, err
+	}
+	spec := sealedSecretSpec{
+		EncryptedData: make(map[string]string),
+		Template:      newSecretTemplate(node),
+	}
+	digests := make(map[string]string)
+	var unsealed []string
+	for _, key := range keys {
+		value, ok := previous[key]
+		if !ok {
+			unsealed = append(unsealed, key)
+			continue
+		}
+		digest, changed, err := sealedValueDigest(previousDigests[key], values[key])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to compare key %s with the sealed value", key)
+		}
+		if changed {
+			unsealed = append(unsealed, key)
+			continue
+		}
+		spec.EncryptedData[key] = value
+		digests[key] = digest
+	}
+
+	sealedSecret, err := secretSkeleton(node, SealedSecretAPIVersion, SealedSecretKind)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSpec(sealedSecret, spec); err != nil {
+		return nil, err
+	}
+	if len(digests) > 0 {
+		var annotation []string
+		for _, key := range sortedKeys(digests) {
+			annotation = append(annotation, key+"="+digests[key])
+		}
+		err = sealedSecret.PipeE(kyaml.SetAnnotation(annotationKonvertSealedDigests, strings.Join(annotation, ",")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to set annotation %s", annotationKonvertSealedDigests)
+		}
+	}
+	if len(unsealed) > 0 {
+		f.results = append(f.results, resourceResult(sealedSecret, framework.Warning,
+			fmt.Sprintf("keys %s must be sealed, e.g. with kubeseal --merge-into", strings.Join(unsealed, ", "))))
+	}
+	return sealedSecret, nil
+}
+
+// sealedValueDigest returns the digest of the plaintext value of a sealed
+// key, with the salt of the previous digest, and whether it changed. A key
+// without a previous digest was just sealed, e.g. with kubeseal --merge-into,
+// its digest is computed with a new salt.
+func sealedValueDigest(previous string, value []byte) (string, bool, error) {
+	if previous == "" {
+		salt := make([]byte, sopsDigestSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", false, errors.Wrap(err, "unable to generate digest salt")
+		}
+		digest, err := scryptDigest(value, salt)
+		return digest, false, err
+	}
+	salt, err := scryptDigestSalt(annotationKonvertSealedDigests, previous)
+	if err != nil {
+		return "", false, err
+	}
+	digest, err := scryptDigest(value, salt)
+	if err != nil {
+		return "", false, err
+	}
+	return digest, digest != previous, nil
+}
+
+// secretValues returns the plaintext values of data and stringData of a
+// Secret, stringData overriding data as in the API server
+func secretValues(node *kyaml.RNode) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	for _, field := range []string{"data", "stringData"} {
+		fieldValues := node.Field(field)
+		if fieldValues == nil {
+			continue
+		}
+		err := fieldValues.Value.VisitFields(func(value *kyaml.MapNode) error {
+			content := []byte(value.Value.YNode().Value)
+			if field == "data" {
+				decoded, err := base64.StdEncoding.DecodeString(value.Value.YNode().Value)
+				if err != nil {
+					return errors.Wrapf(err, "unable to decode key %s", value.Key.YNode().Value)
+				}
+				content = decoded
+			}
+			values[value.Key.YNode().Value] = content
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s of Secret %s", field, node.GetName())
+		}
+	}
+	return values, nil
+}
+
+// previousSealedData returns the encryptedData of the previous version of the
+// SealedSecret replacing the Secret node, and the digests of their plaintext
+func (f *ConvertSecretsFunction) previousSealedData(node *kyaml.RNode) (map[string]string, map[string]string, error) {
+	id := fmt.Sprintf("%s/%s/%s/%s", SealedSecretAPIVersion, SealedSecretKind, node.GetNamespace(), node.GetName())
+	values := make(map[string]string)
+	digests := make(map[string]string)
+	for _, prev := range f.previous {
+		if resourceID(prev) != id {
+			continue
+		}
+		if annotation := prev.GetAnnotations()[annotationKonvertSealedDigests]; annotation != "" {
+			for _, keyDigest := range strings.Split(annotation, ",") {
+				key, digest, ok := strings.Cut(keyDigest, "=")
+				if !ok {
+					return nil, nil, fmt.Errorf("invalid %s %q", annotationKonvertSealedDigests, annotation)
+				}
+				digests[key] = digest
+			}
+		}
+		encryptedData, err := prev.Pipe(kyaml.Lookup("spec", "encryptedData"))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read encryptedData of the previous SealedSecret")
+		}
+		if encryptedData == nil {
+			continue
+		}
+		err = encryptedData.VisitFields(func(field *kyaml.MapNode) error {
+			values[field.Key.YNode().Value] = field.Value.YNode().Value
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read encryptedData of the previous SealedSecret")
+		}
+	}
+	return values, digests, nil
+}
+
+func setSpec(node *kyaml.RNode, spec interface{}) error {
+	field := &kyaml.Node{}
+	if err := field.Encode(spec); err != nil {
+		return errors.Wrapf(err, "unable to encode spec of %s %s", node.GetKind(), node.GetName())
+	}
+	if err := node.PipeE(kyaml.SetField("spec", kyaml.NewRNode(field))); err != nil {
+		return errors.Wrapf(err, "unable to set spec of %s %s", node.GetKind(), node.GetName())
+	}
+	return nil
+}
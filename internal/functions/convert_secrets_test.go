@@ -0,0 +1,425 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testConvertSecretsInput = `# Source: mysql/templates/secrets.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql
+  namespace: db
+  labels:
+    app.kubernetes.io/name: mysql
+  annotations:
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+type: Opaque
+data:
+  mysql-root-password: cm9vdA==
+  mysql-password: cGFzc3dvcmQ=
+stringData:
+  username: app
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql-tls
+  namespace: db
+type: kubernetes.io/tls
+immutable: true
+data:
+  tls.crt: Y2VydA==
+  tls.key: a2V5
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql-token
+  namespace: db
+  annotations:
+    kubernetes.io/service-account.name: mysql
+type: kubernetes.io/service-account-token
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql-placeholder
+  namespace: db
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mysql
+  namespace: db
+data:
+  my.cnf: '[mysqld]'
+`
+
+func testConvertSecrets(t *testing.T, fn *ConvertSecretsFunction) map[string]*kyaml.RNode {
+	t.Helper()
+	items, err := kio.ParseAll(testConvertSecretsInput)
+	require.NoError(t, err, "ParseAll")
+	items, err = fn.Filter(items)
+	require.NoError(t, err, "Filter")
+	require.Len(t, items, 5, "items")
+	nodes := make(map[string]*kyaml.RNode)
+	for _, item := range items {
+		require.NoError(t, item.PipeE(kyaml.ClearAnnotation(kioutil.IndexAnnotation)), "ClearAnnotation")
+		require.NoError(t, item.PipeE(kyaml.ClearAnnotation(kioutil.LegacyIndexAnnotation)), "ClearAnnotation") //nolint:staticcheck
+		require.NoError(t, kyaml.ClearEmptyAnnotations(item), "ClearEmptyAnnotations")
+		assert.NotContains(t, item.MustString(), "cm9vdA==", "plaintext")
+		assert.NotContains(t, item.MustString(), "username: app", "plaintext")
+		nodes[item.GetKind()+"/"+item.GetName()] = item
+	}
+	assert.Contains(t, nodes, "Secret/mysql-token", "service account tokens are kept")
+	assert.Contains(t, nodes, "Secret/mysql-placeholder", "Secrets without values are kept")
+	assert.Contains(t, nodes, "ConfigMap/mysql", "configmap")
+	return nodes
+}
+
+func TestConvertSecretsFunctionExternalSecret(t *testing.T) {
+	fn := &ConvertSecretsFunction{
+		ExternalSecrets: ExternalSecrets{
+			SecretStoreRef:  SecretStoreRef{Name: "vault", Kind: "ClusterSecretStore"},
+			RefreshInterval: "1h",
+		},
+		ReleaseName: "db01",
+	}
+	nodes := testConvertSecrets(t, fn)
+	assert.Empty(t, fn.Results(), "results")
+
+	require.Contains(t, nodes, "ExternalSecret/mysql", "externalsecret")
+	assert.Equal(t, `# Source: mysql/templates/secrets.yaml
+apiVersion: external-secrets.io/v1
+kind: ExternalSecret
+metadata:
+  name: mysql
+  namespace: db
+  labels:
+    app.kubernetes.io/name: mysql
+  annotations:
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+spec:
+  refreshInterval: 1h
+  secretStoreRef:
+    name: vault
+    kind: ClusterSecretStore
+  target:
+    name: mysql
+    template:
+      metadata:
+        labels:
+          app.kubernetes.io/name: mysql
+  data:
+  - secretKey: mysql-root-password
+    remoteRef:
+      key: mysql
+      property: mysql-root-password
+  - secretKey: mysql-password
+    remoteRef:
+      key: mysql
+      property: mysql-password
+  - secretKey: username
+    remoteRef:
+      key: mysql
+      property: username
+`, nodes["ExternalSecret/mysql"].MustString(), "externalsecret")
+
+	require.Contains(t, nodes, "ExternalSecret/mysql-tls", "externalsecret")
+	assert.Equal(t, `apiVersion: external-secrets.io/v1
+kind: ExternalSecret
+metadata:
+  name: mysql-tls
+  namespace: db
+spec:
+  refreshInterval: 1h
+  secretStoreRef:
+    name: vault
+    kind: ClusterSecretStore
+  target:
+    name: mysql-tls
+    template:
+      type: kubernetes.io/tls
+      immutable: true
+  data:
+  - secretKey: tls.crt
+    remoteRef:
+      key: mysql-tls
+      property: tls.crt
+  - secretKey: tls.key
+    remoteRef:
+      key: mysql-tls
+      property: tls.key
+`, nodes["ExternalSecret/mysql-tls"].MustString(), "type")
+}
+
+func TestConvertSecretsFunctionRemoteKey(t *testing.T) {
+	fn := &ConvertSecretsFunction{
+		ExternalSecrets: ExternalSecrets{
+			SecretStoreRef: SecretStoreRef{Name: "aws"},
+			RemoteKey:      "{{ .Release }}/{{ .Namespace }}/{{ .Name }}/{{ .Key | upper }}",
+		},
+		ReleaseName: "db01",
+	}
+	nodes := testConvertSecrets(t, fn)
+	require.Contains(t, nodes, "ExternalSecret/mysql", "externalsecret")
+	spec, err := nodes["ExternalSecret/mysql"].Pipe(kyaml.Lookup("spec"))
+	require.NoError(t, err, "Lookup")
+	assert.Equal(t, `secretStoreRef:
+  name: aws
+  kind: SecretStore
+target:
+  name: mysql
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: mysql
+data:
+- secretKey: mysql-root-password
+  remoteRef:
+    key: db01/db/mysql/MYSQL-ROOT-PASSWORD
+- secretKey: mysql-password
+  remoteRef:
+    key: db01/db/mysql/MYSQL-PASSWORD
+- secretKey: username
+  remoteRef:
+    key: db01/db/mysql/USERNAME
+`, spec.MustString(), "spec")
+}
+
+func TestConvertSecretsFunctionSealedSecret(t *testing.T) {
+	previous, err := kio.ParseAll(`apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: mysql
+  namespace: db
+spec:
+  encryptedData:
+    mysql-root-password: AgBy3i4OJSWK+PiTySYZZA==
+    removed: AgAKAoiQm7QDBbEKA==
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: mysql
+`)
+	require.NoError(t, err, "ParseAll")
+	fn := &ConvertSecretsFunction{
+		ExternalSecrets: ExternalSecrets{Kind: SealedSecretKind},
+		previous:        previous,
+	}
+	nodes := testConvertSecrets(t, fn)
+
+	require.Contains(t, nodes, "SealedSecret/mysql", "sealedsecret")
+	digests := nodes["SealedSecret/mysql"].GetAnnotations()[annotationKonvertSealedDigests]
+	require.True(t, strings.HasPrefix(digests, "mysql-root-password="), "digests")
+	_, changed, err := sealedValueDigest(strings.TrimPrefix(digests, "mysql-root-password="), []byte("root"))
+	require.NoError(t, err, "sealedValueDigest")
+	assert.False(t, changed, "digest")
+	require.NoError(t, nodes["SealedSecret/mysql"].PipeE(kyaml.ClearAnnotation(annotationKonvertSealedDigests)), "ClearAnnotation")
+	assert.Equal(t, `# Source: mysql/templates/secrets.yaml
+apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: mysql
+  namespace: db
+  labels:
+    app.kubernetes.io/name: mysql
+  annotations:
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+spec:
+  encryptedData:
+    mysql-root-password: AgBy3i4OJSWK+PiTySYZZA==
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: mysql
+`, nodes["SealedSecret/mysql"].MustString(), "sealedsecret")
+
+	require.Contains(t, nodes, "SealedSecret/mysql-tls", "sealedsecret")
+	assert.Equal(t, `apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: mysql-tls
+  namespace: db
+spec:
+  encryptedData: {}
+  template:
+    type: kubernetes.io/tls
+    immutable: true
+`, nodes["SealedSecret/mysql-tls"].MustString(), "sealedsecret")
+
+	var messages []string
+	for _, result := range fn.Results() {
+		assert.Equal(t, framework.Warning, result.Severity, "severity")
+		messages = append(messages, fmt.Sprintf("%s: %s", result.ResourceRef.Name, result.Message))
+	}
+	assert.Equal(t, []string{
+		"mysql: keys mysql-password, username must be sealed, e.g. with kubeseal --merge-into",
+		"mysql-tls: keys tls.crt, tls.key must be sealed, e.g. with kubeseal --merge-into",
+	}, messages, "results")
+}
+
+func TestConvertSecretsFunctionSealedSecretChanged(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	var tests = []struct {
+		name             string
+		sealedPlaintext  string
+		expectedData     string
+		expectedUnsealed string
+		expectedDigest   bool
+	}{
+		{
+			name:             "unchanged",
+			sealedPlaintext:  "root",
+			expectedData:     "mysql-root-password: AgBy3i4OJSWK+PiTySYZZA==\n",
+			expectedUnsealed: "mysql-password, username",
+			expectedDigest:   true,
+		},
+		{
+			name:             "changed",
+			sealedPlaintext:  "previous",
+			expectedData:     "{}\n",
+			expectedUnsealed: "mysql-root-password, mysql-password, username",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			digest, err := scryptDigest([]byte(test.sealedPlaintext), salt)
+			require.NoError(t, err, "scryptDigest")
+			previous, err := kio.ParseAll(`apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: mysql
+  namespace: db
+  annotations:
+    konvert.kumorilabs.io/sealed-digests: mysql-root-password=` + digest + `
+spec:
+  encryptedData:
+    mysql-root-password: AgBy3i4OJSWK+PiTySYZZA==
+`)
+			require.NoError(t, err, "ParseAll")
+			fn := &ConvertSecretsFunction{
+				ExternalSecrets: ExternalSecrets{Kind: SealedSecretKind},
+				previous:        previous,
+			}
+			nodes := testConvertSecrets(t, fn)
+
+			require.Contains(t, nodes, "SealedSecret/mysql", "sealedsecret")
+			sealedSecret := nodes["SealedSecret/mysql"]
+			encryptedData, err := sealedSecret.Pipe(kyaml.Lookup("spec", "encryptedData"))
+			require.NoError(t, err, "Lookup")
+			assert.Equal(t, test.expectedData, encryptedData.MustString(), "encryptedData")
+			if test.expectedDigest {
+				assert.Equal(t, "mysql-root-password="+digest, sealedSecret.GetAnnotations()[annotationKonvertSealedDigests], "digests")
+			} else {
+				assert.NotContains(t, sealedSecret.GetAnnotations(), annotationKonvertSealedDigests, "digests")
+			}
+			require.NotEmpty(t, fn.Results(), "results")
+			assert.Equal(t, "keys "+test.expectedUnsealed+" must be sealed, e.g. with kubeseal --merge-into", fn.Results()[0].Message, "results")
+		})
+	}
+}
+
+func TestKonvertExternalSecretsConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		spec          string
+		expectedError string
+	}{
+		{name: "external-secret", spec: "  externalSecrets:\n    secretStoreRef:\n      name: vault\n"},
+		{name: "sealed-secret", spec: "  externalSecrets:\n    kind: SealedSecret\n"},
+		{name: "invalid-kind", spec: "  externalSecrets:\n    kind: Secret\n", expectedError: `spec.externalSecrets: invalid kind "Secret", must be ExternalSecret or SealedSecret`},
+		{name: "no-store", spec: "  externalSecrets:\n    remoteKey: '{{ .Name }}'\n", expectedError: "spec.externalSecrets: secretStoreRef.name is required"},
+		{name: "invalid-template", spec: "  externalSecrets:\n    secretStoreRef:\n      name: vault\n    remoteKey: '{{ .Name'\n", expectedError: "spec.externalSecrets: invalid remoteKey template"},
+		{name: "sops", spec: "  sops:\n    age:\n    - age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n  externalSecrets:\n    kind: SealedSecret\n", expectedError: "spec.externalSecrets cannot be set with spec.sops"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: ./local-chart
+  kubeVersion: "1.27"
+` + test.spec)
+			require.NoError(t, err, "Parse")
+			err = Konvert("./examples/konvert.yaml").Config(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+		})
+	}
+}
+
+func TestKonvertFilterExternalSecrets(t *testing.T) {
+	c, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/secret.yaml",
+		Data: []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}-credentials
+  namespace: {{ .Release.Namespace }}
+data:
+  password: {{ "secret" | b64enc }}
+`),
+	})
+	dir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, dir), "SaveDir")
+
+	input, err := kyaml.Parse(fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: %s
+  namespace: web
+  kubeVersion: "1.27"
+  externalSecrets:
+    secretStoreRef:
+      name: vault
+    remoteKey: '{{ .Release }}/{{ .Name }}'
+`, filepath.Join(dir, c.Name())))
+	require.NoError(t, err, "Parse")
+	fn := Konvert("./examples/konvert.yaml")
+	require.NoError(t, fn.Config(input), "Config")
+	nodes, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	var externalSecret *kyaml.RNode
+	for _, node := range nodes {
+		assert.False(t, isSecret(node), "secret %s", node.GetName())
+		assert.False(t, strings.Contains(node.MustString(), "c2VjcmV0"), "plaintext")
+		if node.GetKind() == ExternalSecretKind {
+			externalSecret = node
+		}
+	}
+	require.NotNil(t, externalSecret, "externalsecret")
+	assert.Equal(t, "externalsecret-web-credentials.yaml", externalSecret.GetAnnotations()[kioutil.PathAnnotation], "path")
+	assert.Equal(t, "web", externalSecret.GetNamespace(), "namespace")
+	key, err := externalSecret.Pipe(kyaml.Lookup("spec", "data", "[secretKey=password]", "remoteRef", "key"))
+	require.NoError(t, err, "Lookup")
+	require.NotNil(t, key, "remoteRef")
+	assert.Equal(t, "web/web-credentials", key.YNode().Value, "remoteRef")
+}
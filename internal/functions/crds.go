@@ -0,0 +1,56 @@
+package functions
+
+import (
+	"github.com/pkg/errors"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const defaultCRDsPath = "crds"
+
+// CRDs routes the CustomResourceDefinitions rendered from a chart, from its
+// crds directory and its templates, to their own directory so they can be
+// applied before the resources using them
+type CRDs struct {
+	// Path is the directory of the CustomResourceDefinitions, relative to the
+	// Konvert file. Defaults to crds.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Kustomize writes a kustomization.yaml for the CustomResourceDefinitions.
+	// It is not referenced by the other kustomizations.
+	Kustomize bool `json:"kustomize,omitempty" yaml:"kustomize,omitempty"`
+}
+
+// isCRD returns true if rn is a CustomResourceDefinition
+func isCRD(rn *kyaml.RNode) bool {
+	group, _ := splitAPIVersion(rn.GetApiVersion())
+	return group == "apiextensions.k8s.io" && rn.GetKind() == "CustomResourceDefinition"
+}
+
+func isNotCRD(rn *kyaml.RNode) bool {
+	return !isCRD(rn)
+}
+
+// crdPath returns the directory of the CustomResourceDefinitions, empty when
+// they are written with the other resources
+func (f *KonvertFunction) crdPath() string {
+	if f.CRDs == nil {
+		return ""
+	}
+	return f.CRDs.Path
+}
+
+// kustomizeCRDs writes the kustomization of the CustomResourceDefinitions
+// rendered from the chart
+func (f *KonvertFunction) kustomizeCRDs(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	kustomizer := KustomizerFunction{
+		Path:                    f.CRDs.Path,
+		ResourceAnnotationName:  annotationKonvertChart,
+		ResourceAnnotationValue: konvertChartAnnotationValue(f.Repo, f.Chart),
+		selects:                 isCRD,
+		standalone:              true,
+	}
+	nodes, err := kustomizer.Filter(nodes)
+	if err != nil {
+		return nodes, errors.Wrap(err, "unable to run kustomizer function for CRDs")
+	}
+	return nodes, nil
+}
@@ -0,0 +1,156 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: %[1]ss.example.com
+spec:
+  group: example.com
+  names:
+    kind: %[2]s
+    plural: %[1]ss
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+`
+
+// testCRDChart saves the local-chart example with a CustomResourceDefinition
+// in its crds directory and another one in its templates, and returns its
+// directory
+func testCRDChart(t *testing.T) string {
+	t.Helper()
+	c, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	c.Files = append(c.Files, &chart.File{
+		Name: "crds/widget.yaml",
+		Data: []byte(fmt.Sprintf(testCRD, "widget", "Widget")),
+	})
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/gadget-crd.yaml",
+		Data: []byte(fmt.Sprintf(testCRD, "gadget", "Gadget")),
+	})
+	dir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, dir), "SaveDir")
+	return filepath.Join(dir, c.Name())
+}
+
+func TestIsCRD(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{name: "v1", input: fmt.Sprintf(testCRD, "widget", "Widget"), expected: true},
+		{name: "v1beta1", input: "apiVersion: apiextensions.k8s.io/v1beta1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\n", expected: true},
+		{name: "custom-resource", input: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: widget\n"},
+		{name: "other-group", input: "apiVersion: example.com/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widget\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isCRD(kyaml.MustParse(test.input)), test.name)
+		})
+	}
+}
+
+func TestSetPathAnnotationFunctionCRDPath(t *testing.T) {
+	input, err := kio.ParseAll(fmt.Sprintf(testCRD, "widget", "Widget") + `---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+`)
+	require.NoError(t, err, "ParseAll")
+
+	fn := SetPathAnnotationFunction{Path: "upstream", CRDPath: "crds"}
+	output, err := fn.Filter(input)
+	require.NoError(t, err, "Filter")
+	assert.Equal(t, "crds/customresourcedefinition-widgets.example.com.yaml", output[0].GetAnnotations()[kioutil.PathAnnotation], "crd")
+	assert.Equal(t, "upstream/widget-widget.yaml", output[1].GetAnnotations()[kioutil.PathAnnotation], "custom resource")
+}
+
+func TestKonvertFilterCRDs(t *testing.T) {
+	chartDir := testCRDChart(t)
+	newFn := func(crds string) *KonvertFunction {
+		input, err := kyaml.Parse(fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: %s
+  namespace: web
+  path: upstream
+  kustomize: true
+%s`, chartDir, crds))
+		require.NoError(t, err, "Parse")
+		fn := Konvert("./examples/konvert.yaml")
+		require.NoError(t, fn.Config(input), "Config")
+		return fn
+	}
+
+	nodes, err := newFn("  crds:\n    kustomize: true\n").Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	paths := make(map[string]*kyaml.RNode)
+	for _, node := range nodes {
+		paths[node.GetAnnotations()[kioutil.PathAnnotation]] = node
+	}
+	assert.Contains(t, paths, "crds/customresourcedefinition-widgets.example.com.yaml", "crd from crds directory")
+	assert.Contains(t, paths, "crds/customresourcedefinition-gadgets.example.com.yaml", "crd from templates")
+
+	resources := func(path string) []string {
+		t.Helper()
+		require.Contains(t, paths, path, "kustomization")
+		elements, err := paths[path].Pipe(kyaml.Lookup("resources"))
+		require.NoError(t, err, "Lookup")
+		var values []string
+		for _, element := range elements.Content() {
+			values = append(values, element.Value)
+		}
+		return values
+	}
+	assert.ElementsMatch(t, []string{
+		"customresourcedefinition-gadgets.example.com.yaml",
+		"customresourcedefinition-widgets.example.com.yaml",
+	}, resources("crds/kustomization.yaml"), "crds kustomization")
+	assert.NotContains(t, resources("upstream/kustomization.yaml"), "customresourcedefinition-gadgets.example.com.yaml", "chart kustomization")
+	assert.Contains(t, resources("upstream/kustomization.yaml"), "deployment-web-local-chart.yaml", "chart kustomization")
+	assert.Equal(t, []string{"upstream"}, resources("kustomization.yaml"), "base kustomization")
+	namespace, err := paths["crds/kustomization.yaml"].Pipe(kyaml.Lookup("namespace"))
+	require.NoError(t, err, "Lookup")
+	assert.Nil(t, namespace, "crds are not namespaced")
+
+	input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: ./local-chart
+  skipCRDs: true
+  crds: {}
+`)
+	require.NoError(t, err, "Parse")
+	err = Konvert("./examples/konvert.yaml").Config(input)
+	require.NotNil(t, err, "skipCRDs")
+	assert.Contains(t, err.Error(), "spec.crds cannot be set with spec.skipCRDs", "skipCRDs")
+}
@@ -0,0 +1,85 @@
+package functions
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// deprecatedAPI is an API version of a kind that Kubernetes removed
+type deprecatedAPI struct {
+	removedIn string
+	// replacement is the API version to use instead, empty when the kind
+	// was removed altogether
+	replacement string
+}
+
+// deprecatedAPIs are keyed by apiVersion/kind, see
+// https://kubernetes.io/docs/reference/using-api/deprecation-guide/
+var deprecatedAPIs = map[string]deprecatedAPI{
+	"extensions/v1beta1/DaemonSet":                                        {"v1.16", "apps/v1"},
+	"extensions/v1beta1/Deployment":                                       {"v1.16", "apps/v1"},
+	"extensions/v1beta1/ReplicaSet":                                       {"v1.16", "apps/v1"},
+	"extensions/v1beta1/NetworkPolicy":                                    {"v1.16", "networking.k8s.io/v1"},
+	"extensions/v1beta1/PodSecurityPolicy":                                {"v1.16", "policy/v1beta1"},
+	"apps/v1beta1/Deployment":                                             {"v1.16", "apps/v1"},
+	"apps/v1beta1/StatefulSet":                                            {"v1.16", "apps/v1"},
+	"apps/v1beta2/DaemonSet":                                              {"v1.16", "apps/v1"},
+	"apps/v1beta2/Deployment":                                             {"v1.16", "apps/v1"},
+	"apps/v1beta2/ReplicaSet":                                             {"v1.16", "apps/v1"},
+	"apps/v1beta2/StatefulSet":                                            {"v1.16", "apps/v1"},
+	"admissionregistration.k8s.io/v1beta1/MutatingWebhookConfiguration":   {"v1.22", "admissionregistration.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1beta1/ValidatingWebhookConfiguration": {"v1.22", "admissionregistration.k8s.io/v1"},
+	"apiextensions.k8s.io/v1beta1/CustomResourceDefinition":               {"v1.22", "apiextensions.k8s.io/v1"},
+	"apiregistration.k8s.io/v1beta1/APIService":                           {"v1.22", "apiregistration.k8s.io/v1"},
+	"certificates.k8s.io/v1beta1/CertificateSigningRequest":               {"v1.22", "certificates.k8s.io/v1"},
+	"coordination.k8s.io/v1beta1/Lease":                                   {"v1.22", "coordination.k8s.io/v1"},
+	"extensions/v1beta1/Ingress":                                          {"v1.22", "networking.k8s.io/v1"},
+	"networking.k8s.io/v1beta1/Ingress":                                   {"v1.22", "networking.k8s.io/v1"},
+	"networking.k8s.io/v1beta1/IngressClass":                              {"v1.22", "networking.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/ClusterRole":                       {"v1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/ClusterRoleBinding":                {"v1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/Role":                              {"v1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/RoleBinding":                       {"v1.22", "rbac.authorization.k8s.io/v1"},
+	"scheduling.k8s.io/v1beta1/PriorityClass":                             {"v1.22", "scheduling.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSIDriver":                                    {"v1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSINode":                                      {"v1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/StorageClass":                                 {"v1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/VolumeAttachment":                             {"v1.22", "storage.k8s.io/v1"},
+	"batch/v1beta1/CronJob":                                               {"v1.25", "batch/v1"},
+	"discovery.k8s.io/v1beta1/EndpointSlice":                              {"v1.25", "discovery.k8s.io/v1"},
+	"events.k8s.io/v1beta1/Event":                                         {"v1.25", "events.k8s.io/v1"},
+	"autoscaling/v2beta1/HorizontalPodAutoscaler":                         {"v1.25", "autoscaling/v2"},
+	"policy/v1beta1/PodDisruptionBudget":                                  {"v1.25", "policy/v1"},
+	"policy/v1beta1/PodSecurityPolicy":                                    {"v1.25", ""},
+	"node.k8s.io/v1beta1/RuntimeClass":                                    {"v1.25", "node.k8s.io/v1"},
+	"autoscaling/v2beta2/HorizontalPodAutoscaler":                         {"v1.26", "autoscaling/v2"},
+	"flowcontrol.apiserver.k8s.io/v1beta1/FlowSchema":                     {"v1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta1/PriorityLevelConfiguration":     {"v1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSIStorageCapacity":                           {"v1.27", "storage.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta2/FlowSchema":                     {"v1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta2/PriorityLevelConfiguration":     {"v1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta3/FlowSchema":                     {"v1.32", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta3/PriorityLevelConfiguration":     {"v1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+// deprecatedAPIResults warns about the resources using an API version
+// Kubernetes removed
+func deprecatedAPIResults(items []*kyaml.RNode) framework.Results {
+	var results framework.Results
+	for _, item := range items {
+		api, ok := deprecatedAPIs[item.GetApiVersion()+"/"+item.GetKind()]
+		if !ok {
+			continue
+		}
+		message := fmt.Sprintf("%s %s was removed in Kubernetes %s", item.GetApiVersion(), item.GetKind(), api.removedIn)
+		if api.replacement != "" {
+			message += fmt.Sprintf(", use %s", api.replacement)
+		}
+		log.WithFields(log.Fields{"kind": item.GetKind(), "name": item.GetName()}).Warn(message)
+		results = append(results, resourceResult(item, framework.Warning, message))
+	}
+	return results
+}
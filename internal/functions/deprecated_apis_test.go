@@ -0,0 +1,46 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+func TestDeprecatedAPIResults(t *testing.T) {
+	items, err := kio.ParseAll(`apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    config.kubernetes.io/path: web/ingress.yaml
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+---
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: restricted
+---
+apiVersion: example.com/v1beta1
+kind: Ingress
+metadata:
+  name: custom
+`)
+	require.NoError(t, err, "ParseAll")
+
+	results := deprecatedAPIResults(items)
+	require.Len(t, results, 2, "results")
+	for _, result := range results {
+		assert.Equal(t, framework.Warning, result.Severity, result.Message)
+	}
+	assert.Equal(t, "networking.k8s.io/v1beta1 Ingress was removed in Kubernetes v1.22, use networking.k8s.io/v1", results[0].Message, "replaced")
+	assert.Equal(t, "web/ingress.yaml", results[0].File.Path, "file")
+	assert.Equal(t, "policy/v1beta1 PodSecurityPolicy was removed in Kubernetes v1.25", results[1].Message, "removed")
+	assert.Equal(t, "restricted", results[1].ResourceRef.Name, "resource")
+}
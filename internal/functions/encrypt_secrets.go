@@ -0,0 +1,764 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// rendered Secrets can be encrypted with the age recipients of spec.sops so
+// they can be committed. The files are written in the format of sops (only
+// the values of data and stringData are encrypted, with a data key encrypted
+// to each recipient), Flux and ksops decrypt them when they are applied.
+
+const (
+	fnEncryptSecretsName = "encrypt-secrets"
+	fnEncryptSecretsKind = "EncryptSecrets"
+
+	sopsField          = "sops"
+	sopsVersion        = "3.8.1"
+	sopsEncryptedRegex = "^(data|stringData)$"
+	sopsDataKeySize    = 32
+	sopsNonceSize      = 32
+
+	// annotationKonvertSOPSDigest is the salted scrypt digest of the
+	// plaintext of an encrypted Secret, to tell it did not change without
+	// decrypting it
+	annotationKonvertSOPSDigest = fnConfigGroup + "/sops-digest"
+	sopsDigestSaltSize          = 16
+	sopsDigestN                 = 1 << 15
+	sopsDigestR                 = 8
+	sopsDigestP                 = 1
+	sopsDigestSize              = 32
+
+	internalAnnotationPrefix = "internal.config.kubernetes.io/"
+
+	envSOPSAgeKey     = "SOPS_AGE_KEY"
+	envSOPSAgeKeyFile = "SOPS_AGE_KEY_FILE"
+)
+
+var (
+	sopsEncryptedFields = regexp.MustCompile(sopsEncryptedRegex)
+	sopsEncryptedValue  = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]$`)
+)
+
+// SOPS configures the encryption of the rendered Secrets
+type SOPS struct {
+	// Age lists the age recipients (age1...) the Secrets are encrypted to
+	Age []string `json:"age,omitempty" yaml:"age,omitempty"`
+}
+
+func validateAgeRecipients(recipients []string) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("age must list at least one recipient")
+	}
+	_, err := parseAgeRecipients(recipients)
+	return err
+}
+
+func parseAgeRecipients(recipients []string) ([]age.Recipient, error) {
+	var parsed []age.Recipient
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid age recipient %q", recipient)
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// ageIdentities returns the age identities sops decrypts with: the keys of
+// SOPS_AGE_KEY, of the file at SOPS_AGE_KEY_FILE or of the sops keys file of
+// the user, if any
+func ageIdentities() ([]age.Identity, error) {
+	if keys := os.Getenv(envSOPSAgeKey); keys != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse %s", envSOPSAgeKey)
+		}
+		return identities, nil
+	}
+	path := os.Getenv(envSOPSAgeKeyFile)
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(dir, "sops", "age", "keys.txt")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open age keys file")
+	}
+	defer file.Close()
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse age keys file %s", path)
+	}
+	return identities, nil
+}
+
+// sopsAge returns the age recipients of spec.sops
+func (f *KonvertFunction) sopsAge() []string {
+	if f.SOPS == nil {
+		return nil
+	}
+	return f.SOPS.Age
+}
+
+type EncryptSecretsProcessor struct{}
+
+func (p *EncryptSecretsProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&EncryptSecretsFunction{}, resourceList)
+}
+
+// EncryptSecretsFunction encrypts the values of the Secrets in the format of
+// sops. A Secret whose content did not change since it was last encrypted
+// keeps its previous ciphertext, otherwise every run would change the
+// encrypted files. The content is compared with the digest annotation of the
+// previous version, no age identity is needed. Secrets encrypted without the
+// digest are decrypted with the identities (see ageIdentities) instead.
+//
+// With Checksum, the checksum annotation of an encrypted Secret covers its
+// encrypted content, the plaintext must not be derivable from the
+// annotations: the digest is salted and computed with scrypt to be expensive
+// to guess.
+type EncryptSecretsFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+	Age                []string `json:"age,omitempty" yaml:"age,omitempty"`
+	// Checksum sets the checksum annotation before the MAC is computed, the
+	// annotation cannot be set once the Secret is encrypted
+	Checksum bool `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	// previous are the resources rendered by the previous run
+	previous []*kyaml.RNode
+	// identities decrypt the previous Secrets, they are loaded with
+	// ageIdentities when nil
+	identities []age.Identity
+	// results reports the Secrets encrypted again because their previous
+	// version could not be decrypted
+	results framework.Results
+}
+
+func (f *EncryptSecretsFunction) Name() string {
+	return fnEncryptSecretsName
+}
+
+func (f *EncryptSecretsFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *EncryptSecretsFunction) Config(rn *kyaml.RNode) error {
+	if err := loadConfig(f, rn, fnEncryptSecretsKind); err != nil {
+		return err
+	}
+	return validateAgeRecipients(f.Age)
+}
+
+// Results reports the Secrets encrypted again by the last Filter because
+// their previous version could not be decrypted
+func (f *EncryptSecretsFunction) Results() framework.Results {
+	return f.results
+}
+
+func (f *EncryptSecretsFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.results = nil
+	if len(f.Age) == 0 {
+		return items, nil
+	}
+	recipients, err := parseAgeRecipients(f.Age)
+	if err != nil {
+		return items, err
+	}
+	identities := f.identities
+	if identities == nil {
+		identities, err = ageIdentities()
+		if err != nil {
+			return items, err
+		}
+	}
+
+	previous := make(map[string]*kyaml.RNode)
+	for _, node := range f.previous {
+		if isSecret(node) && isSOPSEncrypted(node) {
+			previous[resourceID(node)] = node
+		}
+	}
+	paths := make(map[string]int)
+	for _, item := range items {
+		paths[item.GetAnnotations()[kioutil.PathAnnotation]]++
+	}
+
+	for i, item := range items {
+		if !isSecret(item) || isSOPSEncrypted(item) {
+			continue
+		}
+		// sops encrypts whole files
+		if path := item.GetAnnotations()[kioutil.PathAnnotation]; paths[path] > 1 {
+			return items, fmt.Errorf("encrypted Secret %s is written to %s with other resources, encrypted Secrets must be written to their own file", item.GetName(), path)
+		}
+		if prev, ok := previous[resourceID(item)]; ok {
+			reused, err := f.reuse(prev, item, identities)
+			if err != nil {
+				f.results = append(f.results, resourceResult(item, framework.Warning,
+					fmt.Sprintf("encrypted again, unable to compare with the previous version: %s", err)))
+			} else if reused != nil {
+				items[i] = reused
+				continue
+			}
+		}
+		if err := sopsEncrypt(item, f.Age, recipients, f.Checksum, time.Now()); err != nil {
+			return items, errors.Wrapf(err, "unable to encrypt Secret %s", item.GetName())
+		}
+	}
+	return items, nil
+}
+
+// reuse returns the previous version of the Secret node if it encrypts the
+// same content to the same recipients, or nil
+func (f *EncryptSecretsFunction) reuse(prev, node *kyaml.RNode, identities []age.Identity) (*kyaml.RNode, error) {
+	metadata, err := sopsMetadataOf(prev)
+	if err != nil {
+		return nil, err
+	}
+	var recipients []string
+	for _, key := range metadata.Age {
+		recipients = append(recipients, key.Recipient)
+	}
+	if !sameStrings(recipients, f.Age) || metadata.EncryptedRegex != sopsEncryptedRegex {
+		return nil, nil
+	}
+	// the MAC covers the checksum annotation, it cannot be added or removed
+	if _, ok := prev.GetAnnotations()[annotationKonvertChecksum]; ok != f.Checksum {
+		return nil, nil
+	}
+	var same bool
+	if digest, ok := prev.GetAnnotations()[annotationKonvertSOPSDigest]; ok {
+		same, err = matchesSOPSDigest(digest, node)
+	} else {
+		// encrypted before the digest was kept
+		same, err = sameDecrypted(prev, node, identities)
+	}
+	if err != nil || !same {
+		return nil, err
+	}
+
+	// the annotations of the reader are replaced by the ones of the node
+	reused := prev.Copy()
+	if err := clearReaderAnnotations(reused); err != nil {
+		return nil, err
+	}
+	if path, ok := node.GetAnnotations()[kioutil.PathAnnotation]; ok {
+		if err := reused.PipeE(kyaml.SetAnnotation(kioutil.PathAnnotation, path)); err != nil {
+			return nil, errors.Wrapf(err, "unable to set annotation %s", kioutil.PathAnnotation)
+		}
+	}
+	return reused, nil
+}
+
+// sameDecrypted returns true if the encrypted Secret prev decrypts to the
+// content of node
+func sameDecrypted(prev, node *kyaml.RNode, identities []age.Identity) (bool, error) {
+	decrypted, err := sopsDecrypt(prev, identities)
+	if err != nil {
+		return false, err
+	}
+	if err := clearReaderAnnotations(decrypted); err != nil {
+		return false, err
+	}
+	before, err := resourceChecksum(decrypted)
+	if err != nil {
+		return false, err
+	}
+	after, err := resourceChecksum(node)
+	if err != nil {
+		return false, err
+	}
+	return before == after, nil
+}
+
+// sopsDigest returns the digest of the plaintext content of node with salt,
+// as scrypt:<salt>:<digest>
+func sopsDigest(node *kyaml.RNode, salt []byte) (string, error) {
+	content := node.Copy()
+	if err := content.PipeE(kyaml.ClearAnnotation(annotationKonvertSOPSDigest)); err != nil {
+		return "", errors.Wrapf(err, "unable to clear annotation %s", annotationKonvertSOPSDigest)
+	}
+	checksum, err := resourceChecksum(content)
+	if err != nil {
+		return "", err
+	}
+	return scryptDigest([]byte(checksum), salt)
+}
+
+// scryptDigest returns the digest of content with salt, as
+// scrypt:<salt>:<digest>
+func scryptDigest(content, salt []byte) (string, error) {
+	digest, err := scrypt.Key(content, salt, sopsDigestN, sopsDigestR, sopsDigestP, sopsDigestSize)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to compute digest")
+	}
+	return fmt.Sprintf("scrypt:%s:%s",
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(digest)), nil
+}
+
+// scryptDigestSalt returns the salt of the digest returned by scryptDigest
+// and read from the annotation name
+func scryptDigestSalt(name, digest string) ([]byte, error) {
+	parts := strings.Split(digest, ":")
+	if len(parts) != 3 || parts[0] != "scrypt" {
+		return nil, fmt.Errorf("invalid %s %q", name, digest)
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s salt", name)
+	}
+	return salt, nil
+}
+
+// matchesSOPSDigest returns true if digest is the digest of the plaintext
+// content of node, see sopsDigest
+func matchesSOPSDigest(digest string, node *kyaml.RNode) (bool, error) {
+	salt, err := scryptDigestSalt(annotationKonvertSOPSDigest, digest)
+	if err != nil {
+		return false, err
+	}
+	actual, err := sopsDigest(node, salt)
+	if err != nil {
+		return false, err
+	}
+	return actual == digest, nil
+}
+
+func isSecret(node *kyaml.RNode) bool {
+	return node.GetApiVersion() == "v1" && node.GetKind() == "Secret"
+}
+
+func isSOPSEncrypted(node *kyaml.RNode) bool {
+	return node.Field(sopsField) != nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsMetadata is the sops field of an encrypted file
+type sopsMetadata struct {
+	Age            []sopsAgeKey `yaml:"age"`
+	LastModified   string       `yaml:"lastmodified"`
+	MAC            string       `yaml:"mac"`
+	EncryptedRegex string       `yaml:"encrypted_regex"`
+	Version        string       `yaml:"version"`
+}
+
+func sopsMetadataOf(node *kyaml.RNode) (sopsMetadata, error) {
+	var metadata sopsMetadata
+	field := node.Field(sopsField)
+	if field == nil {
+		return metadata, fmt.Errorf("%s %s is not encrypted", node.GetKind(), node.GetName())
+	}
+	if err := field.Value.YNode().Decode(&metadata); err != nil {
+		return metadata, errors.Wrap(err, "unable to read sops metadata")
+	}
+	return metadata, nil
+}
+
+// sopsEncrypt encrypts the values of the fields matching sopsEncryptedRegex
+// with a new data key, sets the digest annotation, the checksum annotation
+// with checksum, and the sops metadata
+func sopsEncrypt(node *kyaml.RNode, recipientNames []string, recipients []age.Recipient, checksum bool, now time.Time) error {
+	dataKey := make([]byte, sopsDataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "unable to generate data key")
+	}
+	salt := make([]byte, sopsDigestSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return errors.Wrap(err, "unable to generate digest salt")
+	}
+	digest, err := sopsDigest(node, salt)
+	if err != nil {
+		return err
+	}
+	if err := node.PipeE(kyaml.SetAnnotation(annotationKonvertSOPSDigest, digest)); err != nil {
+		return errors.Wrapf(err, "unable to set annotation %s", annotationKonvertSOPSDigest)
+	}
+
+	// sops would encrypt the comments of the encrypted fields, they may
+	// describe the values and are dropped
+	content := node.YNode().Content
+	for i := 0; i+1 < len(content); i += 2 {
+		if sopsEncryptedFields.MatchString(content[i].Value) {
+			clearComments(content[i+1])
+		}
+	}
+	plaintext := node.Copy()
+	err = sopsWalk(node.YNode(), nil, func(value *kyaml.Node, path []string) error {
+		if !sopsEncrypted(path) {
+			return nil
+		}
+		return sopsEncryptValue(value, dataKey, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the MAC covers the annotations, the checksum of the encrypted content
+	// is set first
+	if checksum {
+		if _, err := (ChecksumAnnotationSetter{}).Filter(node); err != nil {
+			return err
+		}
+		value := node.GetAnnotations()[annotationKonvertChecksum]
+		if err := plaintext.PipeE(kyaml.SetAnnotation(annotationKonvertChecksum, value)); err != nil {
+			return errors.Wrapf(err, "unable to set annotation %s", annotationKonvertChecksum)
+		}
+	}
+	mac, err := sopsMAC(plaintext)
+	if err != nil {
+		return err
+	}
+
+	lastModified := now.UTC().Format(time.RFC3339)
+	metadata := sopsMetadata{
+		LastModified:   lastModified,
+		EncryptedRegex: sopsEncryptedRegex,
+		Version:        sopsVersion,
+	}
+	metadata.MAC, err = sopsEncryptBytes([]byte(mac), "str", dataKey, lastModified)
+	if err != nil {
+		return err
+	}
+	for i, recipient := range recipients {
+		enc, err := ageEncrypt(dataKey, recipient)
+		if err != nil {
+			return errors.Wrapf(err, "unable to encrypt data key to %s", recipientNames[i])
+		}
+		metadata.Age = append(metadata.Age, sopsAgeKey{Recipient: recipientNames[i], Enc: enc})
+	}
+
+	field := &kyaml.Node{}
+	if err := field.Encode(metadata); err != nil {
+		return errors.Wrap(err, "unable to encode sops metadata")
+	}
+	if err := node.PipeE(kyaml.SetField(sopsField, kyaml.NewRNode(field))); err != nil {
+		return errors.Wrap(err, "unable to set sops metadata")
+	}
+	return nil
+}
+
+// sopsDecrypt returns a copy of node with the values decrypted with one of
+// identities and without the sops metadata, the MAC of the content is checked
+func sopsDecrypt(node *kyaml.RNode, identities []age.Identity) (*kyaml.RNode, error) {
+	metadata, err := sopsMetadataOf(node)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.EncryptedRegex != sopsEncryptedRegex {
+		return nil, fmt.Errorf("unsupported encrypted_regex %q", metadata.EncryptedRegex)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identity, set %s or %s", envSOPSAgeKey, envSOPSAgeKeyFile)
+	}
+	var dataKey []byte
+	for _, key := range metadata.Age {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(key.Enc)), identities...)
+		if err != nil {
+			continue
+		}
+		if dataKey, err = io.ReadAll(r); err != nil {
+			return nil, errors.Wrap(err, "unable to read data key")
+		}
+		break
+	}
+	if dataKey == nil {
+		return nil, fmt.Errorf("none of the age identities decrypts the data key")
+	}
+
+	decrypted := node.Copy()
+	if err := decrypted.PipeE(kyaml.Clear(sopsField)); err != nil {
+		return nil, errors.Wrap(err, "unable to clear sops metadata")
+	}
+	err = sopsWalk(decrypted.YNode(), nil, func(value *kyaml.Node, path []string) error {
+		if !sopsEncrypted(path) || value.Value == "" || value.ShortTag() == kyaml.NodeTagNull {
+			return nil
+		}
+		plaintext, valueType, err := sopsDecryptBytes(value.Value, dataKey, sopsAdditionalData(path))
+		if err != nil {
+			return errors.Wrapf(err, "unable to decrypt %s", strings.Join(path, "."))
+		}
+		setSOPSValue(value, plaintext, valueType)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac, _, err := sopsDecryptBytes(metadata.MAC, dataKey, metadata.LastModified)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt MAC")
+	}
+	actual, err := sopsMAC(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	if string(mac) != actual {
+		return nil, fmt.Errorf("MAC mismatch, %s %s was modified", node.GetKind(), node.GetName())
+	}
+	return decrypted, nil
+}
+
+// sopsWalk calls fn with each scalar value of node and the keys leading to
+// it, in the order of the document. The sops metadata is skipped.
+func sopsWalk(node *kyaml.Node, path []string, fn func(value *kyaml.Node, path []string) error) error {
+	switch node.Kind {
+	case kyaml.DocumentNode, kyaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := sopsWalk(n, path, fn); err != nil {
+				return err
+			}
+		}
+	case kyaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if len(path) == 0 && key == sopsField {
+				continue
+			}
+			if err := sopsWalk(node.Content[i+1], append(path[:len(path):len(path)], key), fn); err != nil {
+				return err
+			}
+		}
+	case kyaml.ScalarNode:
+		return fn(node, path)
+	}
+	return nil
+}
+
+func clearComments(node *kyaml.Node) {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	for _, n := range node.Content {
+		clearComments(n)
+	}
+}
+
+func sopsEncrypted(path []string) bool {
+	for _, key := range path {
+		if sopsEncryptedFields.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func sopsAdditionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// clearReaderAnnotations clears the annotations added by the readers and the
+// pipeline, which are not written to disk
+func clearReaderAnnotations(node *kyaml.RNode) error {
+	for annotation := range node.GetAnnotations() {
+		if annotation == annotationKonvertChecksum ||
+			!strings.HasPrefix(annotation, internalAnnotationPrefix) && !slices.Contains(checksumIgnoredAnnotations, annotation) {
+			continue
+		}
+		if err := node.PipeE(kyaml.ClearAnnotation(annotation)); err != nil {
+			return errors.Wrapf(err, "unable to clear annotation %s", annotation)
+		}
+	}
+	return nil
+}
+
+// sopsMAC returns the MAC of the values of node as written to disk
+func sopsMAC(node *kyaml.RNode) (string, error) {
+	content := node.Copy()
+	if err := clearReaderAnnotations(content); err != nil {
+		return "", err
+	}
+	if err := kyaml.ClearEmptyAnnotations(content); err != nil {
+		return "", errors.Wrap(err, "unable to clear empty annotations")
+	}
+	hash := sha512.New()
+	err := sopsWalk(content.YNode(), nil, func(value *kyaml.Node, _ []string) error {
+		plaintext, valueType := sopsPlaintext(value)
+		if valueType == "bool" {
+			// sops hashes booleans as python prints them
+			plaintext = []byte("False")
+			if value.Value == "true" {
+				plaintext = []byte("True")
+			}
+		}
+		_, err := hash.Write(plaintext)
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to compute MAC")
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil)), nil
+}
+
+// sopsPlaintext returns the plaintext of a value and its sops type
+func sopsPlaintext(value *kyaml.Node) ([]byte, string) {
+	switch value.ShortTag() {
+	case kyaml.NodeTagInt:
+		if i, err := strconv.ParseInt(value.Value, 0, 64); err == nil {
+			return []byte(strconv.FormatInt(i, 10)), "int"
+		}
+	case kyaml.NodeTagFloat:
+		if f, err := strconv.ParseFloat(value.Value, 64); err == nil {
+			return []byte(strconv.FormatFloat(f, 'f', -1, 64)), "float"
+		}
+	case kyaml.NodeTagBool:
+		if b, err := strconv.ParseBool(value.Value); err == nil {
+			return []byte(strconv.FormatBool(b)), "bool"
+		}
+	case kyaml.NodeTagNull:
+		return nil, ""
+	}
+	return []byte(value.Value), "str"
+}
+
+func setSOPSValue(value *kyaml.Node, plaintext []byte, valueType string) {
+	value.Value = string(plaintext)
+	value.Style = 0
+	switch valueType {
+	case "int":
+		value.Tag = kyaml.NodeTagInt
+	case "float":
+		value.Tag = kyaml.NodeTagFloat
+	case "bool":
+		value.Tag = kyaml.NodeTagBool
+		b, _ := strconv.ParseBool(value.Value)
+		value.Value = strconv.FormatBool(b)
+	default:
+		value.Tag = kyaml.NodeTagString
+	}
+}
+
+// sopsEncryptValue replaces a value with its ciphertext, empty values are
+// left as is like sops does
+func sopsEncryptValue(value *kyaml.Node, dataKey []byte, path []string) error {
+	plaintext, valueType := sopsPlaintext(value)
+	if len(plaintext) == 0 {
+		return nil
+	}
+	ciphertext, err := sopsEncryptBytes(plaintext, valueType, dataKey, sopsAdditionalData(path))
+	if err != nil {
+		return errors.Wrapf(err, "unable to encrypt %s", strings.Join(path, "."))
+	}
+	value.Value = ciphertext
+	value.Tag = kyaml.NodeTagString
+	value.Style = 0
+	return nil
+}
+
+func sopsEncryptBytes(plaintext []byte, valueType string, dataKey []byte, additionalData string) (string, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType), nil
+}
+
+func sopsDecryptBytes(ciphertext string, dataKey []byte, additionalData string) ([]byte, string, error) {
+	matches := sopsEncryptedValue.FindStringSubmatch(ciphertext)
+	if matches == nil {
+		return nil, "", fmt.Errorf("value is not encrypted")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return nil, "", errors.Wrap(err, "unable to decode encrypted value")
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, "", err
+	}
+	return plaintext, matches[4], nil
+}
+
+// ageEncrypt returns the armored encryption of the data key to recipient
+func ageEncrypt(dataKey []byte, recipient age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
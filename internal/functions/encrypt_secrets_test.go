@@ -0,0 +1,499 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testSecretsInput = `apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql
+  namespace: db
+  annotations:
+    internal.config.kubernetes.io/path: 'upstream/secret-mysql.yaml'
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+type: Opaque
+data:
+  mysql-root-password: cm9vdA==
+  mysql-password: cGFzc3dvcmQ=
+  empty: ""
+stringData:
+  # the user of the application
+  username: app
+  port: 3306
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mysql
+  namespace: db
+  annotations:
+    internal.config.kubernetes.io/path: 'upstream/configmap-mysql.yaml'
+    konvert.kumorilabs.io/chart: 'https://charts.bitnami.com/bitnami,mysql'
+data:
+  my.cnf: '[mysqld]'
+`
+
+func testAgeIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err, "GenerateX25519Identity")
+	return identity
+}
+
+func testEncryptSecrets(t *testing.T, fn *EncryptSecretsFunction, input string) []*kyaml.RNode {
+	t.Helper()
+	items, err := kio.ParseAll(input)
+	require.NoError(t, err, "ParseAll")
+	items, err = fn.Filter(items)
+	require.NoError(t, err, "Filter")
+	return items
+}
+
+// testWrittenNodes returns the nodes as they are read back from disk
+func testWrittenNodes(t *testing.T, nodes []*kyaml.RNode) []*kyaml.RNode {
+	t.Helper()
+	var content []string
+	for _, node := range nodes {
+		content = append(content, node.MustString())
+	}
+	read, err := kio.ParseAll(strings.Join(content, "---\n"))
+	require.NoError(t, err, "ParseAll")
+	return read
+}
+
+func TestEncryptSecretsFunction(t *testing.T) {
+	alice, bob := testAgeIdentity(t), testAgeIdentity(t)
+	fn := &EncryptSecretsFunction{
+		Age:        []string{alice.Recipient().String(), bob.Recipient().String()},
+		identities: []age.Identity{alice},
+	}
+	output := testEncryptSecrets(t, fn, testSecretsInput)
+	require.Len(t, output, 2, "output")
+	assert.Empty(t, fn.Results(), "results")
+
+	secret, configMap := output[0], output[1]
+	assert.Nil(t, configMap.Field(sopsField), "configmap is not encrypted")
+	assert.Equal(t, "[mysqld]", configMap.GetDataMap()["my.cnf"], "configmap")
+	assert.NotContains(t, secret.MustString(), "cm9vdA==", "plaintext")
+	assert.NotContains(t, secret.MustString(), "the user of the application", "comments")
+	for key, value := range secret.GetDataMap() {
+		if key == "empty" {
+			assert.Equal(t, "", value, "empty values are not encrypted")
+			continue
+		}
+		assert.True(t, strings.HasPrefix(value, "ENC[AES256_GCM,data:"), key)
+		assert.True(t, strings.HasSuffix(value, ",type:str]"), key)
+	}
+	port, err := secret.Pipe(kyaml.Lookup("stringData", "port"))
+	require.NoError(t, err, "Lookup")
+	assert.True(t, strings.HasSuffix(port.YNode().Value, ",type:int]"), "port")
+	assert.Equal(t, "Opaque", secret.Field("type").Value.YNode().Value, "type")
+	assert.Equal(t, "mysql", secret.GetName(), "name")
+
+	metadata, err := sopsMetadataOf(secret)
+	require.NoError(t, err, "sopsMetadataOf")
+	require.Len(t, metadata.Age, 2, "age")
+	assert.Equal(t, alice.Recipient().String(), metadata.Age[0].Recipient, "recipient")
+	assert.True(t, strings.HasPrefix(metadata.Age[0].Enc, "-----BEGIN AGE ENCRYPTED FILE-----\n"), "enc")
+	assert.Equal(t, sopsEncryptedRegex, metadata.EncryptedRegex, "encrypted_regex")
+	assert.Equal(t, sopsVersion, metadata.Version, "version")
+
+	// the checksum covers the encrypted content
+	modified, err := IsModifiedSinceRender(secret)
+	require.NoError(t, err, "IsModifiedSinceRender")
+	assert.False(t, modified, "modified")
+
+	// every recipient decrypts the content as rendered
+	expected, err := kio.ParseAll(testSecretsInput)
+	require.NoError(t, err, "ParseAll")
+	for _, identity := range []age.Identity{alice, bob} {
+		decrypted, err := sopsDecrypt(testWrittenNodes(t, output)[0], []age.Identity{identity})
+		require.NoError(t, err, "sopsDecrypt")
+		assert.Equal(t, expected[0].GetDataMap(), decrypted.GetDataMap(), "data")
+		username, err := decrypted.Pipe(kyaml.Lookup("stringData", "username"))
+		require.NoError(t, err, "Lookup")
+		assert.Equal(t, "app", username.YNode().Value, "username")
+		port, err := decrypted.Pipe(kyaml.Lookup("stringData", "port"))
+		require.NoError(t, err, "Lookup")
+		assert.Equal(t, "3306", port.YNode().Value, "port")
+		assert.Equal(t, kyaml.NodeTagInt, port.YNode().ShortTag(), "port")
+	}
+
+	_, err = sopsDecrypt(secret, []age.Identity{testAgeIdentity(t)})
+	require.NotNil(t, err, "other identity")
+	assert.Contains(t, err.Error(), "none of the age identities decrypts the data key", "other identity")
+}
+
+// testSetAnnotation returns a copy of nodes with annotation set to value, or
+// cleared when value is empty
+func testSetAnnotation(t *testing.T, nodes []*kyaml.RNode, annotation, value string) []*kyaml.RNode {
+	t.Helper()
+	var copies []*kyaml.RNode
+	for _, node := range nodes {
+		node = node.Copy()
+		var filter kyaml.Filter = kyaml.SetAnnotation(annotation, value)
+		if value == "" {
+			filter = kyaml.ClearAnnotation(annotation)
+		}
+		_, err := node.Pipe(filter)
+		require.NoError(t, err, "Pipe")
+		copies = append(copies, node)
+	}
+	return copies
+}
+
+func TestEncryptSecretsFunctionPrevious(t *testing.T) {
+	alice, bob := testAgeIdentity(t), testAgeIdentity(t)
+	previous := testWrittenNodes(t, testEncryptSecrets(t, &EncryptSecretsFunction{
+		Age:        []string{alice.Recipient().String()},
+		identities: []age.Identity{alice},
+	}, testSecretsInput))
+
+	var tests = []struct {
+		name        string
+		input       string
+		recipients  []string
+		identities  []age.Identity
+		previous    []*kyaml.RNode
+		unchanged   bool
+		expectedMsg string
+	}{
+		{
+			name:       "unchanged",
+			input:      testSecretsInput,
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{alice},
+			previous:   previous,
+			unchanged:  true,
+		},
+		{
+			name:       "changed-value",
+			input:      strings.Replace(testSecretsInput, "cGFzc3dvcmQ=", "Y2hhbmdlZA==", 1),
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{alice},
+			previous:   previous,
+		},
+		{
+			name:       "changed-label",
+			input:      strings.Replace(testSecretsInput, "  namespace: db\n", "  namespace: db\n  labels:\n    app: mysql\n", 1),
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{alice},
+			previous:   previous,
+		},
+		{
+			name:       "new-recipient",
+			input:      testSecretsInput,
+			recipients: []string{alice.Recipient().String(), bob.Recipient().String()},
+			identities: []age.Identity{alice},
+			previous:   previous,
+		},
+		{
+			name:       "no-identity",
+			input:      testSecretsInput,
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{bob},
+			previous:   previous,
+			unchanged:  true,
+		},
+		{
+			name:       "no-identity-changed-value",
+			input:      strings.Replace(testSecretsInput, "cGFzc3dvcmQ=", "Y2hhbmdlZA==", 1),
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{bob},
+			previous:   previous,
+		},
+		{
+			name:        "no-digest-no-identity",
+			input:       testSecretsInput,
+			recipients:  []string{alice.Recipient().String()},
+			identities:  []age.Identity{bob},
+			previous:    testSetAnnotation(t, previous, annotationKonvertSOPSDigest, ""),
+			expectedMsg: "encrypted again, unable to compare with the previous version: none of the age identities decrypts the data key",
+		},
+		{
+			name:        "invalid-digest",
+			input:       testSecretsInput,
+			recipients:  []string{alice.Recipient().String()},
+			identities:  []age.Identity{alice},
+			previous:    testSetAnnotation(t, previous, annotationKonvertSOPSDigest, "sha256:abc"),
+			expectedMsg: `encrypted again, unable to compare with the previous version: invalid konvert.kumorilabs.io/sops-digest "sha256:abc"`,
+		},
+		{
+			name:       "new-secret",
+			input:      testSecretsInput,
+			recipients: []string{alice.Recipient().String()},
+			identities: []age.Identity{alice},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := &EncryptSecretsFunction{
+				Age:        test.recipients,
+				identities: test.identities,
+				previous:   test.previous,
+			}
+			output := testWrittenNodes(t, testEncryptSecrets(t, fn, test.input))
+			before, err := previous[0].Pipe(kyaml.Lookup("data", "mysql-root-password"))
+			require.NoError(t, err, "Lookup")
+			after, err := output[0].Pipe(kyaml.Lookup("data", "mysql-root-password"))
+			require.NoError(t, err, "Lookup")
+			if test.unchanged {
+				assert.Equal(t, before.YNode().Value, after.YNode().Value, "ciphertext")
+				assert.Equal(t, previous[0].Field(sopsField).Value.MustString(), output[0].Field(sopsField).Value.MustString(), "sops")
+				assert.Equal(t, previous[0].GetAnnotations()[annotationKonvertChecksum], output[0].GetAnnotations()[annotationKonvertChecksum], "checksum")
+			} else {
+				assert.NotEqual(t, before.YNode().Value, after.YNode().Value, "ciphertext")
+			}
+
+			if test.expectedMsg == "" {
+				assert.Empty(t, fn.Results(), "results")
+			} else {
+				require.Len(t, fn.Results(), 1, "results")
+				assert.Equal(t, framework.Warning, fn.Results()[0].Severity, "severity")
+				assert.Equal(t, test.expectedMsg, fn.Results()[0].Message, "message")
+			}
+
+			// the ciphertext is always decrypted to the rendered content
+			decrypted, err := sopsDecrypt(output[0], []age.Identity{alice})
+			require.NoError(t, err, "sopsDecrypt")
+			expected, err := kio.ParseAll(test.input)
+			require.NoError(t, err, "ParseAll")
+			assert.Equal(t, expected[0].GetDataMap(), decrypted.GetDataMap(), "data")
+			assert.Equal(t, expected[0].GetLabels(), decrypted.GetLabels(), "labels")
+		})
+	}
+}
+
+// TestSOPSDecryptEncryptSecrets checks that sops itself decrypts the Secrets,
+// the MAC and metadata included
+func TestSOPSDecryptEncryptSecrets(t *testing.T) {
+	sops, err := exec.LookPath("sops")
+	if err != nil {
+		t.Skip("sops is not installed")
+	}
+	alice := testAgeIdentity(t)
+	output := testEncryptSecrets(t, &EncryptSecretsFunction{
+		Age:        []string{alice.Recipient().String()},
+		identities: []age.Identity{alice},
+	}, testSecretsInput)
+
+	// as written to disk
+	secret := output[0].Copy()
+	require.NoError(t, clearReaderAnnotations(secret), "clearReaderAnnotations")
+	require.NoError(t, kyaml.ClearEmptyAnnotations(secret), "ClearEmptyAnnotations")
+	path := filepath.Join(t.TempDir(), "secret-mysql.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(secret.MustString()), 0600), "WriteFile")
+
+	cmd := exec.Command(sops, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	cmd.Env = append(os.Environ(), envSOPSAgeKey+"="+alice.String())
+	decrypted, err := cmd.Output()
+	require.NoError(t, err, "sops --decrypt")
+
+	nodes, err := kio.ParseAll(string(decrypted))
+	require.NoError(t, err, "ParseAll")
+	expected, err := kio.ParseAll(testSecretsInput)
+	require.NoError(t, err, "ParseAll")
+	assert.Equal(t, expected[0].GetDataMap(), nodes[0].GetDataMap(), "data")
+	stringData, err := nodes[0].Pipe(kyaml.Lookup("stringData"))
+	require.NoError(t, err, "Lookup")
+	require.NotNil(t, stringData, "stringData")
+	values, err := stringData.Map()
+	require.NoError(t, err, "Map")
+	assert.Equal(t, map[string]interface{}{"username": "app", "port": 3306}, values, "stringData")
+}
+
+func TestSOPSDecryptModified(t *testing.T) {
+	alice := testAgeIdentity(t)
+	output := testEncryptSecrets(t, &EncryptSecretsFunction{
+		Age:        []string{alice.Recipient().String()},
+		Checksum:   true,
+		identities: []age.Identity{alice},
+	}, testSecretsInput)
+	secret := testWrittenNodes(t, output)[0]
+
+	// the MAC covers the unencrypted values
+	require.NoError(t, secret.PipeE(kyaml.SetLabel("app", "mysql")), "SetLabel")
+	_, err := sopsDecrypt(secret, []age.Identity{alice})
+	require.NotNil(t, err, "sopsDecrypt")
+	assert.Contains(t, err.Error(), "MAC mismatch", "sopsDecrypt")
+	modified, err := IsModifiedSinceRender(secret)
+	require.NoError(t, err, "IsModifiedSinceRender")
+	assert.True(t, modified, "modified")
+
+	// values are bound to their key
+	secret = testWrittenNodes(t, output)[0]
+	password, err := secret.Pipe(kyaml.Lookup("data", "mysql-password"))
+	require.NoError(t, err, "Lookup")
+	rootPassword, err := secret.Pipe(kyaml.Lookup("data", "mysql-root-password"))
+	require.NoError(t, err, "Lookup")
+	password.YNode().Value = rootPassword.YNode().Value
+	_, err = sopsDecrypt(secret, []age.Identity{alice})
+	require.NotNil(t, err, "sopsDecrypt")
+	assert.Contains(t, err.Error(), "unable to decrypt data.mysql-password", "sopsDecrypt")
+}
+
+func TestEncryptSecretsFunctionSharedFile(t *testing.T) {
+	alice := testAgeIdentity(t)
+	input := strings.Replace(testSecretsInput, "upstream/configmap-mysql.yaml", "upstream/secret-mysql.yaml", 1)
+	items, err := kio.ParseAll(input)
+	require.NoError(t, err, "ParseAll")
+	fn := &EncryptSecretsFunction{
+		Age:        []string{alice.Recipient().String()},
+		identities: []age.Identity{alice},
+	}
+	_, err = fn.Filter(items)
+	require.NotNil(t, err, "Filter")
+	assert.Contains(t, err.Error(), "encrypted Secrets must be written to their own file", "Filter")
+}
+
+func TestAgeIdentities(t *testing.T) {
+	alice := testAgeIdentity(t)
+	keysFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keysFile, []byte("# created: 2026-10-17\n"+alice.String()+"\n"), 0600), "WriteFile")
+
+	var tests = []struct {
+		name          string
+		key           string
+		keyFile       string
+		expected      int
+		expectedError string
+	}{
+		{name: "key", key: alice.String(), expected: 1},
+		{name: "key-file", keyFile: keysFile, expected: 1},
+		{name: "invalid-key", key: "AGE-SECRET-KEY-INVALID", expectedError: "unable to parse SOPS_AGE_KEY"},
+		{name: "missing-key-file", keyFile: filepath.Join(t.TempDir(), "missing.txt"), expectedError: "unable to open age keys file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(envSOPSAgeKey, test.key)
+			t.Setenv(envSOPSAgeKeyFile, test.keyFile)
+			identities, err := ageIdentities()
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Len(t, identities, test.expected, test.name)
+		})
+	}
+}
+
+func TestKonvertSOPSConfig(t *testing.T) {
+	recipient := testAgeIdentity(t).Recipient().String()
+	var tests = []struct {
+		name          string
+		spec          string
+		expectedError string
+	}{
+		{name: "age", spec: fmt.Sprintf("  sops:\n    age:\n    - %s\n", recipient)},
+		{name: "no-recipient", spec: "  sops: {}\n", expectedError: "spec.sops: age must list at least one recipient"},
+		{name: "invalid-recipient", spec: "  sops:\n    age:\n    - age1invalid\n", expectedError: `spec.sops: invalid age recipient "age1invalid"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: ./local-chart
+  kubeVersion: "1.27"
+` + test.spec)
+			require.NoError(t, err, "Parse")
+			err = Konvert("./examples/konvert.yaml").Config(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+		})
+	}
+}
+
+func TestKonvertFilterSOPS(t *testing.T) {
+	c, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/secret.yaml",
+		Data: []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}-credentials
+data:
+  password: {{ "secret" | b64enc }}
+`),
+	})
+	dir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, dir), "SaveDir")
+
+	alice := testAgeIdentity(t)
+	t.Setenv(envSOPSAgeKey, alice.String())
+	newFn := func() *KonvertFunction {
+		input, err := kyaml.Parse(fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+spec:
+  chart: %s
+  namespace: web
+  kubeVersion: "1.27"
+  sops:
+    age:
+    - %s
+`, filepath.Join(dir, c.Name()), alice.Recipient().String()))
+		require.NoError(t, err, "Parse")
+		fn := Konvert("./examples/konvert.yaml")
+		require.NoError(t, fn.Config(input), "Config")
+		return fn
+	}
+
+	nodes, err := newFn().Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	var secret *kyaml.RNode
+	for _, node := range nodes {
+		if isSecret(node) {
+			secret = node
+		}
+	}
+	require.NotNil(t, secret, "secret")
+	require.True(t, isSOPSEncrypted(secret), "encrypted")
+	modified, err := IsModifiedSinceRender(secret)
+	require.NoError(t, err, "IsModifiedSinceRender")
+	assert.False(t, modified, "modified")
+
+	// a second run keeps the ciphertext
+	written := testWrittenNodes(t, nodes)
+	for _, node := range written {
+		require.NoError(t, node.PipeE(kyaml.ClearAnnotation(kioutil.IndexAnnotation)), "ClearAnnotation")
+	}
+	again, err := newFn().Filter(written)
+	require.NoError(t, err, "Filter")
+	for _, node := range again {
+		if isSecret(node) {
+			assert.Equal(t, secret.GetDataMap(), node.GetDataMap(), "ciphertext")
+			assert.Equal(t, secret.Field(sopsField).Value.MustString(), node.Field(sopsField).Value.MustString(), "sops")
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/kumorilabs/konvert/internal/cache"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// EnvOffline disables network access when set to true. Charts are only
+	// resolved from vendored archives and the chart cache.
+	EnvOffline = "KONVERT_OFFLINE"
+
+	defaultVendorDir = "charts"
+	// provenanceExt is the extension Helm gives chart provenance files
+	provenanceExt = ".prov"
+)
+
+// IsOffline reports whether offline mode is enabled with the environment
+func IsOffline() (bool, error) {
+	value, ok := os.LookupEnv(EnvOffline)
+	if !ok || value == "" {
+		return false, nil
+	}
+	offline, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid %s", EnvOffline)
+	}
+	return offline, nil
+}
+
+// Options configure how the functions access charts. The environment is
+// used for the settings that are not set, which is how they are configured
+// in fn mode.
+type Options struct {
+	// CacheDir is the chart cache directory, see cache.Open
+	CacheDir string
+	// Offline disables network access, see EnvOffline
+	Offline bool
+}
+
+// chartCache returns the chart cache in CacheDir
+func (o Options) chartCache() (*cache.Cache, error) {
+	return cache.Open(o.CacheDir)
+}
+
+// offline reports whether offline mode is enabled with Offline or the
+// environment
+func (o Options) offline() (bool, error) {
+	if o.Offline {
+		return true, nil
+	}
+	return IsOffline()
+}
+
+// fetchedChart is a chart archive and the URL it was downloaded from. The URL
+// is unknown for vendored archives.
+type fetchedChart struct {
+	archive string
+	url     string
+	// provenance is the path of the provenance file, only fetched when the
+	// chart is verified
+	provenance string
+}
+
+// fetchChart returns the chart archive, from a vendored archive or the chart
+// cache when possible, otherwise downloading it to dest and adding it to the
+// cache
+func (f *RenderHelmChartFunction) fetchChart(settings *cli.EnvSettings, dest string) (*fetchedChart, error) {
+	fnlog := log.WithFields(log.Fields{
+		"fn":      f.Name(),
+		"repo":    f.Repo,
+		"chart":   f.Chart,
+		"version": f.Version,
+	})
+
+	chartCache, err := f.options.chartCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to configure chart cache")
+	}
+
+	offline, err := f.options.offline()
+	if err != nil {
+		return nil, err
+	}
+
+	if fetched, err := f.localChart(chartCache, f.Version); err != nil || fetched != nil {
+		return fetched, err
+	}
+	if offline {
+		return f.fetchChartOffline(chartCache)
+	}
+
+	creds, err := f.Auth.credentials(f.BaseDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve repository credentials")
+	}
+
+	regcreds, err := f.Registry.credentials(f.BaseDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve registry credentials")
+	}
+
+	getters := getter.All(settings)
+	regclient, err := regcreds.client(settings.RegistryConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting registry client")
+	}
+	c := downloader.ChartDownloader{
+		Out:              os.Stderr,
+		Getters:          getters,
+		RepositoryConfig: settings.RepositoryConfig,
+		RepositoryCache:  settings.RepositoryCache,
+		RegistryClient:   regclient,
+	}
+	if f.Verify {
+		// the provenance is verified the same way for downloaded, cached
+		// and vendored charts, see verifyChart
+		c.Verify = downloader.VerifyLater
+	}
+
+	var (
+		chartURL string
+		version  = f.Version
+	)
+	if registry.IsOCI(f.Repo) {
+		// OCI registries have no index, the chart is a repository
+		// within the registry (optionally pinned with @sha256:...)
+		chartURL = strings.TrimSuffix(f.Repo, "/") + "/" + f.Chart
+	} else if f.Repo != "" {
+		// if repo is specified, resolve url from repo and chart name
+		fnlog.Debug("resolving chart url from repo")
+		cv, err := f.resolveChartVersion(chartCache, creds, settings)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to resolve chart url")
+		}
+		chartURL, err = repo.ResolveReferenceURL(f.Repo, cv.URLs[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to resolve chart url")
+		}
+
+		// a version range may resolve to a chart that is already available
+		version = cv.Version
+		if fetched, err := f.localChart(chartCache, version); err != nil || fetched != nil {
+			return fetched, err
+		}
+	} else {
+		// otherwise, assume Chart is the full chart URL
+		chartURL = f.Chart
+	}
+	c.Options = append(creds.getterOptions(f.Repo, chartURL), regcreds.getterOptions(regclient)...)
+
+	fnlog.WithField("url", chartURL).Debug("downloading chart from url")
+	archive, _, err := c.DownloadTo(chartURL, version, dest)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to download chart")
+	}
+
+	fetched := &fetchedChart{archive: archive, url: chartURL}
+	if _, err := os.Stat(archive + provenanceExt); err == nil {
+		fetched.provenance = archive + provenanceExt
+	}
+	if !isCacheable(f.Chart, version) {
+		return fetched, nil
+	}
+	cached, err := chartCache.PutChart(f.Repo, f.Chart, version, chartURL, archive)
+	if err != nil {
+		// the cache is an optimization, rendering can continue without it
+		fnlog.WithError(err).Warn("unable to cache chart")
+		return fetched, nil
+	}
+	fetched.archive = cached
+	return fetched, nil
+}
+
+// fetchChartOffline resolves version ranges with the cached repository index
+// (however old it is) and fails when the chart is not available locally
+func (f *RenderHelmChartFunction) fetchChartOffline(chartCache *cache.Cache) (*fetchedChart, error) {
+	version := f.Version
+	if f.Repo != "" && !registry.IsOCI(f.Repo) && !isCacheable(f.Chart, version) {
+		indexPath, _ := chartCache.Index(f.Repo)
+		if cv, err := getChartVersion(indexPath, f.Chart, version); indexPath != "" && err == nil {
+			version = cv.Version
+			if fetched, err := f.localChart(chartCache, version); err != nil || fetched != nil {
+				return fetched, err
+			}
+		}
+	}
+
+	ref := fmt.Sprintf("chart %q", f.Chart)
+	if version != "" {
+		ref = fmt.Sprintf("%s version %q", ref, version)
+	}
+	if f.Repo != "" {
+		ref = fmt.Sprintf("%s from %s", ref, f.Repo)
+	}
+	return nil, errors.Errorf(
+		"%s is not available offline: it is neither vendored in %s nor cached in %s",
+		ref,
+		f.vendorDirectory(),
+		chartCache.Dir,
+	)
+}
+
+// localChart returns the vendored or cached chart for version, or nil when
+// it is not available locally
+func (f *RenderHelmChartFunction) localChart(chartCache *cache.Cache, version string) (*fetchedChart, error) {
+	if archive := f.vendoredChart(version); archive != "" {
+		log.WithFields(log.Fields{
+			"fn":      f.Name(),
+			"archive": archive,
+		}).Debug("using vendored chart")
+		fetched := &fetchedChart{archive: archive}
+		if _, err := os.Stat(archive + provenanceExt); err == nil {
+			fetched.provenance = archive + provenanceExt
+		}
+		return fetched, nil
+	}
+
+	if !isCacheable(f.Chart, version) {
+		return nil, nil
+	}
+	entry, err := chartCache.Chart(f.Repo, f.Chart, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read chart cache")
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	if f.Verify && entry.ProvenancePath == "" {
+		// cached before verification was enabled, download it again
+		return nil, nil
+	}
+	log.WithFields(log.Fields{
+		"fn":      f.Name(),
+		"archive": entry.Path,
+	}).Debug("using cached chart")
+	return &fetchedChart{
+		archive:    entry.Path,
+		url:        entry.URL,
+		provenance: entry.ProvenancePath,
+	}, nil
+}
+
+// vendoredChart returns the path of the vendored archive for version, or an
+// empty string when the chart is not vendored
+func (f *RenderHelmChartFunction) vendoredChart(version string) string {
+	name := vendoredChartName(f.Chart, version)
+	if name == "" {
+		return ""
+	}
+	archive := filepath.Join(f.vendorDirectory(), name)
+	if info, err := os.Stat(archive); err != nil || info.IsDir() {
+		return ""
+	}
+	return archive
+}
+
+// vendorDirectory returns the directory of vendored archives, relative to the
+// Konvert file unless it is absolute
+func (f *RenderHelmChartFunction) vendorDirectory() string {
+	dir := f.VendorDir
+	if dir == "" {
+		dir = defaultVendorDir
+	}
+	return resolvePath(dir, f.BaseDirectory)
+}
+
+// vendoredChartName returns the file name of a vendored archive,
+// <name>-<version>.tgz, or an empty string for references that cannot be
+// vendored (version ranges and chart archive URLs)
+func vendoredChartName(chart, version string) string {
+	if !isExactVersion(version) {
+		return ""
+	}
+	name := path.Base(strings.SplitN(chart, "@", 2)[0])
+	if name == "." || name == "/" || strings.HasSuffix(name, ".tgz") {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s.tgz", name, version)
+}
+
+// resolveChartVersion finds the chart version in the repository index. The
+// cached index is used while it is fresh, and refreshed when it does not
+// contain the requested version yet.
+func (f *RenderHelmChartFunction) resolveChartVersion(
+	chartCache *cache.Cache,
+	creds repoCredentials,
+	settings *cli.EnvSettings,
+) (*repo.ChartVersion, error) {
+	indexPath, fresh := chartCache.Index(f.Repo)
+	if fresh {
+		if cv, err := getChartVersion(indexPath, f.Chart, f.Version); err == nil {
+			return cv, nil
+		}
+	}
+
+	indexPath, err := f.downloadIndex(chartCache, creds, settings)
+	if err != nil {
+		return nil, err
+	}
+	cv, err := getChartVersion(indexPath, f.Chart, f.Version)
+	if err != nil {
+		return nil, errors.Errorf("%s in %s repository", err, f.Repo)
+	}
+	return cv, nil
+}
+
+// downloadIndex downloads the repository index, adds it to the cache and
+// returns its path
+func (f *RenderHelmChartFunction) downloadIndex(
+	chartCache *cache.Cache,
+	creds repoCredentials,
+	settings *cli.EnvSettings,
+) (string, error) {
+	r, err := repo.NewChartRepository(&repo.Entry{
+		Name:                  "konvert",
+		URL:                   f.Repo,
+		Username:              creds.username,
+		Password:              creds.password,
+		PassCredentialsAll:    creds.passCredentialsAll,
+		CertFile:              creds.certFile,
+		KeyFile:               creds.keyFile,
+		CAFile:                creds.caFile,
+		InsecureSkipTLSverify: creds.insecureSkipTLSVerify,
+	}, getter.All(settings))
+	if err != nil {
+		return "", err
+	}
+	r.CachePath = settings.RepositoryCache
+	downloaded, err := r.DownloadIndexFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", f.Repo)
+	}
+
+	if data, err := os.ReadFile(downloaded); err == nil {
+		if cached, err := chartCache.PutIndex(f.Repo, data); err == nil {
+			return cached, nil
+		}
+	}
+	return downloaded, nil
+}
+
+// getChartVersion finds a chart version (or the latest version matching a
+// range) in an index file
+func getChartVersion(indexPath, chart, version string) (*repo.ChartVersion, error) {
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	cv, err := index.Get(chart, version)
+	if err != nil {
+		if version != "" {
+			return nil, errors.Errorf("chart %q version %q not found", chart, version)
+		}
+		return nil, errors.Errorf("chart %q not found", chart)
+	}
+	if len(cv.URLs) == 0 {
+		return nil, errors.Errorf("chart %q version %q has no downloadable URLs", chart, cv.Version)
+	}
+	return cv, nil
+}
+
+// isCacheable reports whether a chart reference is immutable enough to be
+// cached: an exact version or an OCI digest. Version ranges are resolved first.
+func isCacheable(chart, version string) bool {
+	if strings.Contains(chart, "@sha256:") {
+		return true
+	}
+	return isExactVersion(version)
+}
+
+// isExactVersion reports whether version selects a single chart version. Helm
+// reads partial versions such as 1.2 or v1.2 as ranges (1.2.x), only complete
+// versions, with an optional v prefix as used by some charts, are exact.
+func isExactVersion(version string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	return err == nil
+}
+
+// loadChart fetches and loads the chart, the same way Filter does for charts
+// that are not local
+func (f *RenderHelmChartFunction) loadChart() (*helmchart.Chart, error) {
+	tmpdir, err := os.MkdirTemp("", "konvert-helm-")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temp directory for helm config and cache")
+	}
+	defer cleanupTmpDir(tmpdir, log.WithField("fn", f.Name()))
+
+	settings := newHelmSettings(tmpdir)
+	fetched, err := f.fetchChart(settings, tmpdir)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := loader.Load(fetched.archive)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load chart")
+	}
+	return chart, nil
+}
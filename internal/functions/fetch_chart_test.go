@@ -0,0 +1,213 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// testChartCache points the chart cache at an empty directory
+func testChartCache(t *testing.T) *cache.Cache {
+	t.Helper()
+	t.Setenv(cache.EnvDir, t.TempDir())
+	c, err := cache.Default()
+	require.NoError(t, err, "Default")
+	return c
+}
+
+func TestRenderHelmChartFilterUsesCache(t *testing.T) {
+	var tests = []struct {
+		name            string
+		version         string
+		expectedVersion string
+	}{
+		{
+			name:            "exact-version",
+			version:         "0.1.0",
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:            "version-range",
+			version:         "~0.1.0",
+			expectedVersion: "0.1.1",
+		},
+		{
+			name:            "latest",
+			expectedVersion: "0.1.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chartCache := testChartCache(t)
+			chartRepo := newTestChartRepo(t, false, "", "", "0.1.0", "0.1.1")
+
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = chartRepo.URL
+			fn.Chart = "local-chart"
+			fn.Version = test.version
+
+			_, err := fn.Filter([]*kyaml.RNode{})
+			require.NoError(t, err, "first render")
+
+			entries, err := chartCache.List()
+			require.NoError(t, err, "List")
+			require.Len(t, entries, 1, "cached charts")
+			assert.Equal(t, test.expectedVersion, entries[0].Version, "cached version")
+
+			// the index is fresh and the chart cached, so the repository
+			// is no longer needed
+			chartRepo.Close()
+			output, err := fn.Filter([]*kyaml.RNode{})
+			require.NoError(t, err, "cached render")
+			assert.NotEmpty(t, output, "cached render")
+		})
+	}
+}
+
+func TestRenderHelmChartFilterRefreshesIndex(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+
+	var fn RenderHelmChartFunction
+	fn.ReleaseName = "local-chart"
+	fn.Repo = chartRepo.URL
+	fn.Chart = "local-chart"
+	fn.Version = "0.1.0"
+	_, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "render 0.1.0")
+
+	// a version published after the index was cached is still found
+	chartRepo.addVersion(t, "0.2.0")
+	fn.Version = "0.2.0"
+	_, err = fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "render 0.2.0")
+}
+
+func TestRenderHelmChartFilterOffline(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0", "0.1.1")
+
+	// populate the cache, then go offline
+	var online RenderHelmChartFunction
+	online.ReleaseName = "local-chart"
+	online.Repo = chartRepo.URL
+	online.Chart = "local-chart"
+	online.Version = "0.1.1"
+	_, err := online.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "populate cache")
+	chartRepo.Close()
+	t.Setenv(EnvOffline, "true")
+
+	// vendor a version that was never cached
+	baseDir := t.TempDir()
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Metadata.Version = "0.3.0"
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, defaultVendorDir), 0755), "MkdirAll")
+	_, err = chartutil.Save(chart, filepath.Join(baseDir, defaultVendorDir))
+	require.NoError(t, err, "Save")
+
+	var tests = []struct {
+		name          string
+		repo          string
+		version       string
+		expectedError string
+	}{
+		{
+			name:    "cached",
+			repo:    chartRepo.URL,
+			version: "0.1.1",
+		},
+		{
+			name:    "range-resolved-with-cached-index",
+			repo:    chartRepo.URL,
+			version: "~0.1.0",
+		},
+		{
+			name:    "vendored",
+			repo:    chartRepo.URL,
+			version: "0.3.0",
+		},
+		{
+			name:          "missing",
+			repo:          chartRepo.URL,
+			version:       "0.1.0",
+			expectedError: `chart "local-chart" version "0.1.0" from ` + chartRepo.URL + " is not available offline",
+		},
+		{
+			name:          "missing-oci",
+			repo:          "oci://registry.example.com/charts",
+			version:       "0.1.1",
+			expectedError: "is not available offline",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = test.repo
+			fn.Chart = "local-chart"
+			fn.Version = test.version
+			fn.BaseDirectory = baseDir
+
+			output, err := fn.Filter([]*kyaml.RNode{})
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.NotEmpty(t, output, test.name)
+		})
+	}
+}
+
+func TestVendoredChartName(t *testing.T) {
+	var tests = []struct {
+		chart    string
+		version  string
+		expected string
+	}{
+		{chart: "mysql", version: "8.6.2", expected: "mysql-8.6.2.tgz"},
+		{chart: "oci://registry.example.com/charts/mysql", version: "8.6.2", expected: "mysql-8.6.2.tgz"},
+		{chart: "mysql@sha256:abcd", version: "8.6.2", expected: "mysql-8.6.2.tgz"},
+		{chart: "mysql", version: "~8.6", expected: ""},
+		{chart: "mysql", version: "8.6", expected: ""},
+		{chart: "mysql", version: "v8.6", expected: ""},
+		{chart: "https://charts.example.com/mysql-8.6.2.tgz", version: "8.6.2", expected: ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, vendoredChartName(test.chart, test.version), test.chart+" "+test.version)
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	var tests = []struct {
+		chart    string
+		version  string
+		expected bool
+	}{
+		{chart: "mysql", version: "8.6.2", expected: true},
+		{chart: "cert-manager", version: "v1.6.1", expected: true},
+		{chart: "mysql", version: "~8.6", expected: false},
+		{chart: "mysql", version: "8.6", expected: false},
+		{chart: "mysql", version: "v8.6", expected: false},
+		{chart: "mysql", version: "", expected: false},
+		{chart: "mysql@sha256:abcd", version: "", expected: true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isCacheable(test.chart, test.version), test.chart+" "+test.version)
+	}
+}
@@ -0,0 +1,100 @@
+package functions
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	fnFilterResourcesName = "filter-resources"
+	fnFilterResourcesKind = "FilterResources"
+)
+
+type FilterResourcesProcessor struct{}
+
+func (p *FilterResourcesProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&FilterResourcesFunction{}, resourceList)
+}
+
+// FilterResourcesFunction drops the resources that are not selected by any of
+// the Include selectors (when set) or that are selected by any of the Exclude
+// selectors
+type FilterResourcesFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+	Include            []ResourceSelector `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude            []ResourceSelector `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	// dropped reports the resources dropped by the last Filter
+	dropped framework.Results
+}
+
+func (f *FilterResourcesFunction) Name() string {
+	return fnFilterResourcesName
+}
+
+func (f *FilterResourcesFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *FilterResourcesFunction) Config(rn *kyaml.RNode) error {
+	return loadConfig(f, rn, fnFilterResourcesKind)
+}
+
+// Results reports the resources dropped by the last Filter
+func (f *FilterResourcesFunction) Results() framework.Results {
+	return f.dropped
+}
+
+func (f *FilterResourcesFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.dropped = nil
+	var kept []*kyaml.RNode
+	for _, item := range items {
+		reason, err := f.dropReason(item)
+		if err != nil {
+			return items, err
+		}
+		if reason == "" {
+			kept = append(kept, item)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"kind": item.GetKind(),
+			"name": item.GetName(),
+		}).Info(reason)
+		f.dropped = append(f.dropped, resourceResult(item, framework.Info, reason))
+	}
+	return kept, nil
+}
+
+// dropReason returns why item is dropped, or an empty string when it is kept
+func (f *FilterResourcesFunction) dropReason(item *kyaml.RNode) (string, error) {
+	if len(f.Include) > 0 {
+		included := false
+		for i, selector := range f.Include {
+			matched, err := selector.Matches(item)
+			if err != nil {
+				return "", errors.Wrapf(err, "include[%d]", i)
+			}
+			if matched {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "dropped, not selected by spec.include", nil
+		}
+	}
+	for i, selector := range f.Exclude {
+		matched, err := selector.Matches(item)
+		if err != nil {
+			return "", errors.Wrapf(err, "exclude[%d]", i)
+		}
+		if matched {
+			return fmt.Sprintf("dropped, selected by spec.exclude[%d]", i), nil
+		}
+	}
+	return "", nil
+}
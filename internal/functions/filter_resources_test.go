@@ -0,0 +1,158 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testFilterResourcesInput = `apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: grafana
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: grafana
+  labels:
+    app.kubernetes.io/name: grafana
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus-server
+  labels:
+    app.kubernetes.io/name: prometheus
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: prometheus-server-test
+  labels:
+    app.kubernetes.io/name: prometheus
+`
+
+func TestFilterResourcesFunctionConfig(t *testing.T) {
+	input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: FilterResources
+metadata:
+  name: fnconfig
+spec:
+  include:
+  - group: apps
+  exclude:
+  - kind: Deployment
+    labelSelector: app.kubernetes.io/name=grafana
+`)
+	require.NoError(t, err, "Parse")
+
+	var fn FilterResourcesFunction
+	require.NoError(t, fn.Config(input), "Config")
+	assert.Equal(t, []ResourceSelector{{Group: "apps"}}, fn.Include, "include")
+	assert.Equal(t, []ResourceSelector{{Kind: "Deployment", LabelSelector: "app.kubernetes.io/name=grafana"}}, fn.Exclude, "exclude")
+}
+
+func TestFilterResourcesFilter(t *testing.T) {
+	var tests = []struct {
+		name            string
+		include         []ResourceSelector
+		exclude         []ResourceSelector
+		expected        []string
+		expectedDropped []string
+		expectedError   string
+	}{
+		{
+			name:     "no-selectors",
+			expected: []string{"PodSecurityPolicy/grafana", "Deployment/grafana", "Deployment/prometheus-server", "Pod/prometheus-server-test"},
+		},
+		{
+			name:     "exclude",
+			exclude:  []ResourceSelector{{Kind: "PodSecurityPolicy"}, {LabelSelector: "app.kubernetes.io/name=grafana"}, {Name: "*-test"}},
+			expected: []string{"Deployment/prometheus-server"},
+			expectedDropped: []string{
+				"PodSecurityPolicy/grafana: dropped, selected by spec.exclude[0]",
+				"Deployment/grafana: dropped, selected by spec.exclude[1]",
+				"Pod/prometheus-server-test: dropped, selected by spec.exclude[2]",
+			},
+		},
+		{
+			name:     "include",
+			include:  []ResourceSelector{{Group: "apps", Version: "v1"}, {Kind: "Pod"}},
+			expected: []string{"Deployment/grafana", "Deployment/prometheus-server", "Pod/prometheus-server-test"},
+			expectedDropped: []string{
+				"PodSecurityPolicy/grafana: dropped, not selected by spec.include",
+			},
+		},
+		{
+			name:     "include-and-exclude",
+			include:  []ResourceSelector{{LabelSelector: "app.kubernetes.io/name=prometheus"}},
+			exclude:  []ResourceSelector{{Kind: "Pod"}},
+			expected: []string{"Deployment/prometheus-server"},
+			expectedDropped: []string{
+				"PodSecurityPolicy/grafana: dropped, not selected by spec.include",
+				"Deployment/grafana: dropped, not selected by spec.include",
+				"Pod/prometheus-server-test: dropped, selected by spec.exclude[0]",
+			},
+		},
+		{
+			name:          "invalid-selector",
+			exclude:       []ResourceSelector{{Name: "[grafana"}},
+			expectedError: `exclude[0]: invalid name pattern "[grafana"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := FilterResourcesFunction{Include: test.include, Exclude: test.exclude}
+			input, err := kio.ParseAll(testFilterResourcesInput)
+			require.NoError(t, err, "ParseAll")
+
+			output, err := fn.Filter(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+
+			var kept []string
+			for _, rn := range output {
+				kept = append(kept, rn.GetKind()+"/"+rn.GetName())
+			}
+			assert.Equal(t, test.expected, kept, test.name)
+
+			var dropped []string
+			for _, result := range fn.Results() {
+				assert.Equal(t, framework.Info, result.Severity, test.name)
+				dropped = append(dropped, result.ResourceRef.Kind+"/"+result.ResourceRef.Name+": "+result.Message)
+			}
+			assert.Equal(t, test.expectedDropped, dropped, test.name)
+		})
+	}
+}
+
+func TestKonvertFilterIncludeExclude(t *testing.T) {
+	fn := Konvert("./examples/konvert.yaml")
+	fn.ResourceMeta.Name = "web"
+	fn.Chart = "./local-chart"
+	fn.Exclude = []ResourceSelector{{Kind: "Pod", Name: "*-test-connection"}}
+
+	nodes, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	for _, node := range nodes {
+		assert.NotEqual(t, "Pod", node.GetKind(), "test pod is dropped")
+	}
+
+	var dropped []string
+	for _, result := range fn.Results() {
+		if result.ResourceRef != nil && result.Message == "dropped, selected by spec.exclude[0]" {
+			dropped = append(dropped, result.ResourceRef.Name)
+		}
+	}
+	assert.Equal(t, []string{"web-local-chart-test-connection"}, dropped, "dropped")
+}
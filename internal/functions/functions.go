@@ -54,13 +54,21 @@ func runFn(fn konvertFunction, resourceList *framework.ResourceList) error {
 	}
 
 	resourceList.Items, err = fn.Filter(resourceList.Items)
+	if reporter, ok := fn.(resultsReporter); ok {
+		resourceList.Results = append(resourceList.Results, reporter.Results()...)
+	}
 	if err != nil {
-		resourceList.Results = framework.Results{
-			&framework.Result{
-				Message:  fmt.Sprintf("error running %s: %v", fn.Name(), err.Error()),
-				Severity: framework.Error,
-			},
+		var withResults resultsError
+		if errors.As(err, &withResults) {
+			resourceList.Results = append(resourceList.Results, withResults.Results()...)
+			return resourceList.Results
 		}
+		resourceList.Results = append(resourceList.Results, &framework.Result{
+			Message:     fmt.Sprintf("error running %s: %v", fn.Name(), err.Error()),
+			Severity:    framework.Error,
+			ResourceRef: resourceRef(resourceList.FunctionConfig),
+			File:        resourceFile(resourceList.FunctionConfig),
+		})
 		return resourceList.Results
 	}
 
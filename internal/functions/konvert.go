@@ -1,6 +1,7 @@
 package functions
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/kumorilabs/konvert/internal/kube"
@@ -16,7 +17,10 @@ const (
 	fnKonvertKind = "Konvert"
 )
 
-type KonvertProcessor struct{}
+type KonvertProcessor struct {
+	// Options configure how the charts are accessed
+	Options Options
+}
 
 func IsKonvertFile(item *kyaml.RNode) bool {
 	return item.GetKind() == fnKonvertKind && item.GetApiVersion() == fnConfigAPIVersion
@@ -26,7 +30,7 @@ func (p *KonvertProcessor) Process(resourceList *framework.ResourceList) error {
 	fnconfigs := p.functionConfigs(resourceList)
 	for _, fnconfig := range fnconfigs {
 		resourceList.FunctionConfig = fnconfig
-		err := runFn(&KonvertFunction{}, resourceList)
+		err := runFn(&KonvertFunction{options: p.Options}, resourceList)
 		if err != nil {
 			return err
 		}
@@ -63,30 +67,121 @@ func Konvert(filePath string) *KonvertFunction {
 
 type KonvertFunction struct {
 	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
-	Repo               string                 `yaml:"repo,omitempty"`
-	Chart              string                 `yaml:"chart,omitempty"`
-	Version            string                 `yaml:"version,omitempty"`
-	Namespace          string                 `yaml:"namespace,omitempty"`
-	Path               string                 `yaml:"path,omitempty"`
-	Pattern            string                 `yaml:"pattern,omitempty"`
-	Kustomize          bool                   `yaml:"kustomize,omitempty"`
+	Repo               string `yaml:"repo,omitempty"`
+	Chart              string `yaml:"chart,omitempty"`
+	Version            string `yaml:"version,omitempty"`
+	VersionConstraint  string `json:"versionConstraint,omitempty" yaml:"versionConstraint,omitempty"`
+	Namespace          string `yaml:"namespace,omitempty"`
+	Path               string `yaml:"path,omitempty"`
+	Pattern            string `yaml:"pattern,omitempty"`
+	Kustomize          bool   `yaml:"kustomize,omitempty"`
+	// Checksum annotates the rendered resources with the checksum of their
+	// content, to tell when they were edited by hand
+	Checksum           bool                   `json:"checksum,omitempty" yaml:"checksum,omitempty"`
 	Values             map[string]interface{} `json:"values,omitempty"`
+	ValuesFiles        []string               `json:"valuesFiles,omitempty" yaml:"valuesFiles,omitempty"`
+	Set                map[string]interface{} `json:"set,omitempty" yaml:"set,omitempty"`
+	SetString          map[string]interface{} `json:"setString,omitempty" yaml:"setString,omitempty"`
+	SetFile            map[string]string      `json:"setFile,omitempty" yaml:"setFile,omitempty"`
+	Auth               *RepoAuth              `json:"auth,omitempty" yaml:"auth,omitempty"`
+	Registry           *RegistryAuth          `json:"registry,omitempty" yaml:"registry,omitempty"`
+	VendorDir          string                 `json:"vendorDir,omitempty" yaml:"vendorDir,omitempty"`
+	Verify             bool                   `json:"verify,omitempty" yaml:"verify,omitempty"`
+	Keyring            string                 `json:"keyring,omitempty" yaml:"keyring,omitempty"`
 	SkipHooks          bool                   `json:"skipHooks,omitempty" yaml:"skipHooks,omityempty"`
 	SkipTests          bool                   `json:"skipTests,omitempty" yaml:"skipTests,omityempty"`
 	SkipCRDs           bool                   `json:"skipCRDs,omitempty" yaml:"skipCRDs,omitempty"`
 	KubeVersion        string                 `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
 	APIVersions        []string               `json:"apiVersions,omitempty" yaml:"apiVersions,omitempty"`
+	Charts             []KonvertChart         `json:"charts,omitempty" yaml:"charts,omitempty"`
+	Patches            []Patch                `json:"patches,omitempty" yaml:"patches,omitempty"`
+	Include            []ResourceSelector     `json:"include,omitempty" yaml:"include,omitempty"`
+	CRDs               *CRDs                  `json:"crds,omitempty" yaml:"crds,omitempty"`
+	Hooks              *Hooks                 `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	ConfigMapGenerator bool                   `json:"configMapGenerator,omitempty" yaml:"configMapGenerator,omitempty"`
+	SOPS               *SOPS                  `json:"sops,omitempty" yaml:"sops,omitempty"`
+	ExternalSecrets    *ExternalSecrets       `json:"externalSecrets,omitempty" yaml:"externalSecrets,omitempty"`
+	Exclude            []ResourceSelector     `json:"exclude,omitempty" yaml:"exclude,omitempty"`
 	filePath           string
+	// releaseName and chartIndex are set on the functions rendering the
+	// charts of spec.charts, see ChartFunctions
+	releaseName string
+	chartIndex  int
+	// lockPath is the path of the lock file relative to the package
+	lockPath string
+	// options configure how the charts are accessed, see SetOptions
+	options Options
+	// valuesSource locates the inline values in the Konvert file
+	valuesSource *valuesSource
+	// results are the results of the last Filter
+	results framework.Results
+	// siblings are the resources rendered by the charts of spec.charts
+	// rendered before this one
+	siblings []*kyaml.RNode
+	// patchesMatched is the number of rendered resources each patch was
+	// applied to by the last Filter
+	patchesMatched []int
 }
 
 func (f *KonvertFunction) Name() string {
 	return fnKonvertName
 }
 
+// Results returns the rendered and removed resources and the warnings of the
+// last Filter
+func (f *KonvertFunction) Results() framework.Results {
+	return f.results
+}
+
+// FilePath returns the path of the Konvert file the function was loaded from
+func (f *KonvertFunction) FilePath() string {
+	return f.filePath
+}
+
+// VendorChart copies the chart archive into the vendor directory, see
+// RenderHelmChartFunction.VendorChart
+func (f *KonvertFunction) VendorChart() (string, bool, error) {
+	renderHelmChart := f.renderHelmChartFunction()
+	return renderHelmChart.VendorChart()
+}
+
+func (f *KonvertFunction) renderHelmChartFunction() RenderHelmChartFunction {
+	return RenderHelmChartFunction{
+		ReleaseName:   f.ReleaseName(),
+		Repo:          f.Repo,
+		Chart:         f.Chart,
+		Version:       f.Version,
+		KubeVersion:   f.KubeVersion,
+		APIVersions:   f.APIVersions,
+		Values:        f.Values,
+		ValuesFiles:   f.ValuesFiles,
+		Set:           f.Set,
+		SetString:     f.SetString,
+		SetFile:       f.SetFile,
+		Auth:          f.Auth,
+		Registry:      f.Registry,
+		VendorDir:     f.VendorDir,
+		Verify:        f.Verify,
+		Keyring:       f.Keyring,
+		Namespace:     f.Namespace,
+		SkipHooks:     f.SkipHooks,
+		SkipTests:     f.SkipTests,
+		SkipCRDs:      f.SkipCRDs,
+		BaseDirectory: filepath.Dir(f.filePath),
+		valuesSource:  f.valuesSource,
+		options:       f.options,
+	}
+}
+
 func (f *KonvertFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
 	f.ResourceMeta = meta
 }
 
+// SetOptions configures how the charts are accessed
+func (f *KonvertFunction) SetOptions(opts Options) {
+	f.options = opts
+}
+
 func (f *KonvertFunction) Config(rn *kyaml.RNode) error {
 	fnlog := log.WithField("fn", f.Name())
 	err := loadConfig(f, rn, fnKonvertKind)
@@ -97,14 +192,65 @@ func (f *KonvertFunction) Config(rn *kyaml.RNode) error {
 	fnconfigPath := rn.GetAnnotations()[kioutil.PathAnnotation]
 	baseDir := filepath.Dir(fnconfigPath)
 
-	if !isDefaultPath(f.Path) {
-		f.Path = filepath.Join(baseDir, f.Path)
+	if fnconfigPath != "" {
+		f.lockPath = lockFilePath(fnconfigPath)
+	} else {
+		f.lockPath = lockFilePath(filepath.Base(f.filePath))
+	}
+	f.valuesSource = newValuesSource(rn, f.filePath)
+
+	if err := f.validateCharts(); err != nil {
+		return err
+	}
+	f.Path = chartPath(baseDir, f.Path)
+	if f.CRDs != nil {
+		if f.SkipCRDs {
+			return fmt.Errorf("spec.crds cannot be set with spec.skipCRDs")
+		}
+		if f.CRDs.Path == "" {
+			f.CRDs.Path = defaultCRDsPath
+		}
+		f.CRDs.Path = chartPath(baseDir, f.CRDs.Path)
+	}
+	if f.Hooks != nil {
+		if f.SkipHooks {
+			return fmt.Errorf("spec.hooks cannot be set with spec.skipHooks")
+		}
+		if err := validateHooksMode(f.Hooks.Mode); err != nil {
+			return errors.Wrap(err, "spec.hooks")
+		}
+	}
+	if f.ConfigMapGenerator {
+		if !f.Kustomize {
+			return fmt.Errorf("spec.configMapGenerator requires spec.kustomize")
+		}
+		if f.filePath == "" {
+			// functions cannot write the files of the generators
+			return fmt.Errorf("spec.configMapGenerator is not supported when konvert runs as a function")
+		}
+	}
+	if f.SOPS != nil {
+		if err := validateAgeRecipients(f.SOPS.Age); err != nil {
+			return errors.Wrap(err, "spec.sops")
+		}
+	}
+	if f.ExternalSecrets != nil {
+		if f.SOPS != nil {
+			return fmt.Errorf("spec.externalSecrets cannot be set with spec.sops")
+		}
+		if err := f.ExternalSecrets.validate(); err != nil {
+			return errors.Wrap(err, "spec.externalSecrets")
+		}
+	}
+	for i := range f.Charts {
+		f.Charts[i].Path = chartPath(baseDir, f.Charts[i].Path)
 	}
 
 	fnlog.WithFields(log.Fields{
 		"fnconfig-path": fnconfigPath,
 		"filePath":      f.filePath,
 		"path":          f.Path,
+		"lock":          f.lockPath,
 	}).Debug("configuring function")
 
 	if f.KubeVersion == "" {
@@ -132,7 +278,13 @@ func (f *KonvertFunction) Filter(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 	//   add rendered chart nodes
 	log.Debug("running")
 
+	if len(f.Charts) > 0 {
+		return f.filterCharts(nodes)
+	}
+
 	annotationKonvertChartValue := konvertChartAnnotationValue(f.Repo, f.Chart)
+	f.results = nil
+	previous := chartResources(nodes, f.Repo, f.Chart)
 
 	removeByAnnotations := RemoveByAnnotationsFunction{
 		Annotations: map[string]string{
@@ -145,27 +297,42 @@ func (f *KonvertFunction) Filter(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 		return nodes, errors.Wrap(err, "unable to run remove-by-annotations function")
 	}
 
+	renderHelmChart := f.renderHelmChartFunction()
+	filterResources := FilterResourcesFunction{
+		Include: f.Include,
+		Exclude: f.Exclude,
+	}
+	patches := PatchesFunction{
+		Patches:       f.Patches,
+		BaseDirectory: filepath.Dir(f.filePath),
+	}
+	convertHooks := ConvertHooksFunction{
+		Mode:        f.hooksMode(),
+		Path:        f.Path,
+		ReleaseName: f.ReleaseName(),
+	}
+	convertSecrets := ConvertSecretsFunction{
+		ExternalSecrets: f.externalSecrets(),
+		ReleaseName:     f.ReleaseName(),
+		previous:        previous,
+	}
+	encryptSecrets := EncryptSecretsFunction{
+		Age:      f.sopsAge(),
+		Checksum: f.Checksum,
+		previous: previous,
+	}
 	runKonvert := func() ([]*kyaml.RNode, error) {
 		var items []*kyaml.RNode
-		renderHelmChart := RenderHelmChartFunction{
-			ReleaseName:   f.ResourceMeta.Name,
-			Repo:          f.Repo,
-			Chart:         f.Chart,
-			Version:       f.Version,
-			KubeVersion:   f.KubeVersion,
-			APIVersions:   f.APIVersions,
-			Values:        f.Values,
-			Namespace:     f.Namespace,
-			SkipHooks:     f.SkipHooks,
-			SkipTests:     f.SkipTests,
-			SkipCRDs:      f.SkipCRDs,
-			BaseDirectory: filepath.Dir(f.filePath),
-		}
 		items, err := renderHelmChart.Filter(items)
 		if err != nil {
 			return items, err
 		}
 
+		items, err = filterResources.Filter(items)
+		if err != nil {
+			return items, errors.Wrap(err, "unable to run filter-resources function")
+		}
+
 		// run pre-configured functions on rendered helm chart resources
 
 		removeBlankNamespace := RemoveBlankNamespaceFunction{}
@@ -184,6 +351,10 @@ func (f *KonvertFunction) Filter(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 			Repo:  f.Repo,
 			Chart: f.Chart,
 		}
+		if resolved := renderHelmChart.resolved; resolved != nil && resolved.signer != nil {
+			setKonvertAnnotations.SignedBy = resolved.signer.identity
+			setKonvertAnnotations.SignerFingerprint = resolved.signer.fingerprint
+		}
 		items, err = setKonvertAnnotations.Filter(items)
 		if err != nil {
 			return items, errors.Wrap(err, "unable to run konvert-annotations function")
@@ -207,15 +378,51 @@ func (f *KonvertFunction) Filter(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 			return items, errors.Wrap(err, "unable to run remove-blank-pod-affinity-term-namespaces function")
 		}
 
+		// patches apply to the cleaned up resources and are written to disk
+		items, err = patches.Filter(items)
+		if err != nil {
+			return items, errors.Wrap(err, "unable to run patches function")
+		}
+
+		// Secrets are replaced before the path is set, the files are named
+		// after the resources replacing them
+		items, err = convertSecrets.Filter(items)
+		if err != nil {
+			return items, errors.Wrap(err, "unable to run convert-secrets function")
+		}
+
 		setPathAnnotation := SetPathAnnotationFunction{
 			Path:    f.Path,
 			Pattern: f.Pattern,
+			CRDPath: f.crdPath(),
 		}
 		items, err = setPathAnnotation.Filter(items)
 		if err != nil {
 			return items, errors.Wrap(err, "unable to run path-annotation function")
 		}
 
+		// hooks are converted once their path is known, flux stages are
+		// written to their own directories
+		items, err = convertHooks.Filter(items)
+		if err != nil {
+			return items, errors.Wrap(err, "unable to run convert-hooks function")
+		}
+
+		// Secrets are encrypted once their content and path are final
+		items, err = encryptSecrets.Filter(items)
+		if err != nil {
+			return items, errors.Wrap(err, "unable to run encrypt-secrets function")
+		}
+
+		// must run last so the checksum covers every change made above
+		if f.Checksum {
+			setChecksumAnnotation := SetChecksumAnnotationFunction{}
+			items, err = setChecksumAnnotation.Filter(items)
+			if err != nil {
+				return items, errors.Wrap(err, "unable to run checksum-annotation function")
+			}
+		}
+
 		return items, nil
 	}
 
@@ -223,22 +430,122 @@ func (f *KonvertFunction) Filter(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 	if err != nil {
 		return nodes, err
 	}
+	// the files of the package and the resources of the other charts of
+	// spec.charts must not be overwritten. Resources rendered by another
+	// chart, e.g. before the chart was vendored, are not checked.
+	existing := append(unrenderedResources(nodes), f.siblings...)
+	if err := checkPathCollisions(existing, items, !isPathTemplate(f.Pattern)); err != nil {
+		return nodes, err
+	}
+	f.results = append(f.results, renderHelmChart.skipped...)
+	f.results = append(f.results, filterResources.dropped...)
+	f.results = append(f.results, convertHooks.Results()...)
+	f.results = append(f.results, convertSecrets.Results()...)
+	f.results = append(f.results, encryptSecrets.Results()...)
+	f.results = append(f.results, renderResults(previous, items)...)
+	f.results = append(f.results, deprecatedAPIResults(items)...)
+	f.patchesMatched = patches.matched
+	if f.ChartIndex() < 0 {
+		// the charts of spec.charts share the patches, see filterCharts
+		f.results = append(f.results, unmatchedPatchResults(f.patchesMatched)...)
+	}
+
+	changes, err := f.valuesChangesSinceLock(nodes, &renderHelmChart)
+	if err != nil {
+		// the report is informational, it must not prevent the upgrade
+		log.WithError(err).Warn("unable to compare values with the previous chart version")
+		f.results = append(f.results, &framework.Result{
+			Message:  fmt.Sprintf("chart %s: unable to compare values with the previous chart version: %s", f.Chart, err),
+			Severity: framework.Warning,
+			Field:    &framework.Field{Path: "spec.version"},
+		})
+	} else if changes != nil && !changes.Empty() {
+		f.results = append(f.results, f.valuesChangesResults(changes)...)
+	}
+
+	nodes, err = f.updateLock(nodes, renderHelmChart.resolved)
+	if err != nil {
+		return nodes, err
+	}
 
 	// append newly rendered chart nodes
 	nodes = append(nodes, items...)
 
+	return f.kustomize(nodes)
+}
+
+// kustomize adds the resources rendered from the chart to the kustomization
+// at the path of the chart, and the CustomResourceDefinitions to their own
+// kustomization
+func (f *KonvertFunction) kustomize(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
 	if f.Kustomize {
 		kustomizer := KustomizerFunction{
 			Path:                    f.Path,
 			Namespace:               f.Namespace,
 			ResourceAnnotationName:  annotationKonvertChart,
-			ResourceAnnotationValue: annotationKonvertChartValue,
+			ResourceAnnotationValue: konvertChartAnnotationValue(f.Repo, f.Chart),
+			ConfigMapGenerator:      f.ConfigMapGenerator,
 		}
+		kustomizer.selects = func(node *kyaml.RNode) bool {
+			return (f.CRDs == nil || isNotCRD(node)) && hookStageDirectory(node, f.Path) == ""
+		}
+		var err error
 		nodes, err = kustomizer.Filter(nodes)
 		if err != nil {
 			return nodes, errors.Wrap(err, "unable to run kustomizer function")
 		}
+		nodes, err = f.kustomizeHookStages(nodes)
+		if err != nil {
+			return nodes, err
+		}
+	}
+	if f.CRDs != nil && f.CRDs.Kustomize {
+		return f.kustomizeCRDs(nodes)
+	}
+	return nodes, nil
+}
+
+// updateLock records the resolved chart in the lock file, failing if the
+// locked digest of the same chart version changed. Local charts are not
+// locked.
+func (f *KonvertFunction) updateLock(nodes []*kyaml.RNode, resolved *resolvedChart) ([]*kyaml.RNode, error) {
+	index, locked, err := findKonvertLock(nodes, f.lockPath, f.ReleaseName())
+	if err != nil {
+		return nodes, err
+	}
+
+	if resolved == nil {
+		if index >= 0 {
+			nodes = append(nodes[:index], nodes[index+1:]...)
+		}
+		return nodes, nil
 	}
 
+	lock := KonvertLock{
+		Repo:    f.Repo,
+		Chart:   f.Chart,
+		Version: resolved.version,
+		URL:     resolved.url,
+		Digest:  resolved.digest,
+	}
+	if locked != nil {
+		if err := locked.verify(lock); err != nil {
+			return nodes, errors.Wrapf(err, "verifying %s", f.lockPath)
+		}
+		// vendored archives do not know where they were downloaded from
+		if lock.URL == "" && locked.Version == lock.Version {
+			lock.URL = locked.URL
+		}
+	}
+
+	node, err := lock.node(f.ReleaseName(), f.lockPath)
+	if err != nil {
+		return nodes, err
+	}
+	if index >= 0 {
+		nodes[index] = node
+	} else {
+		nodes = append(nodes, node)
+	}
 	return nodes, nil
 }
@@ -0,0 +1,200 @@
+package functions
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// KonvertChart is one of the charts rendered by a Konvert resource listing
+// several charts in spec.charts. The other settings of the Konvert resource,
+// e.g. namespace, pattern or kustomize, apply to every chart.
+type KonvertChart struct {
+	// ReleaseName defaults to the name of the chart
+	ReleaseName       string                 `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
+	Repo              string                 `json:"repo,omitempty" yaml:"repo,omitempty"`
+	Chart             string                 `json:"chart,omitempty" yaml:"chart,omitempty"`
+	Version           string                 `json:"version,omitempty" yaml:"version,omitempty"`
+	VersionConstraint string                 `json:"versionConstraint,omitempty" yaml:"versionConstraint,omitempty"`
+	Values            map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
+	ValuesFiles       []string               `json:"valuesFiles,omitempty" yaml:"valuesFiles,omitempty"`
+	Set               map[string]interface{} `json:"set,omitempty" yaml:"set,omitempty"`
+	SetString         map[string]interface{} `json:"setString,omitempty" yaml:"setString,omitempty"`
+	SetFile           map[string]string      `json:"setFile,omitempty" yaml:"setFile,omitempty"`
+	// Path defaults to the path of the Konvert resource
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// releaseName returns the release name of the chart, the last element of the
+// chart name by default
+func (c KonvertChart) releaseName() string {
+	if c.ReleaseName != "" {
+		return c.ReleaseName
+	}
+	return path.Base(strings.TrimSuffix(c.Chart, "/"))
+}
+
+// ChartFunctions returns a function per chart listed in spec.charts, or the
+// function itself when it renders a single chart
+func (f *KonvertFunction) ChartFunctions() []*KonvertFunction {
+	if len(f.Charts) == 0 {
+		return []*KonvertFunction{f}
+	}
+	var fns []*KonvertFunction
+	for i, chart := range f.Charts {
+		fn := *f
+		fn.Charts = nil
+		fn.Repo = chart.Repo
+		fn.Chart = chart.Chart
+		fn.Version = chart.Version
+		fn.VersionConstraint = chart.VersionConstraint
+		fn.Values = chart.Values
+		fn.ValuesFiles = chart.ValuesFiles
+		fn.Set = chart.Set
+		fn.SetString = chart.SetString
+		fn.SetFile = chart.SetFile
+		if chart.Path != "" {
+			fn.Path = chart.Path
+		}
+		// the charts are kustomized together, see filterCharts
+		fn.Kustomize = false
+		fn.releaseName = chart.releaseName()
+		fn.chartIndex = i
+		fn.valuesSource = f.valuesSource.forChart(i)
+		fn.results = nil
+		fn.siblings = nil
+		fns = append(fns, &fn)
+	}
+	return fns
+}
+
+// ChartIndex returns the index of the chart in spec.charts, or -1 when the
+// function renders the chart of the spec itself
+func (f *KonvertFunction) ChartIndex() int {
+	if f.releaseName == "" {
+		return -1
+	}
+	return f.chartIndex
+}
+
+// ReleaseName returns the name the chart is rendered with
+func (f *KonvertFunction) ReleaseName() string {
+	if f.releaseName != "" {
+		return f.releaseName
+	}
+	return f.ResourceMeta.Name
+}
+
+// validateCharts rejects charts that cannot be told apart, since their
+// rendered resources, kustomization entries and locks would overwrite each
+// other
+func (f *KonvertFunction) validateCharts() error {
+	if len(f.Charts) == 0 {
+		return nil
+	}
+	for _, field := range []struct {
+		name string
+		set  bool
+	}{
+		{"repo", f.Repo != ""},
+		{"chart", f.Chart != ""},
+		{"version", f.Version != ""},
+		{"versionConstraint", f.VersionConstraint != ""},
+		{"values", len(f.Values) > 0},
+		{"valuesFiles", len(f.ValuesFiles) > 0},
+		{"set", len(f.Set) > 0},
+		{"setString", len(f.SetString) > 0},
+		{"setFile", len(f.SetFile) > 0},
+	} {
+		if field.set {
+			return fmt.Errorf("spec.%s cannot be set with spec.charts, set it on each chart", field.name)
+		}
+	}
+
+	charts := make(map[string]int)
+	releases := make(map[string]int)
+	for i, chart := range f.Charts {
+		if chart.Chart == "" {
+			return fmt.Errorf("spec.charts[%d]: chart cannot be empty", i)
+		}
+		id := konvertChartAnnotationValue(chart.Repo, chart.Chart)
+		if j, ok := charts[id]; ok {
+			return fmt.Errorf("spec.charts[%d]: chart %q is already rendered by spec.charts[%d]", i, chart.Chart, j)
+		}
+		charts[id] = i
+		release := chart.releaseName()
+		if j, ok := releases[release]; ok {
+			return fmt.Errorf("spec.charts[%d]: release name %q is already used by spec.charts[%d]", i, release, j)
+		}
+		releases[release] = i
+	}
+	return nil
+}
+
+// filterCharts renders each chart of spec.charts and kustomizes them together
+func (f *KonvertFunction) filterCharts(nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.results = nil
+	f.patchesMatched = make([]int, len(f.Patches))
+	fns := f.ChartFunctions()
+	var rendered []*kyaml.RNode
+	for _, fn := range fns {
+		var err error
+		fn.siblings = rendered
+		nodes, err = fn.Filter(nodes)
+		f.results = append(f.results, fn.results...)
+		if err != nil {
+			return nodes, errors.Wrapf(err, "spec.charts[%d]", fn.chartIndex)
+		}
+		for i, matched := range fn.patchesMatched {
+			f.patchesMatched[i] += matched
+		}
+		rendered = append(rendered, chartResources(nodes, fn.Repo, fn.Chart)...)
+	}
+	// a patch only has to match the resources of one of the charts
+	f.results = append(f.results, unmatchedPatchResults(f.patchesMatched)...)
+
+	// entries are tagged with their chart, so every chart adds its own
+	// resources to the kustomizations
+	for _, fn := range fns {
+		fn.Kustomize = f.Kustomize
+		var err error
+		nodes, err = fn.kustomize(nodes)
+		if err != nil {
+			return nodes, err
+		}
+	}
+	return nodes, nil
+}
+
+// forChart locates the values of the chart at index in spec.charts
+func (s *valuesSource) forChart(index int) *valuesSource {
+	if s == nil {
+		return nil
+	}
+	source := *s
+	source.field = fmt.Sprintf("%s.charts[%d].values", s.section, index)
+	source.node = nil
+	if s.config == nil {
+		return &source
+	}
+	charts, err := s.config.Pipe(kyaml.Lookup(s.section, "charts"))
+	if err != nil || charts == nil || charts.YNode().Kind != kyaml.SequenceNode {
+		return &source
+	}
+	if elements := charts.Content(); index < len(elements) {
+		source.node, _ = kyaml.NewRNode(elements[index]).Pipe(kyaml.Lookup("values"))
+	}
+	return &source
+}
+
+// chartPath joins the path of a chart with the directory of the Konvert file
+func chartPath(baseDir, chartPath string) string {
+	if isDefaultPath(chartPath) {
+		return chartPath
+	}
+	return filepath.Join(baseDir, chartPath)
+}
@@ -0,0 +1,270 @@
+package functions
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestKonvertChartsConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		spec          string
+		expected      []KonvertChart
+		expectedError string
+	}{
+		{
+			name: "charts",
+			spec: `
+  path: upstream
+  charts:
+  - chart: ./charts/web
+    path: web
+  - repo: https://charts.example.com
+    chart: api
+    releaseName: backend
+`,
+			expected: []KonvertChart{
+				{Chart: "./charts/web", Path: "apps/web"},
+				{Repo: "https://charts.example.com", Chart: "api", ReleaseName: "backend"},
+			},
+		},
+		{
+			name: "chart-and-charts",
+			spec: `
+  chart: mysql
+  charts:
+  - chart: redis
+`,
+			expectedError: "spec.chart cannot be set with spec.charts, set it on each chart",
+		},
+		{
+			name: "values-and-charts",
+			spec: `
+  values:
+    replicaCount: 1
+  charts:
+  - chart: redis
+`,
+			expectedError: "spec.values cannot be set with spec.charts, set it on each chart",
+		},
+		{
+			name: "set-and-charts",
+			spec: `
+  set:
+    image.tag: "1.26"
+  charts:
+  - chart: redis
+`,
+			expectedError: "spec.set cannot be set with spec.charts, set it on each chart",
+		},
+		{
+			name: "set-file-and-charts",
+			spec: `
+  setFile:
+    tls.ca: ca.crt
+  charts:
+  - chart: redis
+`,
+			expectedError: "spec.setFile cannot be set with spec.charts, set it on each chart",
+		},
+		{
+			name: "empty-chart",
+			spec: `
+  charts:
+  - repo: https://charts.example.com
+`,
+			expectedError: "spec.charts[0]: chart cannot be empty",
+		},
+		{
+			name: "duplicate-chart",
+			spec: `
+  charts:
+  - chart: redis
+    releaseName: cache
+  - chart: redis
+    releaseName: sessions
+`,
+			expectedError: `spec.charts[1]: chart "redis" is already rendered by spec.charts[0]`,
+		},
+		{
+			name: "duplicate-release",
+			spec: `
+  charts:
+  - repo: https://charts.example.com
+    chart: redis
+  - repo: https://mirror.example.com
+    chart: redis
+`,
+			expectedError: `spec.charts[1]: release name "redis" is already used by spec.charts[0]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: stack
+  annotations:
+    internal.config.kubernetes.io/path: apps/konvert.yaml
+spec:
+  kubeVersion: "1.27"` + test.spec)
+			require.NoError(t, err, "Parse")
+
+			var fn KonvertFunction
+			err = fn.Config(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Equal(t, test.expectedError, err.Error(), test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expected, fn.Charts, test.name)
+		})
+	}
+}
+
+func TestKonvertChartFunctions(t *testing.T) {
+	fn := KonvertFunction{
+		Path:      "upstream",
+		Namespace: "apps",
+		Kustomize: true,
+		Charts: []KonvertChart{
+			{Chart: "./charts/web/", Path: "web", Values: map[string]interface{}{"replicaCount": 2}},
+			{
+				Repo:        "https://charts.example.com",
+				Chart:       "api",
+				Version:     "1.0.0",
+				ReleaseName: "backend",
+				Set:         map[string]interface{}{"image.tag": "2.0"},
+				SetString:   map[string]interface{}{"podLabels.tier": "backend"},
+				SetFile:     map[string]string{"tls.ca": "ca.crt"},
+			},
+		},
+	}
+	fn.ResourceMeta.Name = "stack"
+
+	fns := fn.ChartFunctions()
+	require.Len(t, fns, 2, "functions")
+	assert.Equal(t, "web", fns[0].ReleaseName(), "default release name")
+	assert.Equal(t, "web", fns[0].Path, "path")
+	assert.Equal(t, map[string]interface{}{"replicaCount": 2}, fns[0].Values, "values")
+	assert.Equal(t, 0, fns[0].ChartIndex(), "index")
+	assert.Equal(t, "backend", fns[1].ReleaseName(), "release name")
+	assert.Equal(t, "upstream", fns[1].Path, "default path")
+	assert.Equal(t, "1.0.0", fns[1].Version, "version")
+	assert.Empty(t, fns[0].Set, "set of another chart")
+	assert.Equal(t, map[string]interface{}{"image.tag": "2.0"}, fns[1].Set, "set")
+	assert.Equal(t, map[string]interface{}{"podLabels.tier": "backend"}, fns[1].SetString, "setString")
+	assert.Equal(t, map[string]string{"tls.ca": "ca.crt"}, fns[1].SetFile, "setFile")
+	assert.Equal(t, 1, fns[1].ChartIndex(), "index")
+	for _, chartFn := range fns {
+		assert.Equal(t, "apps", chartFn.Namespace, "namespace")
+		assert.Equal(t, "stack", chartFn.ResourceMeta.Name, "name")
+		assert.False(t, chartFn.Kustomize, "kustomized by the parent")
+		assert.Empty(t, chartFn.Charts, "charts")
+	}
+
+	single := KonvertFunction{Chart: "mysql"}
+	single.ResourceMeta.Name = "db"
+	assert.Equal(t, []*KonvertFunction{&single}, single.ChartFunctions(), "single chart")
+	assert.Equal(t, -1, single.ChartIndex(), "single chart index")
+	assert.Equal(t, "db", single.ReleaseName(), "single chart release name")
+}
+
+func TestKonvertFilterCharts(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+
+	newFn := func(charts string) *KonvertFunction {
+		input, err := kyaml.Parse(fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: stack
+spec:
+  kubeVersion: "1.27"
+  namespace: apps
+  kustomize: true
+  charts:
+%s`, charts))
+		require.NoError(t, err, "Parse")
+		fn := Konvert("./examples/konvert.yaml")
+		require.NoError(t, fn.Config(input), "Config")
+		return fn
+	}
+	both := fmt.Sprintf(`  - chart: ./local-chart
+    releaseName: web
+    path: web
+  - repo: %s
+    chart: local-chart
+    version: 0.1.0
+    releaseName: api
+    path: api
+    values:
+      replicaCount: 3
+`, chartRepo.URL)
+
+	nodes, err := newFn(both).Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	paths := make(map[string]*kyaml.RNode)
+	for _, node := range nodes {
+		paths[node.GetAnnotations()[kioutil.PathAnnotation]] = node
+	}
+	require.Contains(t, paths, "web/deployment-web-local-chart.yaml", "web deployment")
+	require.Contains(t, paths, "api/deployment-api-local-chart.yaml", "api deployment")
+	replicas, err := paths["api/deployment-api-local-chart.yaml"].Pipe(kyaml.Lookup("spec", "replicas"))
+	require.NoError(t, err, "Lookup")
+	assert.Equal(t, "3", replicas.YNode().Value, "chart values")
+
+	resources := func(path string) []string {
+		t.Helper()
+		require.Contains(t, paths, path, "kustomization")
+		elements, err := paths[path].Pipe(kyaml.Lookup("resources"))
+		require.NoError(t, err, "Lookup")
+		var values []string
+		for _, element := range elements.Content() {
+			values = append(values, element.Value)
+		}
+		return values
+	}
+	assert.Equal(t, []string{"web", "api"}, resources("kustomization.yaml"), "base kustomization")
+	assert.Contains(t, resources("web/kustomization.yaml"), "deployment-web-local-chart.yaml", "web kustomization")
+	assert.Contains(t, resources("api/kustomization.yaml"), "deployment-api-local-chart.yaml", "api kustomization")
+
+	index, lock, err := findKonvertLock(nodes, "konvert.lock.yaml", "api")
+	require.NoError(t, err, "findKonvertLock")
+	require.GreaterOrEqual(t, index, 0, "api lock")
+	assert.Equal(t, "0.1.0", lock.Version, "locked version")
+	index, _, err = findKonvertLock(nodes, "konvert.lock.yaml", "web")
+	require.NoError(t, err, "findKonvertLock")
+	assert.Equal(t, -1, index, "local charts are not locked")
+
+	// rendering again only replaces the resources of each chart
+	rerendered, err := newFn(both).Filter(nodes)
+	require.NoError(t, err, "Filter")
+	assert.Len(t, rerendered, len(nodes), "rerendered")
+
+	// removing a chart from the list keeps the resources of the others
+	webOnly := `  - chart: ./local-chart
+    releaseName: web
+    path: web
+    values:
+      replicaCount: 5
+`
+	nodes, err = newFn(webOnly).Filter(rerendered)
+	require.NoError(t, err, "Filter")
+	paths = make(map[string]*kyaml.RNode)
+	for _, node := range nodes {
+		paths[node.GetAnnotations()[kioutil.PathAnnotation]] = node
+	}
+	assert.Contains(t, paths, "api/deployment-api-local-chart.yaml", "api deployment is not removed")
+	replicas, err = paths["web/deployment-web-local-chart.yaml"].Pipe(kyaml.Lookup("spec", "replicas"))
+	require.NoError(t, err, "Lookup")
+	assert.Equal(t, "5", replicas.YNode().Value, "web re-rendered")
+}
@@ -0,0 +1,126 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	fnKonvertLockKind = "KonvertLock"
+
+	// lockFileName is a YAML file name, orchestrators only pass YAML files to
+	// functions
+	lockFileName = "konvert.lock.yaml"
+)
+
+// KonvertLock records the exact chart archive a Konvert file was rendered
+// from
+type KonvertLock struct {
+	Repo    string `yaml:"repo,omitempty"`
+	Chart   string `yaml:"chart"`
+	Version string `yaml:"version"`
+	URL     string `yaml:"url,omitempty"`
+	Digest  string `yaml:"digest"`
+}
+
+// IsKonvertLock returns true if item is a KonvertLock resource
+func IsKonvertLock(item *kyaml.RNode) bool {
+	return item.GetKind() == fnKonvertLockKind && item.GetApiVersion() == fnConfigAPIVersion
+}
+
+// lockFilePath returns the path of the lock file for the Konvert file at
+// konvertPath: konvert.lock.yaml for konvert.yaml, <name>.konvert.lock.yaml
+// otherwise
+func lockFilePath(konvertPath string) string {
+	name := strings.TrimSuffix(filepath.Base(konvertPath), filepath.Ext(konvertPath))
+	if name != fnKonvertName && name != "" && name != "." {
+		name = name + "." + lockFileName
+	} else {
+		name = lockFileName
+	}
+	return filepath.Join(filepath.Dir(konvertPath), name)
+}
+
+// findKonvertLock returns the index and content of the lock named name at
+// path in nodes, or -1 when there is none. A lock file holds a lock per chart
+// rendered from the Konvert file.
+func findKonvertLock(nodes []*kyaml.RNode, path, name string) (int, *KonvertLock, error) {
+	for i, node := range nodes {
+		if !IsKonvertLock(node) || node.GetName() != name {
+			continue
+		}
+		if filepath.Clean(node.GetAnnotations()[kioutil.PathAnnotation]) != filepath.Clean(path) {
+			continue
+		}
+
+		var lock KonvertLock
+		spec := node.Field("spec")
+		if spec == nil {
+			return i, &lock, nil
+		}
+		if err := spec.Value.YNode().Decode(&lock); err != nil {
+			return i, nil, errors.Wrapf(err, "unable to parse lock file %s", path)
+		}
+		return i, &lock, nil
+	}
+	return -1, nil, nil
+}
+
+// verify fails if the lock records a different digest for the same chart
+// version than the one that was just rendered
+func (l *KonvertLock) verify(resolved KonvertLock) error {
+	if l.Repo != resolved.Repo || l.Chart != resolved.Chart || l.Version != resolved.Version {
+		return nil
+	}
+	if l.Digest == "" || l.Digest == resolved.Digest {
+		return nil
+	}
+	return errors.Errorf(
+		"chart %q version %q has digest %s but %s was locked: the chart was republished or tampered with, remove the lock file to accept the new archive",
+		resolved.Chart,
+		resolved.Version,
+		resolved.Digest,
+		l.Digest,
+	)
+}
+
+func (l KonvertLock) node(name, path string) (*kyaml.RNode, error) {
+	template := `
+apiVersion: %s
+kind: %s
+metadata:
+  name: %s
+  annotations:
+    config.kubernetes.io/local-config: 'true'
+    %s: %s
+`
+	node, err := kyaml.Parse(fmt.Sprintf(
+		template,
+		fnConfigAPIVersion,
+		fnKonvertLockKind,
+		name,
+		kioutil.PathAnnotation,
+		path,
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to build lock")
+	}
+
+	data, err := kyaml.Marshal(l)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal lock")
+	}
+	spec, err := kyaml.Parse(string(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse lock")
+	}
+	if err := node.PipeE(kyaml.SetField("spec", spec)); err != nil {
+		return nil, errors.Wrap(err, "unable to set lock spec")
+	}
+	return node, nil
+}
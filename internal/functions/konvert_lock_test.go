@@ -0,0 +1,197 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// republish replaces the archive of an existing version with different
+// content, as a compromised or careless repository would
+func (r *testChartRepo) republish(t *testing.T, version string) {
+	t.Helper()
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Metadata.Version = version
+	chart.Metadata.Description = "republished"
+
+	archive, err := chartutil.Save(chart, r.dir)
+	require.NoError(t, err, "Save")
+	digest, err := provenance.DigestFile(archive)
+	require.NoError(t, err, "DigestFile")
+
+	indexPath := filepath.Join(r.dir, "index.yaml")
+	index, err := repo.LoadIndexFile(indexPath)
+	require.NoError(t, err, "LoadIndexFile")
+	cv, err := index.Get(chart.Name(), version)
+	require.NoError(t, err, "Get")
+	cv.Digest = digest
+	require.NoError(t, index.WriteFile(indexPath, 0644), "WriteFile")
+}
+
+func TestLockFilePath(t *testing.T) {
+	var tests = []struct {
+		konvertPath string
+		expected    string
+	}{
+		{konvertPath: "konvert.yaml", expected: "konvert.lock.yaml"},
+		{konvertPath: "konvert.yml", expected: "konvert.lock.yaml"},
+		{konvertPath: "apps/mysql/konvert.yaml", expected: "apps/mysql/konvert.lock.yaml"},
+		{konvertPath: "apps/mysql.yaml", expected: "apps/mysql.konvert.lock.yaml"},
+		{konvertPath: "", expected: "konvert.lock.yaml"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.konvertPath, func(t *testing.T) {
+			assert.Equal(t, test.expected, lockFilePath(test.konvertPath))
+		})
+	}
+}
+
+func TestKonvertFilterLock(t *testing.T) {
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0", "0.1.1")
+
+	newFn := func(version string) *KonvertFunction {
+		var fn KonvertFunction
+		fn.ResourceMeta.Name = "local-chart"
+		fn.Repo = chartRepo.URL
+		fn.Chart = "local-chart"
+		fn.Version = version
+		fn.filePath = filepath.Join(t.TempDir(), "konvert.yaml")
+		fn.lockPath = "konvert.lock.yaml"
+		return &fn
+	}
+	lockOf := func(nodes []*kyaml.RNode) *KonvertLock {
+		index, lock, err := findKonvertLock(nodes, "konvert.lock.yaml", "local-chart")
+		require.NoError(t, err, "findKonvertLock")
+		require.GreaterOrEqual(t, index, 0, "lock")
+		return lock
+	}
+
+	testChartCache(t)
+	nodes, err := newFn("~0.1.0").Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	lock := lockOf(nodes)
+	assert.Equal(t, "0.1.1", lock.Version, "resolved version")
+	assert.Equal(t, chartRepo.URL+"/local-chart-0.1.1.tgz", lock.URL, "url")
+	digest, err := provenance.DigestFile(filepath.Join(chartRepo.dir, "local-chart-0.1.1.tgz"))
+	require.NoError(t, err, "DigestFile")
+	assert.Equal(t, "sha256:"+digest, lock.Digest, "digest")
+
+	for _, node := range nodes {
+		if IsKonvertLock(node) {
+			assert.Equal(t, "true", node.GetAnnotations()["config.kubernetes.io/local-config"], "local-config")
+			assert.Equal(t, "konvert.lock.yaml", node.GetAnnotations()[kioutil.PathAnnotation], "path")
+		}
+	}
+
+	// rendering again updates the lock in place
+	nodes, err = newFn("~0.1.0").Filter(nodes)
+	require.NoError(t, err, "Filter")
+	var locks int
+	for _, node := range nodes {
+		if IsKonvertLock(node) {
+			locks++
+		}
+	}
+	assert.Equal(t, 1, locks, "single lock")
+
+	// changing the version replaces the lock
+	downgraded, err := newFn("0.1.0").Filter(nodes)
+	require.NoError(t, err, "Filter")
+	assert.Equal(t, "0.1.0", lockOf(downgraded).Version, "downgraded")
+
+	// the same version with a different digest is rejected, the cache of
+	// another machine is empty
+	chartRepo.republish(t, "0.1.1")
+	testChartCache(t)
+	_, err = newFn("~0.1.0").Filter(nodes)
+	require.NotNil(t, err, "republished")
+	assert.Contains(t, err.Error(), "republished or tampered with", "republished")
+
+	// local charts are not locked
+	fn := newFn("")
+	fn.Repo = ""
+	fn.Chart = "./local-chart"
+	fn.filePath = "./examples/konvert.yaml"
+	nodes, err = fn.Filter(nodes)
+	require.NoError(t, err, "Filter")
+	index, _, err := findKonvertLock(nodes, "konvert.lock.yaml", "local-chart")
+	require.NoError(t, err, "findKonvertLock")
+	assert.Equal(t, -1, index, "lock removed")
+}
+
+func TestKonvertProcessLock(t *testing.T) {
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.1")
+	fnconfig, err := kyaml.Parse(fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: local-chart
+  annotations:
+    internal.config.kubernetes.io/path: apps/konvert.yaml
+spec:
+  repo: %s
+  chart: local-chart
+  version: 0.1.1
+`, chartRepo.URL))
+	require.NoError(t, err, "Parse")
+	process := func(locked KonvertLock) (*framework.ResourceList, error) {
+		testChartCache(t)
+		node, err := locked.node("local-chart", "apps/konvert.lock.yaml")
+		require.NoError(t, err, "node")
+		// as the orchestrator reads it from the package
+		items, err := kio.ParseAll(node.MustString())
+		require.NoError(t, err, "ParseAll")
+		resourceList := &framework.ResourceList{Items: items, FunctionConfig: fnconfig}
+		return resourceList, (&KonvertProcessor{}).Process(resourceList)
+	}
+
+	digest, err := provenance.DigestFile(filepath.Join(chartRepo.dir, "local-chart-0.1.1.tgz"))
+	require.NoError(t, err, "DigestFile")
+	lock := KonvertLock{
+		Repo:    chartRepo.URL,
+		Chart:   "local-chart",
+		Version: "0.1.1",
+		URL:     chartRepo.URL + "/local-chart-0.1.1.tgz",
+		Digest:  "sha256:" + digest,
+	}
+	resourceList, err := process(lock)
+	require.NoError(t, err, "Process")
+	index, output, err := findKonvertLock(resourceList.Items, "apps/konvert.lock.yaml", "local-chart")
+	require.NoError(t, err, "findKonvertLock")
+	require.GreaterOrEqual(t, index, 0, "lock")
+	assert.Equal(t, lock, *output, "lock")
+
+	lock.Digest = "sha256:0000"
+	_, err = process(lock)
+	require.NotNil(t, err, "tampered")
+	assert.Contains(t, err.Error(), "republished or tampered with", "tampered")
+}
+
+func TestKonvertLockNode(t *testing.T) {
+	lock := KonvertLock{
+		Repo:    "https://charts.example.com",
+		Chart:   "chart",
+		Version: "0.1.0",
+		URL:     "https://charts.example.com/chart-0.1.0.tgz",
+		Digest:  "sha256:0000",
+	}
+	node, err := lock.node("chart", "apps/konvert.lock.yaml")
+	require.NoError(t, err, "node")
+	assert.True(t, IsKonvertLock(node), "IsKonvertLock")
+
+	_, parsed, err := findKonvertLock([]*kyaml.RNode{node}, "apps/konvert.lock.yaml", "chart")
+	require.NoError(t, err, "findKonvertLock")
+	assert.Equal(t, lock, *parsed, "round-trip")
+}
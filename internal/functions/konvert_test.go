@@ -347,17 +347,18 @@ spec:
 
 func TestKonvertFunctionConfig(t *testing.T) {
 	var tests = []struct {
-		name              string
-		input             string
-		expectedRepo      string
-		expectedChart     string
-		expectedVersion   string
-		expectedNamespace string
-		expectedPath      string
-		expectedPattern   string
-		expectedKustomize bool
-		expectedValues    map[string]interface{}
-		expectedError     string
+		name                string
+		input               string
+		expectedRepo        string
+		expectedChart       string
+		expectedVersion     string
+		expectedNamespace   string
+		expectedPath        string
+		expectedPattern     string
+		expectedKustomize   bool
+		expectedValues      map[string]interface{}
+		expectedValuesFiles []string
+		expectedError       string
 	}{
 		{
 			name: "configmap",
@@ -418,19 +419,23 @@ spec:
   path: "upstream"
   pattern: "%s_%s.yaml"
   kustomize: true
+  valuesFiles:
+  - values/common.yaml
+  - values/prod.yaml
   values:
     architecture: standalone
     image:
       pullPolicy: Always
       debug: true
 `,
-			expectedRepo:      "https://charts.bitnami.com/bitnami",
-			expectedChart:     "mysql",
-			expectedVersion:   "9.10.1",
-			expectedNamespace: "mysql",
-			expectedPath:      "upstream",
-			expectedPattern:   "%s_%s.yaml",
-			expectedKustomize: true,
+			expectedValuesFiles: []string{"values/common.yaml", "values/prod.yaml"},
+			expectedRepo:        "https://charts.bitnami.com/bitnami",
+			expectedChart:       "mysql",
+			expectedVersion:     "9.10.1",
+			expectedNamespace:   "mysql",
+			expectedPath:        "upstream",
+			expectedPattern:     "%s_%s.yaml",
+			expectedKustomize:   true,
 			expectedValues: map[string]interface{}{
 				"image": map[string]interface{}{
 					"pullPolicy": "Always",
@@ -538,6 +543,7 @@ spec: |
 			assert.Equal(t, test.expectedPattern, fn.Pattern, test.name)
 			assert.Equal(t, test.expectedKustomize, fn.Kustomize, test.name)
 			assert.Equal(t, test.expectedValues, fn.Values, test.name)
+			assert.Equal(t, test.expectedValuesFiles, fn.ValuesFiles, test.name)
 		})
 	}
 }
@@ -642,8 +648,16 @@ func TestKonvertFilter(t *testing.T) {
 				}
 			)
 
+			// lock files are covered by TestKonvertFilterLock
+			var resources []*kyaml.RNode
+			for _, node := range output {
+				if !IsKonvertLock(node) {
+					resources = append(resources, node)
+				}
+			}
+
 			err = kio.Pipeline{
-				Inputs: []kio.Reader{&kio.PackageBuffer{Nodes: output}},
+				Inputs: []kio.Reader{&kio.PackageBuffer{Nodes: resources}},
 				Outputs: []kio.Writer{
 					kio.ByteWriter{
 						Writer:           &outputbuf,
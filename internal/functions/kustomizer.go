@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework"
@@ -49,6 +50,15 @@ type KustomizerFunction struct {
 	Namespace               string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
 	ResourceAnnotationName  string `json:"resource_annotation_name,omitempty" yaml:"resource_annotation_name,omitempty"`
 	ResourceAnnotationValue string `json:"resource_annotation_value,omitempty" yaml:"resource_annotation_value,omitempty"`
+	// ConfigMapGenerator replaces the ConfigMaps with configMapGenerator
+	// entries, each key written to its own file
+	ConfigMapGenerator bool `json:"configMapGenerator,omitempty" yaml:"configMapGenerator,omitempty"`
+	// selects restricts the resources of the kustomization further, e.g. to
+	// keep CustomResourceDefinitions in their own kustomization
+	selects func(*kyaml.RNode) bool
+	// standalone kustomizations are not added to the kustomization at the
+	// root of the package
+	standalone bool
 }
 
 func (f *KustomizerFunction) Name() string {
@@ -190,27 +200,44 @@ func (f *KustomizerFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error
 	// f.ResourceAnnotationName=f.ResourceAnnotationValue
 	// and Path
 	// (see SetKonvertAnnotationsFunction, SetPathAnnotationFunction)
-	var kustresources []string
+	var (
+		kustresources []string
+		generated     []*kyaml.RNode
+		kept          []*kyaml.RNode
+	)
 	for _, node := range items {
 		// make sure we never add the kustnode to the resource list
-		if node == kustnode {
+		if node == kustnode || IsFile(node) {
+			kept = append(kept, node)
 			continue
 		}
 		path := node.GetAnnotations()[kioutil.PathAnnotation]
 		resannotationvalue := node.GetAnnotations()[f.ResourceAnnotationName]
-		if path != "" && f.ResourceAnnotationValue == resannotationvalue {
-			// The kustomization.yaml we are writing here lives in the same
-			// directory as the generated resources. So, we don't want to use
-			// the relative path on disk when we write the `resources` section
-			// in the kustomization.yaml. We will just use the file names.
-			kustresources = append(kustresources, filepath.Base(path))
+		if path == "" || f.ResourceAnnotationValue != resannotationvalue || (f.selects != nil && !f.selects(node)) {
+			kept = append(kept, node)
+			continue
+		}
+		if f.ConfigMapGenerator && isConfigMap(node) {
+			entry, files, err := f.generateConfigMap(node)
+			if err != nil {
+				return items, err
+			}
+			generated = append(generated, entry)
+			kept = append(kept, files...)
+			continue
 		}
+		kept = append(kept, node)
+		kustresources = append(kustresources, f.resourcePath(path))
 	}
+	items = kept
 
 	// set kustomization resourrces
 	if err := f.kustomizeResources(kustnode, kustresources); err != nil {
 		return items, err
 	}
+	if err := f.kustomizeConfigMapGenerator(kustnode, generated); err != nil {
+		return items, err
+	}
 
 	// if we are kustomizing resources in a subdirectory (upstream, for
 	// example), write a kustomization file in the parent with the subdirectory
@@ -218,7 +245,7 @@ func (f *KustomizerFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error
 	// Example:
 	// resources:
 	// - upstream
-	if !isDefaultPath(f.Path) {
+	if !isDefaultPath(f.Path) && !f.standalone {
 		baseKustNode, created, err := f.kustomizationAtPath(".", items)
 		if err != nil {
 			return items, err
@@ -234,6 +261,23 @@ func (f *KustomizerFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error
 	return items, nil
 }
 
+// resourcePath returns the path of the resource at path relative to the
+// kustomization.yaml, which lives in the directory of the generated
+// resources. Resources outside of it are listed by file name.
+func (f *KustomizerFunction) resourcePath(path string) string {
+	return relativePath(f.Path, path)
+}
+
+// relativePath returns path relative to dir, or its file name when it is
+// outside of dir
+func relativePath(dir, path string) string {
+	rel, err := filepath.Rel(normalizePath(dir), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
 func (f KustomizerFunction) buildKustomizationNode(kpath string) *kyaml.RNode {
 	template := `
 apiVersion: kustomize.config.k8s.io/v1beta1
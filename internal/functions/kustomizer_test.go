@@ -801,3 +801,24 @@ resources:
 		})
 	}
 }
+
+func TestKustomizerResourcePath(t *testing.T) {
+	var tests = []struct {
+		name     string
+		path     string
+		resource string
+		expected string
+	}{
+		{name: "root", path: ".", resource: "service-mysql.yaml", expected: "service-mysql.yaml"},
+		{name: "in-path", path: "upstream", resource: "upstream/service-mysql.yaml", expected: "service-mysql.yaml"},
+		{name: "subdirectory", path: "upstream", resource: "upstream/templates/service.yaml", expected: "templates/service.yaml"},
+		{name: "outside-path", path: "upstream/base", resource: "service-mysql.yaml", expected: "service-mysql.yaml"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := KustomizerFunction{Path: test.path}
+			assert.Equal(t, test.expected, fn.resourcePath(test.resource), test.name)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/api/filters/patchjson6902"
+	"sigs.k8s.io/kustomize/api/filters/patchstrategicmerge"
+	"sigs.k8s.io/kustomize/kyaml/comments"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/order"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	fnPatchesName = "patches"
+	fnPatchesKind = "Patches"
+)
+
+type PatchesProcessor struct{}
+
+func (p *PatchesProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&PatchesFunction{}, resourceList)
+}
+
+// Patch is a strategic-merge patch or a JSON6902 patch (a list of
+// operations), set inline or read from a file
+type Patch struct {
+	Patch string `json:"patch,omitempty" yaml:"patch,omitempty"`
+	// Path is the file of the patch, relative to the base directory
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Target selects the patched resources. It is required by JSON6902
+	// patches, strategic-merge patches default to the resource they name.
+	Target *ResourceSelector `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+type PatchesFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+	Patches            []Patch `json:"patches,omitempty" yaml:"patches,omitempty"`
+	BaseDirectory      string  `json:"baseDirectory,omitempty" yaml:"baseDirectory,omitempty"`
+	// matched is the number of resources each patch was applied to by the
+	// last Filter
+	matched []int
+}
+
+func (f *PatchesFunction) Name() string {
+	return fnPatchesName
+}
+
+func (f *PatchesFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *PatchesFunction) Config(rn *kyaml.RNode) error {
+	return loadConfig(f, rn, fnPatchesKind)
+}
+
+func (f *PatchesFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	f.matched = make([]int, len(f.Patches))
+	for i, patch := range f.Patches {
+		var err error
+		items, f.matched[i], err = f.apply(patch, items)
+		if err != nil {
+			return items, errors.Wrapf(err, "patches[%d]", i)
+		}
+	}
+	return items, nil
+}
+
+// Results warns about the patches that did not match any resource
+func (f *PatchesFunction) Results() framework.Results {
+	return unmatchedPatchResults(f.matched)
+}
+
+func unmatchedPatchResults(matched []int) framework.Results {
+	var results framework.Results
+	for i, count := range matched {
+		if count == 0 {
+			log.WithField("patch", i).Warn("patch did not match any resource")
+			results = append(results, &framework.Result{
+				Message:  "patch did not match any resource",
+				Severity: framework.Warning,
+				Field:    &framework.Field{Path: fmt.Sprintf("spec.patches[%d]", i)},
+			})
+		}
+	}
+	return results
+}
+
+// apply applies the patch to the selected items and returns the number of
+// items it was applied to
+func (f *PatchesFunction) apply(patch Patch, items []*kyaml.RNode) ([]*kyaml.RNode, int, error) {
+	content, err := f.content(patch)
+	if err != nil {
+		return items, 0, err
+	}
+	node, err := kyaml.Parse(content)
+	if err != nil {
+		return items, 0, errors.Wrap(err, "unable to parse patch")
+	}
+
+	var (
+		target = patch.Target
+		filter func(*kyaml.RNode) (*kyaml.RNode, error)
+	)
+	switch node.YNode().Kind {
+	case kyaml.SequenceNode:
+		if target == nil {
+			return items, 0, fmt.Errorf("a JSON6902 patch requires a target")
+		}
+		jsonPatch := patchjson6902.Filter{Patch: content}
+		// fail on an invalid patch even if it matches nothing
+		if _, err := jsonPatch.Filter(nil); err != nil {
+			return items, 0, errors.Wrap(err, "invalid JSON6902 patch")
+		}
+		filter = func(item *kyaml.RNode) (*kyaml.RNode, error) {
+			original := item.Copy()
+			patched, err := jsonPatch.Filter([]*kyaml.RNode{item})
+			if err != nil {
+				return nil, err
+			}
+			// the patch is applied to JSON, restore the field order and the
+			// comments, e.g. the source of the resource
+			if err := order.SyncOrder(original, patched[0]); err != nil {
+				return nil, err
+			}
+			if err := comments.CopyComments(original, patched[0]); err != nil {
+				return nil, err
+			}
+			return patched[0], nil
+		}
+	case kyaml.MappingNode:
+		if target == nil {
+			group, version := splitAPIVersion(node.GetApiVersion())
+			target = &ResourceSelector{
+				Group:     group,
+				Version:   version,
+				Kind:      node.GetKind(),
+				Name:      node.GetName(),
+				Namespace: node.GetNamespace(),
+			}
+		}
+		filter = func(item *kyaml.RNode) (*kyaml.RNode, error) {
+			smPatch := node.Copy()
+			// the patch applies to every target, whatever it names
+			if err := identify(smPatch, item); err != nil {
+				return nil, err
+			}
+			patched, err := patchstrategicmerge.Filter{Patch: smPatch}.Filter([]*kyaml.RNode{item})
+			if err != nil || len(patched) == 0 {
+				return nil, err
+			}
+			return patched[0], nil
+		}
+	default:
+		return items, 0, fmt.Errorf("a patch must be a resource or a list of JSON6902 operations")
+	}
+
+	var (
+		patched []*kyaml.RNode
+		matched int
+	)
+	for _, item := range items {
+		selected, err := target.Matches(item)
+		if err != nil {
+			return items, 0, err
+		}
+		if !selected {
+			patched = append(patched, item)
+			continue
+		}
+		matched++
+		result, err := filter(item)
+		if err != nil {
+			return items, 0, errors.Wrapf(err, "unable to patch %s %s", item.GetKind(), item.GetName())
+		}
+		// nil when a strategic-merge patch deletes the resource
+		if result != nil {
+			patched = append(patched, result)
+		}
+	}
+	return patched, matched, nil
+}
+
+func (f *PatchesFunction) content(patch Patch) (string, error) {
+	switch {
+	case patch.Patch != "" && patch.Path != "":
+		return "", fmt.Errorf("only one of patch or path can be set")
+	case patch.Patch != "":
+		return patch.Patch, nil
+	case patch.Path != "":
+		data, err := os.ReadFile(resolvePath(patch.Path, f.BaseDirectory))
+		if err != nil {
+			return "", errors.Wrap(err, "unable to read patch")
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("patch or path must be set")
+	}
+}
+
+// identify sets the type and name of patch to the ones of item
+func identify(patch, item *kyaml.RNode) error {
+	patch.SetApiVersion(item.GetApiVersion())
+	patch.SetKind(item.GetKind())
+	if err := patch.SetName(item.GetName()); err != nil {
+		return err
+	}
+	return patch.SetNamespace(item.GetNamespace())
+}
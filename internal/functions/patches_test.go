@@ -0,0 +1,418 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testPatchesInput = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0.0
+        readinessProbe:
+          initialDelaySeconds: 5
+      - name: sidecar
+        image: sidecar:1.0.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  labels:
+    app: worker
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+`
+
+func TestPatchesFunctionConfig(t *testing.T) {
+	input, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Patches
+metadata:
+  name: fnconfig
+spec:
+  baseDirectory: apps
+  patches:
+  - path: patches/probe.yaml
+  - patch: |-
+      - op: replace
+        path: /spec/replicas
+        value: 2
+    target:
+      kind: Deployment
+      name: web-*
+      labelSelector: app=web
+`)
+	require.NoError(t, err, "Parse")
+
+	var fn PatchesFunction
+	require.NoError(t, fn.Config(input), "Config")
+	assert.Equal(t, "apps", fn.BaseDirectory, "base directory")
+	assert.Equal(t, []Patch{
+		{Path: "patches/probe.yaml"},
+		{
+			Patch:  "- op: replace\n  path: /spec/replicas\n  value: 2",
+			Target: &ResourceSelector{Kind: "Deployment", Name: "web-*", LabelSelector: "app=web"},
+		},
+	}, fn.Patches, "patches")
+}
+
+func TestPatchesFilter(t *testing.T) {
+	var tests = []struct {
+		name            string
+		patches         []Patch
+		output          string
+		expectedMatched []int
+		expectedError   string
+	}{
+		{
+			name: "strategic-merge",
+			patches: []Patch{{Patch: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        readinessProbe:
+          initialDelaySeconds: 30
+      tolerations:
+      - key: dedicated
+        operator: Exists
+`}},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0.0
+        readinessProbe:
+          initialDelaySeconds: 30
+      - name: sidecar
+        image: sidecar:1.0.0
+      tolerations:
+      - key: dedicated
+        operator: Exists
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  labels:
+    app: worker
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+`,
+			expectedMatched: []int{1},
+		},
+		{
+			name: "strategic-merge-target",
+			patches: []Patch{{
+				Patch: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: any
+spec:
+  replicas: 3
+`,
+				Target: &ResourceSelector{Group: "apps", Kind: "Deployment"},
+			}},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0.0
+        readinessProbe:
+          initialDelaySeconds: 5
+      - name: sidecar
+        image: sidecar:1.0.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  labels:
+    app: worker
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+`,
+			expectedMatched: []int{2},
+		},
+		{
+			name: "strategic-merge-delete",
+			patches: []Patch{{Patch: `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+$patch: delete
+`}},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0.0
+        readinessProbe:
+          initialDelaySeconds: 5
+      - name: sidecar
+        image: sidecar:1.0.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  labels:
+    app: worker
+spec:
+  replicas: 1
+`,
+			expectedMatched: []int{1},
+		},
+		{
+			name: "json6902",
+			patches: []Patch{{
+				Patch: `- op: add
+  path: /spec/template/spec/containers/0/args
+  value: ["--verbose"]
+- op: remove
+  path: /spec/template/spec/containers/1
+`,
+				Target: &ResourceSelector{Kind: "Deployment", LabelSelector: "app=web"},
+			}},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0.0
+        readinessProbe:
+          initialDelaySeconds: 5
+        args:
+        - --verbose
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  labels:
+    app: worker
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+`,
+			expectedMatched: []int{1},
+		},
+		{
+			name: "unmatched",
+			patches: []Patch{{
+				Patch:  `[{"op": "replace", "path": "/spec/replicas", "value": 2}]`,
+				Target: &ResourceSelector{Kind: "StatefulSet"},
+			}},
+			output:          testPatchesInput,
+			expectedMatched: []int{0},
+		},
+		{
+			name:          "json6902-without-target",
+			patches:       []Patch{{Patch: `[{"op": "replace", "path": "/spec/replicas", "value": 2}]`}},
+			expectedError: "patches[0]: a JSON6902 patch requires a target",
+		},
+		{
+			name: "invalid-json6902",
+			patches: []Patch{{
+				Patch:  `["replace"]`,
+				Target: &ResourceSelector{Kind: "StatefulSet"},
+			}},
+			expectedError: "patches[0]: invalid JSON6902 patch",
+		},
+		{
+			name: "failed-json6902",
+			patches: []Patch{{
+				Patch:  `[{"op": "remove", "path": "/spec/missing"}]`,
+				Target: &ResourceSelector{Name: "worker"},
+			}},
+			expectedError: "patches[0]: unable to patch Deployment worker",
+		},
+		{
+			name:          "scalar",
+			patches:       []Patch{{Patch: "replicas"}},
+			expectedError: "patches[0]: a patch must be a resource or a list of JSON6902 operations",
+		},
+		{
+			name:          "empty",
+			patches:       []Patch{{}},
+			expectedError: "patches[0]: patch or path must be set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := PatchesFunction{Patches: test.patches}
+			input, err := kio.ParseAll(testPatchesInput)
+			require.NoError(t, err, "ParseAll")
+
+			output, err := fn.Filter(input)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			outputstr, err := kio.StringAll(output)
+			require.NoError(t, err, "StringAll")
+			assert.Equal(t, test.output, outputstr, test.name)
+			assert.Equal(t, test.expectedMatched, fn.matched, test.name)
+		})
+	}
+}
+
+func TestPatchesFilterPath(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "patches"), 0755), "MkdirAll")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "patches", "replicas.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  replicas: 4
+`), 0644), "WriteFile")
+
+	fn := PatchesFunction{
+		Patches:       []Patch{{Path: "patches/replicas.yaml"}},
+		BaseDirectory: dir,
+	}
+	input, err := kio.ParseAll(testPatchesInput)
+	require.NoError(t, err, "ParseAll")
+	output, err := fn.Filter(input)
+	require.NoError(t, err, "Filter")
+	replicas, err := output[1].Pipe(kyaml.Lookup("spec", "replicas"))
+	require.NoError(t, err, "Lookup")
+	assert.Equal(t, "4", replicas.YNode().Value, "replicas")
+
+	fn.Patches = []Patch{{Path: "patches/missing.yaml"}}
+	_, err = fn.Filter(input)
+	require.NotNil(t, err, "missing file")
+	assert.Contains(t, err.Error(), "unable to read patch", "missing file")
+}
+
+func TestKonvertFilterPatches(t *testing.T) {
+	fn := Konvert("./examples/konvert.yaml")
+	fn.ResourceMeta.Name = "web"
+	fn.Chart = "./local-chart"
+	fn.Path = "upstream"
+	fn.Pattern = "{{.Source | base}}"
+	fn.Patches = []Patch{
+		{
+			Patch: `- op: add
+  path: /spec/template/spec/tolerations
+  value:
+  - key: dedicated
+    operator: Exists
+`,
+			Target: &ResourceSelector{Kind: "Deployment"},
+		},
+		{
+			Patch:  `[{"op": "add", "path": "/metadata/labels/tier", "value": "web"}]`,
+			Target: &ResourceSelector{Kind: "CronJob"},
+		},
+	}
+
+	nodes, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	var deployment *kyaml.RNode
+	for _, node := range nodes {
+		if node.GetKind() == "Deployment" {
+			deployment = node
+		}
+	}
+	require.NotNil(t, deployment, "deployment")
+	// the path annotation is set after the patches, the source of the
+	// resource survives them
+	assert.Equal(t, "upstream/deployment.yaml", deployment.GetAnnotations()[kioutil.PathAnnotation], "path")
+	tolerations, err := deployment.Pipe(kyaml.Lookup("spec", "template", "spec", "tolerations"))
+	require.NoError(t, err, "Lookup")
+	require.NotNil(t, tolerations, "tolerations")
+	assert.Len(t, tolerations.Content(), 1, "tolerations")
+
+	var warnings []string
+	for _, result := range fn.Results() {
+		if result.Severity == framework.Warning && result.Field != nil {
+			warnings = append(warnings, result.Field.Path+": "+result.Message)
+		}
+	}
+	assert.Equal(t, []string{"spec.patches[1]: patch did not match any resource"}, warnings, "unmatched patches")
+}
@@ -0,0 +1,156 @@
+package functions
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// RegistryAuth configures access to an OCI registry (repos and charts using
+// the oci:// scheme). Credentials are read from environment variables, local
+// files or a docker config.json. File paths are relative to the Konvert file.
+type RegistryAuth struct {
+	Username              string `json:"username,omitempty" yaml:"username,omitempty"`
+	UsernameEnv           string `json:"usernameEnv,omitempty" yaml:"usernameEnv,omitempty"`
+	PasswordEnv           string `json:"passwordEnv,omitempty" yaml:"passwordEnv,omitempty"`
+	PasswordFile          string `json:"passwordFile,omitempty" yaml:"passwordFile,omitempty"`
+	ConfigFile            string `json:"configFile,omitempty" yaml:"configFile,omitempty"`
+	CAFile                string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	CertFile              string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile               string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty" yaml:"insecureSkipTLSVerify,omitempty"`
+	PlainHTTP             bool   `json:"plainHTTP,omitempty" yaml:"plainHTTP,omitempty"`
+}
+
+// registryCredentials are the resolved RegistryAuth settings
+type registryCredentials struct {
+	username              string
+	password              string
+	configFile            string
+	caFile                string
+	certFile              string
+	keyFile               string
+	insecureSkipTLSVerify bool
+	plainHTTP             bool
+}
+
+// credentials resolves environment variables and file paths. A nil
+// RegistryAuth resolves to empty credentials.
+func (a *RegistryAuth) credentials(baseDir string) (registryCredentials, error) {
+	var creds registryCredentials
+	if a == nil {
+		return creds, nil
+	}
+
+	username, password, err := resolveBasicAuth(a.Username, a.UsernameEnv, a.PasswordEnv, a.PasswordFile, baseDir)
+	if err != nil {
+		return creds, err
+	}
+	creds.username = username
+	creds.password = password
+
+	for _, file := range []struct {
+		path string
+		dest *string
+	}{
+		{a.ConfigFile, &creds.configFile},
+		{a.CAFile, &creds.caFile},
+		{a.CertFile, &creds.certFile},
+		{a.KeyFile, &creds.keyFile},
+	} {
+		if file.path != "" {
+			*file.dest = resolvePath(file.path, baseDir)
+		}
+	}
+
+	creds.insecureSkipTLSVerify = a.InsecureSkipTLSVerify
+	creds.plainHTTP = a.PlainHTTP
+	return creds, nil
+}
+
+// client returns a registry client for the credentials, reading the
+// credentials stored in registryConfig unless a config file is set. Debug
+// output goes to stderr so it never ends up in the function's output.
+func (c registryCredentials) client(registryConfig string) (*registry.Client, error) {
+	opts := []registry.ClientOption{
+		registry.ClientOptEnableCache(true),
+		registry.ClientOptWriter(os.Stderr),
+	}
+	if c.username != "" || c.password != "" {
+		opts = append(opts, registry.ClientOptBasicAuth(c.username, c.password))
+	}
+	if c.configFile != "" {
+		if _, err := os.Stat(c.configFile); err != nil {
+			return nil, errors.Wrap(err, "unable to read registry config file")
+		}
+		opts = append(opts, registry.ClientOptCredentialsFile(c.configFile))
+	} else {
+		opts = append(opts, registry.ClientOptCredentialsFile(registryConfig))
+	}
+	if c.plainHTTP {
+		opts = append(opts, registry.ClientOptPlainHTTP())
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, registry.ClientOptHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+				Proxy:           http.ProxyFromEnvironment,
+			},
+		}))
+	}
+
+	return registry.NewClient(opts...)
+}
+
+// tlsConfig returns nil when the default TLS settings should be used
+func (c registryCredentials) tlsConfig() (*tls.Config, error) {
+	if c.caFile == "" && c.certFile == "" && c.keyFile == "" && !c.insecureSkipTLSVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: c.insecureSkipTLSVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.certFile != "" || c.keyFile != "" {
+		if c.certFile == "" || c.keyFile == "" {
+			return nil, fmt.Errorf("both certFile and keyFile must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.caFile != "" {
+		ca, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", c.caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// getterOptions returns the options that make the OCI getter use client
+func (c registryCredentials) getterOptions(client *registry.Client) []getter.Option {
+	return []getter.Option{
+		getter.WithRegistryClient(client),
+		getter.WithPlainHTTP(c.plainHTTP),
+	}
+}
@@ -0,0 +1,329 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// testOCIRegistry is a minimal, pull-only OCI registry serving the
+// local-chart example as charts/local-chart
+type testOCIRegistry struct {
+	*httptest.Server
+	username  string
+	password  string
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	tags      []string
+}
+
+func newTestOCIRegistry(t *testing.T, secure bool, username, password string, versions ...string) *testOCIRegistry {
+	t.Helper()
+	r := &testOCIRegistry{
+		username:  username,
+		password:  password,
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+
+	handler := http.HandlerFunc(r.serve)
+	if secure {
+		r.Server = httptest.NewTLSServer(handler)
+	} else {
+		r.Server = httptest.NewServer(handler)
+	}
+	t.Cleanup(r.Close)
+
+	for _, version := range versions {
+		r.addVersion(t, version)
+	}
+	return r
+}
+
+// host is the registry host without the scheme
+func (r *testOCIRegistry) host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(r.URL, "https://"), "http://")
+}
+
+func (r *testOCIRegistry) addVersion(t *testing.T, version string) string {
+	t.Helper()
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Metadata.Version = version
+
+	archive, err := chartutil.Save(chart, t.TempDir())
+	require.NoError(t, err, "Save")
+	content, err := os.ReadFile(archive)
+	require.NoError(t, err, "ReadFile")
+	config, err := json.Marshal(chart.Metadata)
+	require.NoError(t, err, "Marshal")
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        r.addBlob(registry.ConfigMediaType, config),
+		"layers":        []interface{}{r.addBlob(registry.ChartLayerMediaType, content)},
+	})
+	require.NoError(t, err, "Marshal")
+
+	digest := testDigest(manifest)
+	r.manifests[digest] = manifest
+	r.manifests[version] = manifest
+	r.tags = append(r.tags, version)
+	return digest
+}
+
+func (r *testOCIRegistry) addBlob(mediaType string, content []byte) map[string]interface{} {
+	digest := testDigest(content)
+	r.blobs[digest] = content
+	return map[string]interface{}{
+		"mediaType": mediaType,
+		"digest":    digest,
+		"size":      len(content),
+	}
+}
+
+func (r *testOCIRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		u, p, ok := req.BasicAuth()
+		if !ok || u != r.username || p != r.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	const prefix = "/v2/charts/local-chart/"
+	var (
+		content   []byte
+		mediaType = "application/octet-stream"
+	)
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+		return
+	case req.URL.Path == prefix+"tags/list":
+		content, _ = json.Marshal(map[string]interface{}{"name": "charts/local-chart", "tags": r.tags})
+		mediaType = "application/json"
+	case strings.HasPrefix(req.URL.Path, prefix+"manifests/"):
+		content = r.manifests[strings.TrimPrefix(req.URL.Path, prefix+"manifests/")]
+		mediaType = "application/vnd.oci.image.manifest.v1+json"
+	case strings.HasPrefix(req.URL.Path, prefix+"blobs/"):
+		content = r.blobs[strings.TrimPrefix(req.URL.Path, prefix+"blobs/")]
+	}
+	if content == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+	w.Header().Set("Docker-Content-Digest", testDigest(content))
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(content)
+	}
+}
+
+func testDigest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func TestRenderHelmChartFilterWithRegistry(t *testing.T) {
+	plain := newTestOCIRegistry(t, false, "konvert", "s3cr3t", "0.1.0")
+	digest := plain.addVersion(t, "0.2.0")
+	secure := newTestOCIRegistry(t, true, "", "", "0.1.0")
+
+	baseDir := t.TempDir()
+	(&testChartRepo{Server: secure.Server}).writeCA(t, baseDir)
+	dockerConfig, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			plain.host(): map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte("konvert:s3cr3t")),
+			},
+		},
+	})
+	require.NoError(t, err, "Marshal")
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "config.json"), dockerConfig, 0600), "WriteFile")
+	t.Setenv("KONVERT_TEST_PASSWORD", "s3cr3t")
+
+	var tests = []struct {
+		name            string
+		repo            string
+		chart           string
+		version         string
+		registry        *RegistryAuth
+		expectedVersion string
+		expectedError   string
+	}{
+		{
+			name:    "repo-and-basic-auth",
+			repo:    "oci://" + plain.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				Username:    "konvert",
+				PasswordEnv: "KONVERT_TEST_PASSWORD",
+				PlainHTTP:   true,
+			},
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:    "chart-url-and-docker-config",
+			chart:   "oci://" + plain.host() + "/charts/local-chart",
+			version: "0.2.0",
+			registry: &RegistryAuth{
+				ConfigFile: "config.json",
+				PlainHTTP:  true,
+			},
+			expectedVersion: "0.2.0",
+		},
+		{
+			name:  "digest",
+			repo:  "oci://" + plain.host() + "/charts/",
+			chart: "local-chart@" + digest,
+			registry: &RegistryAuth{
+				Username:    "konvert",
+				PasswordEnv: "KONVERT_TEST_PASSWORD",
+				PlainHTTP:   true,
+			},
+			expectedVersion: "0.2.0",
+		},
+		{
+			name:    "ca-file",
+			repo:    "oci://" + secure.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				CAFile: "ca.crt",
+			},
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:    "insecure",
+			repo:    "oci://" + secure.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				InsecureSkipTLSVerify: true,
+			},
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:    "untrusted-certificate",
+			repo:    "oci://" + secure.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				Username: "konvert",
+			},
+			expectedError: "certificate",
+		},
+		{
+			name:    "no-credentials",
+			repo:    "oci://" + plain.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				PlainHTTP: true,
+			},
+			expectedError: "basic credential not found",
+		},
+		{
+			name:    "missing-config-file",
+			repo:    "oci://" + plain.host() + "/charts",
+			chart:   "local-chart",
+			version: "0.1.0",
+			registry: &RegistryAuth{
+				ConfigFile: "missing.json",
+			},
+			expectedError: "unable to read registry config file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testChartCache(t)
+
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = test.repo
+			fn.Chart = test.chart
+			fn.Version = test.version
+			fn.Registry = test.registry
+			fn.BaseDirectory = baseDir
+
+			output, err := fn.Filter([]*kyaml.RNode{})
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			require.NotEmpty(t, output, test.name)
+
+			var found bool
+			for _, node := range output {
+				if node.GetLabels()["helm.sh/chart"] != "" {
+					found = true
+					assert.Equal(t, "local-chart-"+test.expectedVersion, node.GetLabels()["helm.sh/chart"], test.name)
+				}
+			}
+			assert.True(t, found, "helm.sh/chart label")
+		})
+	}
+}
+
+func TestRegistryCredentialsTLSConfig(t *testing.T) {
+	var tests = []struct {
+		name          string
+		creds         registryCredentials
+		expectNil     bool
+		expectedError string
+	}{
+		{
+			name:      "default",
+			expectNil: true,
+		},
+		{
+			name:  "insecure",
+			creds: registryCredentials{insecureSkipTLSVerify: true},
+		},
+		{
+			name:          "missing-key",
+			creds:         registryCredentials{certFile: "client.crt"},
+			expectedError: "both certFile and keyFile must be set",
+		},
+		{
+			name:          "invalid-ca",
+			creds:         registryCredentials{caFile: "registry_auth_test.go"},
+			expectedError: "no certificates found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.creds.tlsConfig()
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expectNil, config == nil, test.name)
+		})
+	}
+}
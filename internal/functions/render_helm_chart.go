@@ -4,22 +4,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/mitchellh/copystructure"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli"
-	"helm.sh/helm/v3/pkg/downloader"
-	"helm.sh/helm/v3/pkg/getter"
-	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/provenance"
 	helmrelease "helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/releaseutil"
-	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/strvals"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework"
 	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -40,6 +43,15 @@ type RenderHelmChartFunction struct {
 	Chart              string                 `json:"chart,omitempty" yaml:"chart,omitempty"`
 	Version            string                 `json:"version,omitempty" yaml:"version,omitempty"`
 	Values             map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
+	ValuesFiles        []string               `json:"valuesFiles,omitempty" yaml:"valuesFiles,omitempty"`
+	Set                map[string]interface{} `json:"set,omitempty" yaml:"set,omitempty"`
+	SetString          map[string]interface{} `json:"setString,omitempty" yaml:"setString,omitempty"`
+	SetFile            map[string]string      `json:"setFile,omitempty" yaml:"setFile,omitempty"`
+	Auth               *RepoAuth              `json:"auth,omitempty" yaml:"auth,omitempty"`
+	Registry           *RegistryAuth          `json:"registry,omitempty" yaml:"registry,omitempty"`
+	VendorDir          string                 `json:"vendorDir,omitempty" yaml:"vendorDir,omitempty"`
+	Verify             bool                   `json:"verify,omitempty" yaml:"verify,omitempty"`
+	Keyring            string                 `json:"keyring,omitempty" yaml:"keyring,omitempty"`
 	Namespace          string                 `json:"namespace,omitempty" yaml:"namespace,omitempty"`
 	SkipHooks          bool                   `json:"skipHooks,omitempty" yaml:"skipHooks,omityempty"`
 	SkipTests          bool                   `json:"skipTests,omitempty" yaml:"skipTests,omityempty"`
@@ -47,30 +59,75 @@ type RenderHelmChartFunction struct {
 	KubeVersion        string                 `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
 	APIVersions        []string               `json:"apiVersions,omitempty" yaml:"apiVersions,omitempty"`
 	BaseDirectory      string
+	// options are set by the Konvert function, see KonvertFunction.SetOptions
+	options Options
+	// resolved is the chart archive used by the last Filter, nil for
+	// local charts
+	resolved *resolvedChart
+	// valuesSource locates the inline values in the function config
+	valuesSource *valuesSource
+	// results are the results of the last Filter, skipped are the empty
+	// manifests among them
+	results framework.Results
+	skipped framework.Results
+}
+
+// resolvedChart identifies the exact chart archive a chart was rendered from
+type resolvedChart struct {
+	version string
+	url     string
+	digest  string
+	// signer is set when the chart provenance was verified
+	signer *chartSigner
+	chart  *helmchart.Chart
 }
 
 func (f *RenderHelmChartFunction) Name() string {
 	return fnRenderHelmChartName
 }
 
+// Results returns the rendered and removed resources and the warnings of the
+// last Filter
+func (f *RenderHelmChartFunction) Results() framework.Results {
+	return f.results
+}
+
 func (f *RenderHelmChartFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
 	f.ResourceMeta = meta
 }
 
 func (f *RenderHelmChartFunction) Config(rn *kyaml.RNode) error {
-	return loadConfig(f, rn, fnRenderHelmChartKind)
+	if err := loadConfig(f, rn, fnRenderHelmChartKind); err != nil {
+		return err
+	}
+	f.valuesSource = newValuesSource(rn, "")
+	return nil
 }
 
-// parseManifests parses a map of Helm manifests into RNodes, skipping empty and comment-only manifests
-func parseManifests(manifests map[string]string) ([]*kyaml.RNode, error) {
+// parseManifests parses a map of Helm manifests into RNodes, skipping empty and
+// comment-only manifests with a warning
+func parseManifests(manifests map[string]string) ([]*kyaml.RNode, framework.Results, error) {
 	fnlog := log.WithField("fn", fnRenderHelmChartName)
-	var renderedNodes []*kyaml.RNode
+	var (
+		renderedNodes []*kyaml.RNode
+		skipped       framework.Results
+	)
 
-	for path, manifest := range manifests {
+	skip := func(path, message string) {
+		fnlog.WithFields(log.Fields{"path": path}).Debug(message)
+		skipped = append(skipped, &framework.Result{
+			Message:  message,
+			Severity: framework.Warning,
+			File:     &framework.File{Path: path},
+		})
+	}
+
+	for _, path := range sortedKeys(manifests) {
+		manifest := manifests[path]
 		// Skip empty manifests and manifests containing only comments
 		trimmed := strings.TrimSpace(manifest)
 		if len(trimmed) == 0 {
-			fnlog.WithFields(log.Fields{"path": path}).Debug("skipping empty manifest")
+			skip(path, "empty manifest skipped")
 			continue
 		}
 
@@ -84,24 +141,25 @@ func parseManifests(manifests map[string]string) ([]*kyaml.RNode, error) {
 			}
 		}
 		if !hasContent {
-			fnlog.WithFields(log.Fields{"path": path}).Debug("skipping comment-only manifest")
+			skip(path, "comment-only manifest skipped")
 			continue
 		}
 
 		node, err := kyaml.Parse(manifest)
 		if err != nil {
 			fnlog.WithFields(log.Fields{"path": path, "manifest": manifest}).Debug("failed to parse manifest")
-			return renderedNodes, errors.Wrap(err, "unable to parse manifest")
+			return renderedNodes, skipped, errors.Wrapf(err, "unable to parse manifest %s", path)
 		}
 		renderedNodes = append(renderedNodes, node)
 	}
 
-	return renderedNodes, nil
+	return renderedNodes, skipped, nil
 }
 
 func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
 	fnlog := log.WithField("fn", f.Name())
 	fnlog.Debug("rendering")
+	f.results, f.skipped = nil, nil
 
 	if f.Chart == "" {
 		return items, fmt.Errorf("chart cannot be empty")
@@ -113,86 +171,44 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 	}
 	defer cleanupTmpDir(tmpdir, fnlog)
 
-	configPath := filepath.Join(tmpdir, ".config")
 	cachePath := filepath.Join(tmpdir, ".cache")
-	dataPath := filepath.Join(tmpdir, ".data")
-
-	settings := cli.New()
-	settings.RegistryConfig = filepath.Join(configPath, "registry.json")
-	settings.RepositoryConfig = filepath.Join(configPath, "repositories.yaml")
-	settings.RepositoryCache = filepath.Join(cachePath, "repository")
+	settings := newHelmSettings(tmpdir)
 
-	for k, v := range map[string]string{
-		"HELM_CONFIG_HOME": configPath,
-		"HELM_CACHE_HOME":  cachePath,
-		"HELM_DATA_HOME":   dataPath,
-	} {
-		if err := os.Setenv(k, v); err != nil {
-			return items, errors.Wrapf(err, "setting environment variable %q", k)
-		}
-	}
-
-	var (
-		chartURL string
-		archive  string
-	)
+	var archive string
 
 	if f.Repo == "" {
 		log.WithField("base-directory", f.BaseDirectory).Debug("looking for local chart directory")
 		if chartDir := resolveLocalChartDirectory(f.Chart, f.BaseDirectory); chartDir != "" {
+			if f.Verify {
+				return nil, fmt.Errorf("local chart %q cannot be verified, it has no provenance", f.Chart)
+			}
 			fnlog.WithField("chart", chartDir).Debug("using local chart directory")
 			archive = chartDir
 		}
 	}
 
-	if archive == "" {
-		getters := getter.All(settings)
-		regclient, err := getRegistryClient()
-		if err != nil {
-			return nil, errors.Wrap(err, "getting registry client")
-		}
-		c := downloader.ChartDownloader{
-			Out:              os.Stderr,
-			Getters:          getters,
-			RepositoryConfig: settings.RepositoryConfig,
-			RepositoryCache:  settings.RepositoryCache,
-			RegistryClient:   regclient,
-		}
-
-		if f.Repo != "" {
-			// if repo is specified, resolve url from repo and chart name
-			fnlog.WithFields(
-				log.Fields{
-					"repo":    f.Repo,
-					"chart":   f.Chart,
-					"version": f.Version,
-				},
-			).Debug("resolving chart url from repo")
-			chartURL, err = repo.FindChartInRepoURL(
-				f.Repo,
-				f.Chart,
-				f.Version,
-				"", "", "",
-				getters,
-			)
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to resolve chart url")
-			}
-		} else {
-			// otherwise, assume Chart is the full chart URL
-			chartURL = f.Chart
-		}
+	var fetched *fetchedChart
 
-		fnlog.WithField("url", f.Chart).Debug("downloading chart from url")
+	if archive == "" {
 		tmpDir, err := os.MkdirTemp("", "konvert")
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to create temp directory")
 		}
 		defer cleanupTmpDir(tmpDir, fnlog)
 
-		archive, _, err = c.DownloadTo(chartURL, f.Version, tmpDir)
+		fetched, err = f.fetchChart(settings, tmpDir)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to download chart")
+			return nil, err
+		}
+		archive = fetched.archive
+
+		digest, err := provenance.DigestFile(archive)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to compute chart digest")
+		}
+		f.resolved = &resolvedChart{
+			url:    fetched.url,
+			digest: "sha256:" + digest,
 		}
 	}
 
@@ -201,6 +217,17 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to load chart")
 	}
+	if f.resolved != nil {
+		f.resolved.version = chart.Metadata.Version
+		f.resolved.chart = chart
+	}
+	if f.Verify {
+		signer, err := f.verifyChart(fetched, chart.Metadata, tmpdir)
+		if err != nil {
+			return nil, err
+		}
+		f.resolved.signer = signer
+	}
 
 	err = chartutil.SaveDir(chart, cachePath)
 	if err != nil {
@@ -239,7 +266,22 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 		client.APIVersions = chartutil.VersionSet(f.APIVersions)
 	}
 
-	release, err := client.Run(chart, f.Values)
+	values, err := f.mergeValues()
+	if err != nil {
+		return nil, err
+	}
+
+	// helm validates the values too, but its error cannot be traced back to
+	// the function config
+	violations, err := validateValues(chart, values)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, f.valuesSource.schemaError(f.Chart, violations)
+	}
+
+	release, err := client.Run(chart, values)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to run helm install action")
 	}
@@ -267,7 +309,8 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 		}
 	}
 
-	renderedNodes, err := parseManifests(manifests)
+	renderedNodes, skipped, err := parseManifests(manifests)
+	f.skipped = skipped
 	if err != nil {
 		return renderedNodes, err
 	}
@@ -283,6 +326,9 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 		}
 		nonChartNodes = append(nonChartNodes, item)
 	}
+	f.results = append(f.results, skipped...)
+	f.results = append(f.results, renderResults(chartResources(items, f.Repo, f.Chart), renderedNodes)...)
+	f.results = append(f.results, deprecatedAPIResults(renderedNodes)...)
 	// append newly rendered chart nodes
 	items = append(nonChartNodes, renderedNodes...)
 
@@ -290,6 +336,130 @@ func (f *RenderHelmChartFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode,
 	return items, nil
 }
 
+// mergeValues merges ValuesFiles in order, each one taking precedence over the
+// previous, and then applies the inline Values on top using Helm's coalescing
+// semantics (null values are preserved so they can still remove chart
+// defaults). Finally Set, SetString and SetFile are applied the same way `helm
+// template --set/--set-string/--set-file` would.
+func (f *RenderHelmChartFunction) mergeValues() (map[string]interface{}, error) {
+	if len(f.ValuesFiles) == 0 && len(f.Set) == 0 && len(f.SetString) == 0 && len(f.SetFile) == 0 {
+		return f.Values, nil
+	}
+
+	values := map[string]interface{}{}
+	for _, valuesFile := range f.ValuesFiles {
+		path := resolvePath(valuesFile, f.BaseDirectory)
+		log.WithFields(log.Fields{"fn": f.Name(), "values-file": path}).Debug("loading values file")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read values file %q", valuesFile)
+		}
+		fileValues := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse values file %q", valuesFile)
+		}
+		values = chartutil.MergeTables(fileValues, values)
+	}
+
+	if len(f.Values) > 0 {
+		// MergeTables modifies dst, don't let it change the configured values
+		inline, err := copystructure.Copy(f.Values)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to copy values")
+		}
+		values = chartutil.MergeTables(inline.(map[string]interface{}), values)
+	}
+
+	for _, key := range sortedKeys(f.Set) {
+		value, err := setValueString(f.Set[key])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse set %q", key)
+		}
+		if err := strvals.ParseInto(key+"="+value, values); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse set %q", key)
+		}
+	}
+
+	for _, key := range sortedKeys(f.SetString) {
+		value, err := setValueString(f.SetString[key])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse setString %q", key)
+		}
+		if err := strvals.ParseIntoString(key+"="+value, values); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse setString %q", key)
+		}
+	}
+
+	reader := func(rs []rune) (interface{}, error) {
+		path := resolvePath(string(rs), f.BaseDirectory)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	for _, key := range sortedKeys(f.SetFile) {
+		if err := strvals.ParseIntoFile(key+"="+f.SetFile[key], values, reader); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse setFile %q", key)
+		}
+	}
+
+	return values, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setValueString formats a value from the spec the way it would have been
+// typed on the command line, lists are written with Helm's {a,b} syntax. Maps
+// cannot be expressed with --set and must be configured in values instead.
+func setValueString(value interface{}) (string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return scalarString(value)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		s, err := scalarString(item)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid list item %d", i)
+		}
+		// commas would split the item in two
+		items[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(s)
+	}
+	return "{" + strings.Join(items, ",") + "}", nil
+}
+
+// scalarString formats a scalar value from the spec the way it would have been
+// typed on the command line
+func scalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string, bool, int, int64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", errors.Errorf("unsupported value of type %T, only scalars and lists of scalars can be set, use values for maps", value)
+	}
+}
+
+// resolvePath resolves a path relative to the working directory (typically
+// the directory of the Konvert file)
+func resolvePath(path string, workingDir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(workingDir, path)
+}
+
 func resolveLocalChartDirectory(chart string, workingDir string) string {
 	if !filepath.IsAbs(chart) {
 		chartDir, err := filepath.Abs(filepath.Join(workingDir, chart))
@@ -304,6 +474,21 @@ func resolveLocalChartDirectory(chart string, workingDir string) string {
 	return chart
 }
 
+// newHelmSettings isolates helm's config, cache and data directories in
+// tmpdir so rendering never depends on (or changes) the user's helm setup
+func newHelmSettings(tmpdir string) *cli.EnvSettings {
+	configPath := filepath.Join(tmpdir, ".config")
+	cachePath := filepath.Join(tmpdir, ".cache")
+	dataPath := filepath.Join(tmpdir, ".data")
+
+	settings := cli.New()
+	settings.PluginsDirectory = filepath.Join(dataPath, "plugins")
+	settings.RegistryConfig = filepath.Join(configPath, "registry.json")
+	settings.RepositoryConfig = filepath.Join(configPath, "repositories.yaml")
+	settings.RepositoryCache = filepath.Join(cachePath, "repository")
+	return settings
+}
+
 func cleanupTmpDir(tmpdir string, log *log.Entry) {
 	if err := os.RemoveAll(tmpdir); err != nil {
 		log.WithError(err).
@@ -311,10 +496,3 @@ func cleanupTmpDir(tmpdir string, log *log.Entry) {
 			Error("unable to remove temporary directory")
 	}
 }
-
-func getRegistryClient() (*registry.Client, error) {
-	return registry.NewClient(
-		registry.ClientOptDebug(true),
-		registry.ClientOptEnableCache(true),
-	)
-}
@@ -3,11 +3,15 @@ package functions
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
 	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
@@ -367,13 +371,30 @@ func TestRenderHelmChartFilter(t *testing.T) {
 }
 
 // TestManifestParsing tests that we correctly handle empty and comment-only manifests
+func TestNewHelmSettings(t *testing.T) {
+	t.Setenv("HELM_CONFIG_HOME", "/helm/config")
+	tmpdir := t.TempDir()
+
+	settings := newHelmSettings(tmpdir)
+	for name, path := range map[string]string{
+		"plugins":          settings.PluginsDirectory,
+		"registry config":  settings.RegistryConfig,
+		"repository":       settings.RepositoryConfig,
+		"repository cache": settings.RepositoryCache,
+	} {
+		assert.True(t, strings.HasPrefix(path, tmpdir+string(filepath.Separator)), name)
+	}
+	assert.Equal(t, "/helm/config", os.Getenv("HELM_CONFIG_HOME"), "the environment is not changed")
+}
+
 func TestManifestParsing(t *testing.T) {
 	tests := []struct {
-		name          string
-		manifests     map[string]string
-		expectedCount int
-		expectedError bool
-		description   string
+		name            string
+		manifests       map[string]string
+		expectedCount   int
+		expectedSkipped []string
+		expectedError   bool
+		description     string
 	}{
 		{
 			name: "valid-manifests-only",
@@ -407,8 +428,9 @@ spec:
   - port: 80`,
 				"empty.yaml": "",
 			},
-			expectedCount: 1,
-			description:   "Should skip empty manifests",
+			expectedCount:   1,
+			expectedSkipped: []string{"empty.yaml"},
+			description:     "Should skip empty manifests",
 		},
 		{
 			name: "with-whitespace-only-manifest",
@@ -422,8 +444,9 @@ spec:
   - port: 80`,
 				"whitespace.yaml": "   \n\n  \t  \n",
 			},
-			expectedCount: 1,
-			description:   "Should skip whitespace-only manifests",
+			expectedCount:   1,
+			expectedSkipped: []string{"whitespace.yaml"},
+			description:     "Should skip whitespace-only manifests",
 		},
 		{
 			name: "with-comment-only-manifest",
@@ -439,8 +462,9 @@ spec:
 # Copyright (c) Example Corp
 # This is a comment-only file`,
 			},
-			expectedCount: 1,
-			description:   "Should skip comment-only manifests",
+			expectedCount:   1,
+			expectedSkipped: []string{"comments.yaml"},
+			description:     "Should skip comment-only manifests",
 		},
 		{
 			name: "with-mixed-empty-and-comment-only",
@@ -462,8 +486,9 @@ metadata:
 data:
   key: value`,
 			},
-			expectedCount: 2,
-			description:   "Should skip all empty and comment-only manifests",
+			expectedCount:   2,
+			expectedSkipped: []string{"comments.yaml", "empty1.yaml", "empty2.yaml"},
+			description:     "Should skip all empty and comment-only manifests",
 		},
 		{
 			name: "all-empty-or-comments",
@@ -472,15 +497,16 @@ data:
 				"whitespace.yaml": "  \n\t\n  ",
 				"comments.yaml":   "# Only comments here",
 			},
-			expectedCount: 0,
-			description:   "Should return no manifests when all are empty or comments",
+			expectedCount:   0,
+			expectedSkipped: []string{"comments.yaml", "empty.yaml", "whitespace.yaml"},
+			description:     "Should return no manifests when all are empty or comments",
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			// Call the actual parseManifests function from render_helm_chart.go
-			renderedNodes, err := parseManifests(test.manifests)
+			renderedNodes, skipped, err := parseManifests(test.manifests)
 
 			if test.expectedError {
 				assert.Error(t, err, test.description)
@@ -489,6 +515,12 @@ data:
 
 			require.NoError(t, err, test.description)
 			assert.Equal(t, test.expectedCount, len(renderedNodes), test.description)
+			var skippedPaths []string
+			for _, result := range skipped {
+				assert.Equal(t, framework.Warning, result.Severity, test.description)
+				skippedPaths = append(skippedPaths, result.File.Path)
+			}
+			assert.Equal(t, test.expectedSkipped, skippedPaths, test.description)
 		})
 	}
 }
@@ -610,3 +642,282 @@ func TestRenderHelmChartFilterWithAPIVersions(t *testing.T) {
 		})
 	}
 }
+
+// TestRenderHelmChartMergeValues tests that values files are merged in order and inline values are applied on top
+func TestRenderHelmChartMergeValues(t *testing.T) {
+	baseDir := t.TempDir()
+	valuesFiles := map[string]string{
+		"common.yaml": `replicaCount: 2
+image:
+  repository: nginx
+  tag: "1.25"
+service:
+  type: ClusterIP
+  port: 80
+`,
+		"env/prod.yaml": `replicaCount: 3
+image:
+  tag: "1.26"
+service:
+  port: null
+`,
+	}
+	for name, content := range valuesFiles {
+		path := filepath.Join(baseDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644), "WriteFile")
+	}
+
+	var tests = []struct {
+		name           string
+		valuesFiles    []string
+		values         map[string]interface{}
+		expectedValues map[string]interface{}
+		expectedError  string
+	}{
+		{
+			name: "inline-only",
+			values: map[string]interface{}{
+				"replicaCount": float64(1),
+			},
+			expectedValues: map[string]interface{}{
+				"replicaCount": float64(1),
+			},
+		},
+		{
+			name:        "single-file",
+			valuesFiles: []string{"common.yaml"},
+			expectedValues: map[string]interface{}{
+				"replicaCount": float64(2),
+				"image": map[string]interface{}{
+					"repository": "nginx",
+					"tag":        "1.25",
+				},
+				"service": map[string]interface{}{
+					"type": "ClusterIP",
+					"port": float64(80),
+				},
+			},
+		},
+		{
+			name:        "files-in-order-then-inline",
+			valuesFiles: []string{"common.yaml", "env/prod.yaml"},
+			values: map[string]interface{}{
+				"image": map[string]interface{}{
+					"pullPolicy": "Always",
+				},
+				"replicaCount": float64(5),
+			},
+			expectedValues: map[string]interface{}{
+				"replicaCount": float64(5),
+				"image": map[string]interface{}{
+					"repository": "nginx",
+					"tag":        "1.26",
+					"pullPolicy": "Always",
+				},
+				"service": map[string]interface{}{
+					"type": "ClusterIP",
+					"port": nil,
+				},
+			},
+		},
+		{
+			name:        "absolute-path",
+			valuesFiles: []string{filepath.Join(baseDir, "common.yaml")},
+			expectedValues: map[string]interface{}{
+				"replicaCount": float64(2),
+				"image": map[string]interface{}{
+					"repository": "nginx",
+					"tag":        "1.25",
+				},
+				"service": map[string]interface{}{
+					"type": "ClusterIP",
+					"port": float64(80),
+				},
+			},
+		},
+		{
+			name:          "missing-file",
+			valuesFiles:   []string{"missing.yaml"},
+			expectedError: `unable to read values file "missing.yaml"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fn RenderHelmChartFunction
+			fn.ValuesFiles = test.valuesFiles
+			fn.Values = test.values
+			fn.BaseDirectory = baseDir
+
+			values, err := fn.mergeValues()
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expectedValues, values, test.name)
+			assert.Equal(t, test.values, fn.Values, "inline values are not modified")
+		})
+	}
+}
+
+// TestRenderHelmChartFilterWithValuesFiles tests that values files are used when rendering a chart
+func TestRenderHelmChartFilterWithValuesFiles(t *testing.T) {
+	valuesDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(valuesDir, "values.yaml"), []byte("replicaCount: 4\n"), 0644)
+	require.NoError(t, err, "WriteFile")
+
+	chartDir, err := filepath.Abs("./examples/local-chart")
+	require.NoError(t, err, "Abs")
+
+	var fn RenderHelmChartFunction
+	fn.Chart = chartDir
+	fn.ReleaseName = "local-chart"
+	fn.Namespace = "test"
+	fn.ValuesFiles = []string{"values.yaml"}
+	fn.BaseDirectory = valuesDir
+
+	output, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+
+	var replicas string
+	for _, node := range output {
+		if node.GetKind() == "Deployment" {
+			field, err := node.Pipe(kyaml.Lookup("spec", "replicas"))
+			require.NoError(t, err, "Lookup")
+			replicas = field.YNode().Value
+		}
+	}
+	assert.Equal(t, "4", replicas, "replicas")
+}
+
+// TestRenderHelmChartMergeSetValues tests that set, setString and setFile are applied after values
+func TestRenderHelmChartMergeSetValues(t *testing.T) {
+	baseDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(baseDir, "ca.crt"), []byte("-----BEGIN CERTIFICATE-----\n"), 0644)
+	require.NoError(t, err, "WriteFile")
+
+	var tests = []struct {
+		name           string
+		values         map[string]interface{}
+		set            map[string]interface{}
+		setString      map[string]interface{}
+		setFile        map[string]string
+		expectedValues map[string]interface{}
+		expectedError  string
+	}{
+		{
+			name: "set-overrides-values",
+			values: map[string]interface{}{
+				"controller": map[string]interface{}{
+					"image": map[string]interface{}{
+						"repository": "nginx",
+						"tag":        "1.25",
+					},
+				},
+			},
+			set: map[string]interface{}{
+				"controller.image.tag": "1.26",
+				"controller.replicas":  float64(3),
+				"controller.enabled":   true,
+				"controller.args":      "{--v=2,--debug}",
+			},
+			expectedValues: map[string]interface{}{
+				"controller": map[string]interface{}{
+					"image": map[string]interface{}{
+						"repository": "nginx",
+						"tag":        "1.26",
+					},
+					"replicas": int64(3),
+					"enabled":  true,
+					"args":     []interface{}{"--v=2", "--debug"},
+				},
+			},
+		},
+		{
+			name: "set-list",
+			set: map[string]interface{}{
+				"controller.args":  []interface{}{"--v=2", "--labels=a,b"},
+				"controller.ports": []interface{}{float64(80), float64(443)},
+			},
+			expectedValues: map[string]interface{}{
+				"controller": map[string]interface{}{
+					"args":  []interface{}{"--v=2", "--labels=a,b"},
+					"ports": []interface{}{int64(80), int64(443)},
+				},
+			},
+		},
+		{
+			name: "set-map",
+			set: map[string]interface{}{
+				"controller.image": map[string]interface{}{"tag": "1.26"},
+			},
+			expectedError: `unable to parse set "controller.image": unsupported value of type map[string]interface {}`,
+		},
+		{
+			name: "set-nested-list",
+			set: map[string]interface{}{
+				"controller.args": []interface{}{[]interface{}{"a"}},
+			},
+			expectedError: `unable to parse set "controller.args": invalid list item 0`,
+		},
+		{
+			name: "set-string",
+			set: map[string]interface{}{
+				"image.tag": float64(2),
+			},
+			setString: map[string]interface{}{
+				"image.tag":   float64(2),
+				"podLabels.x": true,
+			},
+			expectedValues: map[string]interface{}{
+				"image": map[string]interface{}{
+					"tag": "2",
+				},
+				"podLabels": map[string]interface{}{
+					"x": "true",
+				},
+			},
+		},
+		{
+			name: "set-file",
+			setFile: map[string]string{
+				"tls.ca": "ca.crt",
+			},
+			expectedValues: map[string]interface{}{
+				"tls": map[string]interface{}{
+					"ca": "-----BEGIN CERTIFICATE-----\n",
+				},
+			},
+		},
+		{
+			name: "set-file-missing",
+			setFile: map[string]string{
+				"tls.ca": "missing.crt",
+			},
+			expectedError: `unable to parse setFile "tls.ca"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fn RenderHelmChartFunction
+			fn.Values = test.values
+			fn.Set = test.set
+			fn.SetString = test.setString
+			fn.SetFile = test.setFile
+			fn.BaseDirectory = baseDir
+
+			values, err := fn.mergeValues()
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expectedValues, values, test.name)
+		})
+	}
+}
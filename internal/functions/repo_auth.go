@@ -0,0 +1,143 @@
+package functions
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// RepoAuth configures authentication for a private chart repository. Secrets
+// are never configured inline, they are read from environment variables or
+// local files. File paths are relative to the Konvert file.
+type RepoAuth struct {
+	Username              string `json:"username,omitempty" yaml:"username,omitempty"`
+	UsernameEnv           string `json:"usernameEnv,omitempty" yaml:"usernameEnv,omitempty"`
+	PasswordEnv           string `json:"passwordEnv,omitempty" yaml:"passwordEnv,omitempty"`
+	PasswordFile          string `json:"passwordFile,omitempty" yaml:"passwordFile,omitempty"`
+	CAFile                string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	CertFile              string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile               string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty" yaml:"insecureSkipTLSVerify,omitempty"`
+	PassCredentialsAll    bool   `json:"passCredentialsAll,omitempty" yaml:"passCredentialsAll,omitempty"`
+}
+
+// repoCredentials are the resolved RepoAuth settings
+type repoCredentials struct {
+	username              string
+	password              string
+	caFile                string
+	certFile              string
+	keyFile               string
+	insecureSkipTLSVerify bool
+	passCredentialsAll    bool
+}
+
+// credentials resolves environment variables and file paths. A nil RepoAuth
+// resolves to empty credentials.
+func (a *RepoAuth) credentials(baseDir string) (repoCredentials, error) {
+	var creds repoCredentials
+	if a == nil {
+		return creds, nil
+	}
+
+	username, password, err := resolveBasicAuth(a.Username, a.UsernameEnv, a.PasswordEnv, a.PasswordFile, baseDir)
+	if err != nil {
+		return creds, err
+	}
+	creds.username = username
+	creds.password = password
+
+	for _, file := range []struct {
+		path string
+		dest *string
+	}{
+		{a.CAFile, &creds.caFile},
+		{a.CertFile, &creds.certFile},
+		{a.KeyFile, &creds.keyFile},
+	} {
+		if file.path != "" {
+			*file.dest = resolvePath(file.path, baseDir)
+		}
+	}
+
+	creds.insecureSkipTLSVerify = a.InsecureSkipTLSVerify
+	creds.passCredentialsAll = a.PassCredentialsAll
+	return creds, nil
+}
+
+// getterOptions returns the options for downloading chartURL. Basic auth
+// credentials are only sent to the repository host unless passCredentialsAll
+// is set (just like helm does for repositories it knows about).
+func (c repoCredentials) getterOptions(repoURL, chartURL string) []getter.Option {
+	var opts []getter.Option
+	if c.certFile != "" || c.keyFile != "" || c.caFile != "" {
+		opts = append(opts, getter.WithTLSClientConfig(c.certFile, c.keyFile, c.caFile))
+	}
+	if c.insecureSkipTLSVerify {
+		opts = append(opts, getter.WithInsecureSkipVerifyTLS(true))
+	}
+	if c.username != "" || c.password != "" {
+		if repoURL == "" || c.passCredentialsAll || sameHost(repoURL, chartURL) {
+			opts = append(opts,
+				getter.WithBasicAuth(c.username, c.password),
+				getter.WithPassCredentialsAll(c.passCredentialsAll),
+			)
+		}
+	}
+	return opts
+}
+
+// resolveBasicAuth resolves a username and password configured inline, from
+// environment variables or from a file
+func resolveBasicAuth(username, usernameEnv, passwordEnv, passwordFile, baseDir string) (string, string, error) {
+	var password string
+	if usernameEnv != "" {
+		value, err := lookupEnv(usernameEnv)
+		if err != nil {
+			return "", "", errors.Wrap(err, "unable to resolve username")
+		}
+		username = value
+	}
+
+	switch {
+	case passwordEnv != "" && passwordFile != "":
+		return "", "", fmt.Errorf("only one of passwordEnv and passwordFile can be set")
+	case passwordEnv != "":
+		value, err := lookupEnv(passwordEnv)
+		if err != nil {
+			return "", "", errors.Wrap(err, "unable to resolve password")
+		}
+		password = value
+	case passwordFile != "":
+		data, err := os.ReadFile(resolvePath(passwordFile, baseDir))
+		if err != nil {
+			return "", "", errors.Wrap(err, "unable to read password file")
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	return username, password, nil
+}
+
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	}
+	return value, nil
+}
+
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host == ub.Host
+}
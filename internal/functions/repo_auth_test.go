@@ -0,0 +1,233 @@
+package functions
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// testChartRepo is a chart repository serving the local-chart example in
+// one or more versions
+type testChartRepo struct {
+	*httptest.Server
+	dir      string
+	username string
+	password string
+}
+
+func newTestChartRepo(t *testing.T, secure bool, username, password string, versions ...string) *testChartRepo {
+	t.Helper()
+	r := &testChartRepo{
+		dir:      t.TempDir(),
+		username: username,
+		password: password,
+	}
+
+	files := http.FileServer(http.Dir(r.dir))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.username != "" {
+			u, p, ok := req.BasicAuth()
+			if !ok || u != r.username || p != r.password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		files.ServeHTTP(w, req)
+	})
+	if secure {
+		r.Server = httptest.NewTLSServer(handler)
+	} else {
+		r.Server = httptest.NewServer(handler)
+	}
+	t.Cleanup(r.Close)
+
+	for _, version := range versions {
+		r.addVersion(t, version)
+	}
+	return r
+}
+
+func (r *testChartRepo) addVersion(t *testing.T, version string) {
+	t.Helper()
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Metadata.Version = version
+	r.addChart(t, chart)
+}
+
+// addChart publishes chart, e.g. a local-chart version with different values
+func (r *testChartRepo) addChart(t *testing.T, chart *helmchart.Chart) {
+	t.Helper()
+	archive, err := chartutil.Save(chart, r.dir)
+	require.NoError(t, err, "Save")
+	digest, err := provenance.DigestFile(archive)
+	require.NoError(t, err, "DigestFile")
+
+	indexPath := filepath.Join(r.dir, "index.yaml")
+	index := repo.NewIndexFile()
+	if _, err := os.Stat(indexPath); err == nil {
+		index, err = repo.LoadIndexFile(indexPath)
+		require.NoError(t, err, "LoadIndexFile")
+	}
+	require.NoError(t, index.MustAdd(chart.Metadata, filepath.Base(archive), r.URL, digest), "MustAdd")
+	index.SortEntries()
+	require.NoError(t, index.WriteFile(indexPath, 0644), "WriteFile")
+}
+
+// writeCA writes the server certificate so it can be used as a CA file
+func (r *testChartRepo) writeCA(t *testing.T, dir string) string {
+	t.Helper()
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, ca, 0644), "WriteFile")
+	return caFile
+}
+
+func TestRenderHelmChartFilterWithAuth(t *testing.T) {
+	chartRepo := newTestChartRepo(t, true, "konvert", "s3cr3t", "0.1.0")
+
+	baseDir := t.TempDir()
+	chartRepo.writeCA(t, baseDir)
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "password"), []byte("s3cr3t\n"), 0600), "WriteFile")
+	t.Setenv("KONVERT_TEST_USERNAME", "konvert")
+	t.Setenv("KONVERT_TEST_PASSWORD", "s3cr3t")
+
+	var tests = []struct {
+		name          string
+		auth          *RepoAuth
+		expectedError string
+	}{
+		{
+			name: "env-credentials-and-ca",
+			auth: &RepoAuth{
+				UsernameEnv: "KONVERT_TEST_USERNAME",
+				PasswordEnv: "KONVERT_TEST_PASSWORD",
+				CAFile:      "ca.crt",
+			},
+		},
+		{
+			name: "password-file-and-insecure",
+			auth: &RepoAuth{
+				Username:              "konvert",
+				PasswordFile:          "password",
+				InsecureSkipTLSVerify: true,
+			},
+		},
+		{
+			name: "wrong-password",
+			auth: &RepoAuth{
+				Username:    "konvert",
+				PasswordEnv: "KONVERT_TEST_USERNAME",
+				CAFile:      "ca.crt",
+			},
+			expectedError: "401 Unauthorized",
+		},
+		{
+			name: "untrusted-certificate",
+			auth: &RepoAuth{
+				UsernameEnv: "KONVERT_TEST_USERNAME",
+				PasswordEnv: "KONVERT_TEST_PASSWORD",
+			},
+			expectedError: "certificate",
+		},
+		{
+			name: "missing-env",
+			auth: &RepoAuth{
+				UsernameEnv: "KONVERT_TEST_MISSING",
+			},
+			expectedError: `environment variable "KONVERT_TEST_MISSING" is not set`,
+		},
+		{
+			name:          "no-auth",
+			expectedError: "unable to resolve chart url",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testChartCache(t)
+
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = chartRepo.URL
+			fn.Chart = "local-chart"
+			fn.Version = "0.1.0"
+			fn.Auth = test.auth
+			fn.BaseDirectory = baseDir
+
+			output, err := fn.Filter([]*kyaml.RNode{})
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.NotEmpty(t, output, test.name)
+		})
+	}
+}
+
+func TestRepoCredentialsGetterOptions(t *testing.T) {
+	var tests = []struct {
+		name          string
+		creds         repoCredentials
+		repoURL       string
+		chartURL      string
+		expectedCount int
+	}{
+		{
+			name:          "no-credentials",
+			repoURL:       "https://charts.example.com",
+			chartURL:      "https://charts.example.com/chart-0.1.0.tgz",
+			expectedCount: 0,
+		},
+		{
+			name:          "same-host",
+			creds:         repoCredentials{username: "user", password: "pass"},
+			repoURL:       "https://charts.example.com",
+			chartURL:      "https://charts.example.com/chart-0.1.0.tgz",
+			expectedCount: 2,
+		},
+		{
+			name:          "other-host",
+			creds:         repoCredentials{username: "user", password: "pass"},
+			repoURL:       "https://charts.example.com",
+			chartURL:      "https://github.com/example/releases/chart-0.1.0.tgz",
+			expectedCount: 0,
+		},
+		{
+			name:          "other-host-pass-credentials-all",
+			creds:         repoCredentials{username: "user", password: "pass", passCredentialsAll: true},
+			repoURL:       "https://charts.example.com",
+			chartURL:      "https://github.com/example/releases/chart-0.1.0.tgz",
+			expectedCount: 2,
+		},
+		{
+			name:          "tls",
+			creds:         repoCredentials{caFile: "ca.crt", insecureSkipTLSVerify: true},
+			repoURL:       "https://charts.example.com",
+			chartURL:      "https://charts.example.com/chart-0.1.0.tgz",
+			expectedCount: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.creds.getterOptions(test.repoURL, test.chartURL)
+			assert.Equal(t, test.expectedCount, len(opts), fmt.Sprintf("%s options", test.name))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package functions
+
+import (
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// resultsReporter is implemented by functions reporting results besides their
+// output, e.g. the resources they rendered
+type resultsReporter interface {
+	Results() framework.Results
+}
+
+// resultsError is an error made of several results, e.g. one per invalid
+// value, reported individually in fn mode
+type resultsError interface {
+	error
+	Results() framework.Results
+}
+
+// resourceRef identifies rn in a result
+func resourceRef(rn *kyaml.RNode) *kyaml.ResourceIdentifier {
+	if rn == nil {
+		return nil
+	}
+	return &kyaml.ResourceIdentifier{
+		TypeMeta: kyaml.TypeMeta{APIVersion: rn.GetApiVersion(), Kind: rn.GetKind()},
+		NameMeta: kyaml.NameMeta{Name: rn.GetName(), Namespace: rn.GetNamespace()},
+	}
+}
+
+// resourceFile is the file rn was read from or is written to, nil when it
+// has no path annotation
+func resourceFile(rn *kyaml.RNode) *framework.File {
+	if rn == nil {
+		return nil
+	}
+	path, index, err := kioutil.GetFileAnnotations(rn)
+	if err != nil || path == "" {
+		return nil
+	}
+	file := &framework.File{Path: path}
+	file.Index, _ = strconv.Atoi(index)
+	return file
+}
+
+// resourceResult is a result about rn
+func resourceResult(rn *kyaml.RNode, severity framework.Severity, message string) *framework.Result {
+	return &framework.Result{
+		Message:     message,
+		Severity:    severity,
+		ResourceRef: resourceRef(rn),
+		File:        resourceFile(rn),
+	}
+}
+
+// chartResources returns the items previously rendered from the chart
+func chartResources(items []*kyaml.RNode, repo, chart string) []*kyaml.RNode {
+	var resources []*kyaml.RNode
+	for _, item := range items {
+		if item.GetAnnotations()[annotationKonvertChart] == konvertChartAnnotationValue(repo, chart) {
+			resources = append(resources, item)
+		}
+	}
+	return resources
+}
+
+// unrenderedResources returns the items that were not rendered from a chart,
+// e.g. Konvert files and kustomizations
+func unrenderedResources(items []*kyaml.RNode) []*kyaml.RNode {
+	var resources []*kyaml.RNode
+	for _, item := range items {
+		if _, ok := item.GetAnnotations()[annotationKonvertChart]; !ok {
+			resources = append(resources, item)
+		}
+	}
+	return resources
+}
+
+// renderResults reports every rendered resource, and the previously rendered
+// resources the chart no longer renders
+func renderResults(previous, rendered []*kyaml.RNode) framework.Results {
+	var results framework.Results
+	renderedIDs := make(map[string]bool)
+	for _, rn := range rendered {
+		renderedIDs[resourceID(rn)] = true
+		results = append(results, resourceResult(rn, framework.Info, "rendered"))
+	}
+	for _, rn := range previous {
+		if !renderedIDs[resourceID(rn)] {
+			results = append(results, resourceResult(rn, framework.Info, "removed, the chart no longer renders it"))
+		}
+	}
+	return results
+}
+
+func resourceID(rn *kyaml.RNode) string {
+	return fmt.Sprintf("%s/%s/%s/%s", rn.GetApiVersion(), rn.GetKind(), rn.GetNamespace(), rn.GetName())
+}
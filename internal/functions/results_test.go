@@ -0,0 +1,132 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestRenderResults(t *testing.T) {
+	previous, err := kio.ParseAll(`apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: apps
+  annotations:
+    config.kubernetes.io/path: app/service.yaml
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+  namespace: apps
+  annotations:
+    config.kubernetes.io/path: app/configmap.yaml
+`)
+	require.NoError(t, err, "ParseAll")
+	rendered, err := kio.ParseAll(`apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: apps
+  annotations:
+    config.kubernetes.io/path: app/service.yaml
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: apps
+  annotations:
+    config.kubernetes.io/path: app/deployment.yaml
+    config.kubernetes.io/index: "1"
+`)
+	require.NoError(t, err, "ParseAll")
+
+	results := renderResults(previous, rendered)
+	require.Len(t, results, 3, "results")
+	var messages []string
+	for _, result := range results {
+		assert.Equal(t, framework.Info, result.Severity, result.Message)
+		messages = append(messages, result.String())
+	}
+	assert.Equal(t, []string{
+		"[info] v1/Service/apps/app: rendered",
+		"[info] apps/v1/Deployment/apps/app: rendered",
+		"[info] v1/ConfigMap/apps/app-config: removed, the chart no longer renders it",
+	}, messages, "messages")
+	assert.Equal(t, &framework.File{Path: "app/deployment.yaml", Index: 1}, results[1].File, "file")
+	assert.Equal(t, "app/configmap.yaml", results[2].File.Path, "removed file")
+}
+
+func TestResourceFile(t *testing.T) {
+	rn, err := kyaml.Parse(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`)
+	require.NoError(t, err, "Parse")
+	assert.Nil(t, resourceFile(rn), "no path")
+	assert.Nil(t, resourceFile(nil), "nil")
+	assert.Nil(t, resourceRef(nil), "nil")
+}
+
+func TestRunFnResults(t *testing.T) {
+	fnconfig, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: local-chart
+  annotations:
+    internal.config.kubernetes.io/path: apps/konvert.yaml
+    internal.config.kubernetes.io/index: "0"
+spec:
+  chart: ./examples/local-chart
+  path: local-chart
+`)
+	require.NoError(t, err, "Parse")
+	stale, err := kyaml.Parse(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: stale
+  annotations:
+    konvert.kumorilabs.io/chart: ./examples/local-chart
+    config.kubernetes.io/path: apps/local-chart/configmap-stale.yaml
+`)
+	require.NoError(t, err, "Parse")
+
+	resourceList := &framework.ResourceList{
+		Items:          []*kyaml.RNode{stale},
+		FunctionConfig: fnconfig,
+	}
+	require.NoError(t, runFn(&KonvertFunction{}, resourceList), "runFn")
+
+	var rendered, removed int
+	for _, result := range resourceList.Results {
+		switch result.Message {
+		case "rendered":
+			rendered++
+			require.NotNil(t, result.File, result.ResourceRef.Name)
+			assert.Contains(t, result.File.Path, "apps/local-chart/", result.ResourceRef.Name)
+		case "removed, the chart no longer renders it":
+			removed++
+			assert.Equal(t, "stale", result.ResourceRef.Name, "removed")
+		}
+	}
+	assert.Equal(t, len(resourceList.Items), rendered, "rendered")
+	assert.Equal(t, 1, removed, "removed")
+
+	// errors reference the function config
+	require.NoError(t, fnconfig.PipeE(kyaml.SetField("spec", kyaml.NewMapRNode(&map[string]string{"chart": "./missing"}))), "SetField")
+	resourceList = &framework.ResourceList{FunctionConfig: fnconfig}
+	require.Error(t, runFn(&KonvertFunction{}, resourceList), "runFn")
+	require.Len(t, resourceList.Results, 1, "results")
+	result := resourceList.Results[0]
+	assert.Equal(t, framework.Error, result.Severity, "severity")
+	assert.Equal(t, "local-chart", result.ResourceRef.Name, "resource")
+	assert.Equal(t, "Konvert", result.ResourceRef.Kind, "kind")
+	assert.Equal(t, &framework.File{Path: "apps/konvert.yaml"}, result.File, "file")
+}
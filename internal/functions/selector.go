@@ -0,0 +1,77 @@
+package functions
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ResourceSelector selects resources by group, version, kind, name,
+// namespace, labels and annotations. Empty fields match every resource. Group,
+// version, kind, name and namespace accept glob patterns, e.g. *-controller.
+type ResourceSelector struct {
+	Group              string `json:"group,omitempty" yaml:"group,omitempty"`
+	Version            string `json:"version,omitempty" yaml:"version,omitempty"`
+	Kind               string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Name               string `json:"name,omitempty" yaml:"name,omitempty"`
+	Namespace          string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	LabelSelector      string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+	AnnotationSelector string `json:"annotationSelector,omitempty" yaml:"annotationSelector,omitempty"`
+}
+
+// Matches returns true if rn is selected
+func (s ResourceSelector) Matches(rn *kyaml.RNode) (bool, error) {
+	group, version := splitAPIVersion(rn.GetApiVersion())
+	for _, field := range []struct {
+		name    string
+		pattern string
+		value   string
+	}{
+		{"group", s.Group, group},
+		{"version", s.Version, version},
+		{"kind", s.Kind, rn.GetKind()},
+		{"name", s.Name, rn.GetName()},
+		{"namespace", s.Namespace, rn.GetNamespace()},
+	} {
+		if field.pattern == "" {
+			continue
+		}
+		matched, err := path.Match(field.pattern, field.value)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid %s pattern %q", field.name, field.pattern)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	if s.LabelSelector != "" {
+		matched, err := rn.MatchesLabelSelector(s.LabelSelector)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid label selector %q", s.LabelSelector)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	if s.AnnotationSelector != "" {
+		matched, err := rn.MatchesAnnotationSelector(s.AnnotationSelector)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid annotation selector %q", s.AnnotationSelector)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// splitAPIVersion returns the group and version of apiVersion, the group of
+// the core API is empty
+func splitAPIVersion(apiVersion string) (string, string) {
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i], apiVersion[i+1:]
+	}
+	return "", apiVersion
+}
@@ -0,0 +1,68 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestResourceSelectorMatches(t *testing.T) {
+	deployment := kyaml.MustParse(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ingress-nginx-controller
+  namespace: ingress
+  labels:
+    app.kubernetes.io/component: controller
+  annotations:
+    team: edge
+`)
+	service := kyaml.MustParse(`apiVersion: v1
+kind: Service
+metadata:
+  name: ingress-nginx-controller
+`)
+
+	var tests = []struct {
+		name          string
+		selector      ResourceSelector
+		rn            *kyaml.RNode
+		expected      bool
+		expectedError string
+	}{
+		{name: "empty", rn: deployment, expected: true},
+		{name: "group", selector: ResourceSelector{Group: "apps"}, rn: deployment, expected: true},
+		{name: "core-group", selector: ResourceSelector{Group: "apps"}, rn: service},
+		{name: "version", selector: ResourceSelector{Version: "v1"}, rn: service, expected: true},
+		{name: "kind", selector: ResourceSelector{Kind: "Service"}, rn: deployment},
+		{name: "group-glob", selector: ResourceSelector{Group: "app*"}, rn: deployment, expected: true},
+		{name: "version-glob", selector: ResourceSelector{Version: "v1beta*"}, rn: deployment},
+		{name: "kind-glob", selector: ResourceSelector{Kind: "*Set"}, rn: deployment},
+		{name: "kind-glob-match", selector: ResourceSelector{Kind: "Deploy*"}, rn: deployment, expected: true},
+		{name: "invalid-kind-pattern", selector: ResourceSelector{Kind: "[Deployment"}, rn: deployment, expectedError: `invalid kind pattern "[Deployment"`},
+		{name: "name", selector: ResourceSelector{Name: "ingress-nginx-controller"}, rn: service, expected: true},
+		{name: "name-glob", selector: ResourceSelector{Name: "*-controller"}, rn: deployment, expected: true},
+		{name: "name-glob-mismatch", selector: ResourceSelector{Name: "*-admission"}, rn: deployment},
+		{name: "namespace-glob", selector: ResourceSelector{Namespace: "ingr*"}, rn: deployment, expected: true},
+		{name: "label", selector: ResourceSelector{LabelSelector: "app.kubernetes.io/component=controller"}, rn: deployment, expected: true},
+		{name: "label-mismatch", selector: ResourceSelector{LabelSelector: "app.kubernetes.io/component in (webhook)"}, rn: deployment},
+		{name: "annotation", selector: ResourceSelector{AnnotationSelector: "team=edge"}, rn: deployment, expected: true},
+		{name: "invalid-pattern", selector: ResourceSelector{Name: "[controller"}, rn: deployment, expectedError: `invalid name pattern "[controller"`},
+		{name: "invalid-label-selector", selector: ResourceSelector{LabelSelector: "app in"}, rn: deployment, expectedError: `invalid label selector "app in"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched, err := test.selector.Matches(test.rn)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expected, matched, test.name)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// the checksum annotation records a digest of each rendered resource so we can
+// tell when a generated resource was edited by hand after it was rendered
+
+const (
+	fnSetChecksumAnnotationName = "checksum-annotation"
+	fnSetChecksumAnnotationKind = "SetChecksumAnnotation"
+	annotationKonvertChecksum   = fnConfigGroup + "/checksum"
+)
+
+// annotations that are not part of the rendered content (they are added by
+// readers/writers or by this function)
+var checksumIgnoredAnnotations = []string{
+	annotationKonvertChecksum,
+	kioutil.PathAnnotation,
+	kioutil.IndexAnnotation,
+	kioutil.IdAnnotation,
+	kioutil.SeqIndentAnnotation,
+	//lint:ignore SA1019 explicitly ignoring legacy annotations that may have been added by framework
+	kioutil.LegacyPathAnnotation, //nolint:staticcheck
+	//lint:ignore SA1019 explicitly ignoring legacy annotations that may have been added by framework
+	kioutil.LegacyIndexAnnotation, //nolint:staticcheck
+	//lint:ignore SA1019 explicitly ignoring legacy annotations that may have been added by framework
+	kioutil.LegacyIdAnnotation, //nolint:staticcheck
+}
+
+type SetChecksumAnnotationProcessor struct{}
+
+func (p *SetChecksumAnnotationProcessor) Process(resourceList *framework.ResourceList) error {
+	return runFn(&SetChecksumAnnotationFunction{}, resourceList)
+}
+
+type SetChecksumAnnotationFunction struct {
+	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
+}
+
+func (f *SetChecksumAnnotationFunction) Name() string {
+	return fnSetChecksumAnnotationName
+}
+
+func (f *SetChecksumAnnotationFunction) SetResourceMeta(meta kyaml.ResourceMeta) {
+	f.ResourceMeta = meta
+}
+
+func (f *SetChecksumAnnotationFunction) Config(rn *kyaml.RNode) error {
+	return loadConfig(f, rn, fnSetChecksumAnnotationKind)
+}
+
+func (f *SetChecksumAnnotationFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	return kio.FilterAll(ChecksumAnnotationSetter{}).Filter(items)
+}
+
+type ChecksumAnnotationSetter struct{}
+
+func (f ChecksumAnnotationSetter) Filter(node *kyaml.RNode) (*kyaml.RNode, error) {
+	checksum, err := resourceChecksum(node)
+	if err != nil {
+		return node, err
+	}
+	err = node.PipeE(kyaml.SetAnnotation(annotationKonvertChecksum, checksum))
+	if err != nil {
+		return node, errors.Wrapf(err, "unable to set annotation %s", annotationKonvertChecksum)
+	}
+	return node, nil
+}
+
+// IsModifiedSinceRender returns true if the resource has a checksum annotation
+// that no longer matches its content. Resources without a checksum are never
+// considered modified.
+func IsModifiedSinceRender(node *kyaml.RNode) (bool, error) {
+	expected, ok := node.GetAnnotations()[annotationKonvertChecksum]
+	if !ok {
+		return false, nil
+	}
+	actual, err := resourceChecksum(node)
+	if err != nil {
+		return false, err
+	}
+	return expected != actual, nil
+}
+
+// resourceChecksum returns the sha256 of the resource's content. The content
+// is serialized as JSON (which sorts keys) so that formatting, comments and
+// yaml styles do not change the checksum.
+func resourceChecksum(node *kyaml.RNode) (string, error) {
+	content := node.Copy()
+	for _, annotation := range checksumIgnoredAnnotations {
+		if err := content.PipeE(kyaml.ClearAnnotation(annotation)); err != nil {
+			return "", errors.Wrapf(err, "unable to clear annotation %s", annotation)
+		}
+	}
+	if err := kyaml.ClearEmptyAnnotations(content); err != nil {
+		return "", errors.Wrap(err, "unable to clear empty annotations")
+	}
+	// the sops metadata of encrypted Secrets covers the checksum, see
+	// EncryptSecretsFunction
+	if err := content.PipeE(kyaml.Clear(sopsField)); err != nil {
+		return "", errors.Wrap(err, "unable to clear sops metadata")
+	}
+
+	data, err := content.MarshalJSON()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal resource")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,159 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestSetChecksumAnnotationFilter(t *testing.T) {
+	input := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: test
+spec:
+  ports:
+  - name: http
+    port: 8080
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+data:
+  env: test
+`
+
+	var fn SetChecksumAnnotationFunction
+	items, err := kio.ParseAll(input)
+	require.NoError(t, err, "ParseAll")
+
+	output, err := fn.Filter(items)
+	require.NoError(t, err, "Filter")
+
+	checksums := make(map[string]bool)
+	for _, node := range output {
+		checksum, ok := node.GetAnnotations()[annotationKonvertChecksum]
+		if !assert.True(t, ok, "missing checksum annotation", node.GetName()) {
+			t.FailNow()
+		}
+		assert.Len(t, checksum, 64, node.GetName())
+		checksums[checksum] = true
+	}
+	assert.Len(t, checksums, 2, "checksums are unique per resource")
+}
+
+func TestIsModifiedSinceRender(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		modify   func(node *kyaml.RNode) error
+		checksum bool
+		expected bool
+	}{
+		{
+			name: "unmodified",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+data:
+  env: test
+`,
+			checksum: true,
+			expected: false,
+		},
+		{
+			name: "formatting-and-reader-annotations",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+data:
+  env: test
+`,
+			modify: func(node *kyaml.RNode) error {
+				node.YNode().HeadComment = "a comment"
+				return node.PipeE(kyaml.SetAnnotation("config.kubernetes.io/path", "configmap.yaml"))
+			},
+			checksum: true,
+			expected: false,
+		},
+		{
+			name: "modified",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+data:
+  env: test
+`,
+			modify: func(node *kyaml.RNode) error {
+				return node.PipeE(kyaml.SetField("data", kyaml.NewMapRNode(&map[string]string{"env": "prod"})))
+			},
+			checksum: true,
+			expected: true,
+		},
+		{
+			name: "no-checksum",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+data:
+  env: test
+`,
+			modify: func(node *kyaml.RNode) error {
+				return node.PipeE(kyaml.SetField("data", kyaml.NewMapRNode(&map[string]string{"env": "prod"})))
+			},
+			checksum: false,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := kyaml.Parse(test.input)
+			require.NoError(t, err, test.name)
+
+			if test.checksum {
+				node, err = ChecksumAnnotationSetter{}.Filter(node)
+				require.NoError(t, err, test.name)
+			}
+			if test.modify != nil {
+				require.NoError(t, test.modify(node), test.name)
+			}
+
+			modified, err := IsModifiedSinceRender(node)
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.expected, modified, test.name)
+		})
+	}
+}
+
+func TestKonvertFilterChecksum(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		fn := Konvert("./examples/konvert.yaml")
+		fn.ResourceMeta.Name = "local-chart"
+		fn.Chart = "./local-chart"
+		fn.Checksum = checksum
+		output, err := fn.Filter([]*kyaml.RNode{})
+		require.NoError(t, err, "Filter")
+		require.NotEmpty(t, output, "output")
+		var annotated int
+		for _, node := range output {
+			if _, ok := node.GetAnnotations()[annotationKonvertChecksum]; ok {
+				annotated++
+			}
+		}
+		if checksum {
+			assert.Equal(t, len(output), annotated, "opted in")
+		} else {
+			assert.Zero(t, annotated, "default")
+		}
+	}
+}
@@ -14,6 +14,8 @@ const (
 	fnSetKonvertAnnotationsKind  = "SetKonvertAnnotations"
 	annotationKonvertGeneratedBy = fnConfigGroup + "/generated-by"
 	annotationKonvertChart       = fnConfigGroup + "/chart"
+	annotationKonvertSignedBy    = fnConfigGroup + "/signed-by"
+	annotationKonvertSigner      = fnConfigGroup + "/signer-fingerprint"
 	defaultGeneratedBy           = "konvert"
 )
 
@@ -27,6 +29,10 @@ type SetKonvertAnnotationsFunction struct {
 	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
 	Chart              string `json:"chart,omitempty" yaml:"chart,omitempty"`
 	Repo               string `json:"repo,omitempty" yaml:"repo,omitempty"`
+	// SignedBy and SignerFingerprint identify the key the chart provenance
+	// was verified with, if any
+	SignedBy          string `json:"signedBy,omitempty" yaml:"signedBy,omitempty"`
+	SignerFingerprint string `json:"signerFingerprint,omitempty" yaml:"signerFingerprint,omitempty"`
 }
 
 func (f *SetKonvertAnnotationsFunction) Name() string {
@@ -60,6 +66,22 @@ func (f *SetKonvertAnnotationsFunction) Filter(items []*kyaml.RNode) ([]*kyaml.R
 		return items, errors.Wrapf(err, "unable to set annotation %s", annotationKonvertChart)
 	}
 
+	if f.SignerFingerprint != "" {
+		items, err = kio.FilterAll(
+			kyaml.SetAnnotation(annotationKonvertSignedBy, f.SignedBy),
+		).Filter(items)
+		if err != nil {
+			return items, errors.Wrapf(err, "unable to set annotation %s", annotationKonvertSignedBy)
+		}
+
+		items, err = kio.FilterAll(
+			kyaml.SetAnnotation(annotationKonvertSigner, f.SignerFingerprint),
+		).Filter(items)
+		if err != nil {
+			return items, errors.Wrapf(err, "unable to set annotation %s", annotationKonvertSigner)
+		}
+	}
+
 	return items, nil
 }
 
@@ -2,8 +2,10 @@ package functions
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
+	"text/template"
 
 	"github.com/pkg/errors"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework"
@@ -29,6 +31,8 @@ type SetPathAnnotationFunction struct {
 	kyaml.ResourceMeta `json:",inline" yaml:",inline"`
 	Path               string `json:"path,omitempty" yaml:"path,omitempty"`
 	Pattern            string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// CRDPath is the directory of CustomResourceDefinitions, Path by default
+	CRDPath string `json:"crdPath,omitempty" yaml:"crdPath,omitempty"`
 }
 
 func (f *SetPathAnnotationFunction) Name() string {
@@ -44,12 +48,18 @@ func (f *SetPathAnnotationFunction) Config(rn *kyaml.RNode) error {
 }
 
 func (f *SetPathAnnotationFunction) Filter(items []*kyaml.RNode) ([]*kyaml.RNode, error) {
-	items, err := kio.FilterAll(
-		PathAnnotation(f.Path, f.Pattern),
-	).Filter(items)
+	items, err := kio.FilterAll(kyaml.FilterFunc(func(node *kyaml.RNode) (*kyaml.RNode, error) {
+		if f.CRDPath != "" && isCRD(node) {
+			return PathAnnotation(f.CRDPath, f.Pattern).Filter(node)
+		}
+		return PathAnnotation(f.Path, f.Pattern).Filter(node)
+	})).Filter(items)
 	if err != nil {
 		return items, errors.Wrapf(err, "unable to set path annotation")
 	}
+	if err := checkPathCollisions(nil, items, !isPathTemplate(f.Pattern)); err != nil {
+		return items, err
+	}
 
 	return items, nil
 }
@@ -59,6 +69,13 @@ type PathAnnotationSetter struct {
 	pattern string
 }
 
+// PathAnnotation sets the path of resources to pattern in path. The pattern
+// is either a Go template (see pathTemplateData) or a fmt string with two
+// string values replaced with the lowercase kind and the name respectively.
+// Examples:
+// %s-%s.yaml
+// base/%s-%s.yaml
+// {{.Namespace}}/{{.Kind | lower}}/{{.Name}}.yaml
 func PathAnnotation(path, pattern string) PathAnnotationSetter {
 	return PathAnnotationSetter{path, pattern}
 }
@@ -75,20 +92,21 @@ func (f PathAnnotationSetter) Filter(node *kyaml.RNode) (*kyaml.RNode, error) {
 	if err != nil {
 		return node, errors.Wrap(err, "unable to get meta from rnode")
 	}
-	kind := strings.ToLower(meta.Kind)
-	name := meta.Name
 
-	// TODO: pattern is not super useful yet
-	// you can only pass a fmt string with two string values
-	// which will be replaced with kind and name respectively
-	// Examples:
-	// %s-%s.yaml
-	// %s_%s.yaml
-	// base/%s-%s.yaml
+	var name string
+	if isPathTemplate(f.pattern) {
+		name, err = executePathTemplate(f.pattern, node, meta)
+		if err != nil {
+			return node, err
+		}
+	} else {
+		name = fmt.Sprintf(f.pattern, strings.ToLower(meta.Kind), meta.Name)
+	}
+
 	err = node.PipeE(
 		kyaml.SetAnnotation(
 			kioutil.PathAnnotation,
-			filepath.Join(f.path, fmt.Sprintf(f.pattern, kind, name)),
+			filepath.Join(f.path, name),
 		),
 	)
 	if err != nil {
@@ -96,3 +114,116 @@ func (f PathAnnotationSetter) Filter(node *kyaml.RNode) (*kyaml.RNode, error) {
 	}
 	return node, nil
 }
+
+// isPathTemplate returns true if pattern is a Go template, a fmt pattern
+// only gets the kind and the name
+func isPathTemplate(pattern string) bool {
+	return strings.Contains(pattern, "{{")
+}
+
+// pathTemplateData is the data of path templates
+type pathTemplateData struct {
+	Group       string
+	Version     string
+	Kind        string
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Source is the chart template the resource was rendered from, e.g.
+	// ingress-nginx/templates/controller-deployment.yaml
+	Source string
+}
+
+var pathTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"base":  path.Base,
+}
+
+func executePathTemplate(pattern string, node *kyaml.RNode, meta kyaml.ResourceMeta) (string, error) {
+	tmpl, err := template.New("pattern").Funcs(pathTemplateFuncs).Option("missingkey=zero").Parse(pattern)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+
+	data := pathTemplateData{
+		Kind:        meta.Kind,
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+		Source:      helmSource(node),
+	}
+	data.Group, data.Version = splitAPIVersion(meta.APIVersion)
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", errors.Wrapf(err, "unable to execute pattern %q for %s %s", pattern, meta.Kind, meta.Name)
+	}
+	name := filepath.Clean(strings.TrimPrefix(sb.String(), "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || strings.HasSuffix(sb.String(), "/") {
+		return "", fmt.Errorf("pattern %q maps %s %s to the invalid file name %q", pattern, meta.Kind, meta.Name, sb.String())
+	}
+	return name, nil
+}
+
+// sourceCommentPrefix starts the comment Helm adds before every rendered
+// resource
+const sourceCommentPrefix = "# Source: "
+
+// helmSource returns the chart template node was rendered from, read from
+// the comment Helm adds before every resource, or an empty string
+func helmSource(node *kyaml.RNode) string {
+	comments := node.YNode().HeadComment
+	if content := node.YNode().Content; node.YNode().Kind == kyaml.MappingNode && len(content) > 0 {
+		comments += "\n" + content[0].HeadComment
+	}
+	for _, line := range strings.Split(comments, "\n") {
+		if strings.HasPrefix(line, sourceCommentPrefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, sourceCommentPrefix))
+		}
+	}
+	return ""
+}
+
+// checkPathCollisions fails when two items, or an item and one of the
+// existing nodes, are written to the same file. With a fmt pattern, items
+// may share a file, e.g. resources of the same kind and name in two
+// namespaces are written to one file with two documents.
+func checkPathCollisions(existing, items []*kyaml.RNode, sharedFiles bool) error {
+	existingPaths := make(map[string]*kyaml.RNode)
+	for _, node := range existing {
+		if path := node.GetAnnotations()[kioutil.PathAnnotation]; path != "" {
+			existingPaths[filepath.Clean(path)] = node
+		}
+	}
+	paths := make(map[string]*kyaml.RNode)
+	for _, item := range items {
+		path := item.GetAnnotations()[kioutil.PathAnnotation]
+		if path == "" {
+			continue
+		}
+		path = filepath.Clean(path)
+		other, ok := existingPaths[path]
+		if !ok && !sharedFiles {
+			other, ok = paths[path]
+		}
+		if ok {
+			return fmt.Errorf(
+				"%s %s and %s %s are both written to %s, change the pattern so every resource gets its own file",
+				other.GetKind(), resourceName(other), item.GetKind(), resourceName(item), path,
+			)
+		}
+		paths[path] = item
+	}
+	return nil
+}
+
+// resourceName returns the namespaced name of rn
+func resourceName(rn *kyaml.RNode) string {
+	if namespace := rn.GetNamespace(); namespace != "" {
+		return namespace + "/" + rn.GetName()
+	}
+	return rn.GetName()
+}
@@ -248,3 +248,215 @@ spec:
 		})
 	}
 }
+
+func TestPathAnnotationSetterTemplate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		path               string
+		pattern            string
+		expectedAnnotation string
+		expectedError      string
+	}{
+		{
+			name:               "namespace-kind-name",
+			path:               ".",
+			pattern:            "{{.Namespace}}/{{.Kind | lower}}/{{.Name}}.yaml",
+			expectedAnnotation: "ingress/deployment/ingress-nginx-controller.yaml",
+		},
+		{
+			name:               "group-version",
+			path:               "upstream",
+			pattern:            "{{.Group}}_{{.Version}}_{{.Kind}}.yaml",
+			expectedAnnotation: "upstream/apps_v1_Deployment.yaml",
+		},
+		{
+			name:               "labels-annotations",
+			path:               ".",
+			pattern:            `{{index .Labels "app.kubernetes.io/component"}}/{{.Annotations.team | upper}}.yaml`,
+			expectedAnnotation: "controller/EDGE.yaml",
+		},
+		{
+			name:               "missing-label",
+			path:               ".",
+			pattern:            "{{.Labels.tier}}-{{.Name}}.yaml",
+			expectedAnnotation: "-ingress-nginx-controller.yaml",
+		},
+		{
+			name:               "source",
+			path:               ".",
+			pattern:            "{{.Source | base}}",
+			expectedAnnotation: "controller-deployment.yaml",
+		},
+		{
+			name:          "invalid-template",
+			path:          ".",
+			pattern:       "{{.Name",
+			expectedError: `invalid pattern "{{.Name"`,
+		},
+		{
+			name:          "unknown-field",
+			path:          ".",
+			pattern:       "{{.Chart}}.yaml",
+			expectedError: `unable to execute pattern "{{.Chart}}.yaml" for Deployment ingress-nginx-controller`,
+		},
+		{
+			name:          "outside-path",
+			path:          ".",
+			pattern:       "../{{.Name}}.yaml",
+			expectedError: `pattern "../{{.Name}}.yaml" maps Deployment ingress-nginx-controller to the invalid file name "../ingress-nginx-controller.yaml"`,
+		},
+		{
+			name:          "directory",
+			path:          ".",
+			pattern:       "{{.Name}}/",
+			expectedError: "to the invalid file name",
+		},
+	}
+
+	const resyaml = `# Source: ingress-nginx/templates/controller-deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ingress-nginx-controller
+  namespace: ingress
+  labels:
+    app.kubernetes.io/component: controller
+  annotations:
+    team: edge
+`
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputNode, err := yaml.Parse(resyaml)
+			if !assert.NoError(t, err, test.name) {
+				t.FailNow()
+			}
+			output, err := PathAnnotation(test.path, test.pattern).Filter(inputNode)
+			if test.expectedError != "" {
+				if assert.NotNil(t, err, test.name) {
+					assert.Contains(t, err.Error(), test.expectedError, test.name)
+				}
+				return
+			}
+			if !assert.NoError(t, err, test.name) {
+				t.FailNow()
+			}
+			assert.Equal(t, test.expectedAnnotation, output.GetAnnotations()[kioutil.PathAnnotation], test.name)
+		})
+	}
+}
+
+func TestHelmSource(t *testing.T) {
+	node, err := yaml.Parse("---\n# Source: chart/templates/service.yaml\n# another comment\napiVersion: v1\nkind: Service\n")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "chart/templates/service.yaml", helmSource(node))
+
+	node, err = yaml.Parse("apiVersion: v1\nkind: Service\n")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "", helmSource(node))
+}
+
+func TestSetPathAnnotationFunctionCollisions(t *testing.T) {
+	inputyaml := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: a
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: b
+`
+	fn := SetPathAnnotationFunction{Path: "upstream"}
+	input, err := kio.ParseAll(inputyaml)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// the default pattern writes both resources to the same file
+	output, err := fn.Filter(input)
+	if !assert.NoError(t, err, "default pattern") {
+		t.FailNow()
+	}
+	assert.Equal(t, "upstream/service-web.yaml", output[0].GetAnnotations()[kioutil.PathAnnotation])
+	assert.Equal(t, "upstream/service-web.yaml", output[1].GetAnnotations()[kioutil.PathAnnotation])
+
+	// so does an explicit fmt pattern
+	fn.Pattern = "base/%s-%s.yaml"
+	output, err = fn.Filter(input)
+	if !assert.NoError(t, err, "fmt pattern") {
+		t.FailNow()
+	}
+	assert.Equal(t, "upstream/base/service-web.yaml", output[0].GetAnnotations()[kioutil.PathAnnotation])
+	assert.Equal(t, "upstream/base/service-web.yaml", output[1].GetAnnotations()[kioutil.PathAnnotation])
+
+	fn.Pattern = "%s-%s.yaml"
+	output, err = fn.Filter(input)
+	if !assert.NoError(t, err, "explicit default pattern") {
+		t.FailNow()
+	}
+	assert.Equal(t, "upstream/service-web.yaml", output[0].GetAnnotations()[kioutil.PathAnnotation])
+	assert.Equal(t, "upstream/service-web.yaml", output[1].GetAnnotations()[kioutil.PathAnnotation])
+
+	fn.Pattern = "{{.Kind | lower}}-{{.Name}}.yaml"
+	_, err = fn.Filter(input)
+	if assert.NotNil(t, err, "collision") {
+		assert.Equal(t, "Service a/web and Service b/web are both written to upstream/service-web.yaml, change the pattern so every resource gets its own file", err.Error())
+	}
+
+	fn.Pattern = "{{.Namespace}}/{{.Kind | lower}}-{{.Name}}.yaml"
+	output, err = fn.Filter(input)
+	if !assert.NoError(t, err, "namespaced pattern") {
+		t.FailNow()
+	}
+	assert.Equal(t, "upstream/a/service-web.yaml", output[0].GetAnnotations()[kioutil.PathAnnotation])
+	assert.Equal(t, "upstream/b/service-web.yaml", output[1].GetAnnotations()[kioutil.PathAnnotation])
+}
+
+func TestKonvertFilterPathCollisions(t *testing.T) {
+	konvertFile, err := yaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: web
+  annotations:
+    internal.config.kubernetes.io/path: service-web-local-chart.yaml
+spec:
+  chart: ./local-chart
+`)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	fn := Konvert("./examples/konvert.yaml")
+	fn.ResourceMeta.Name = "web"
+	fn.Chart = "./local-chart"
+	_, err = fn.Filter([]*yaml.RNode{konvertFile})
+	if assert.NotNil(t, err, "package file") {
+		assert.Contains(t, err.Error(), "Konvert web and Service web-local-chart are both written to service-web-local-chart.yaml", "package file")
+	}
+
+	// charts of spec.charts rendered to the same path must not overwrite
+	// each other
+	fn = Konvert("./examples/konvert.yaml")
+	fn.ResourceMeta.Name = "web"
+	fn.Pattern = "{{.Source | base}}"
+	fn.Charts = []KonvertChart{
+		{Chart: "./local-chart", ReleaseName: "web"},
+		{Chart: "../examples/local-chart", ReleaseName: "api"},
+	}
+	_, err = fn.Filter([]*yaml.RNode{})
+	if assert.NotNil(t, err, "sibling charts") {
+		assert.Contains(t, err.Error(), "spec.charts[1]: ", "sibling charts")
+		assert.Contains(t, err.Error(), "are both written to", "sibling charts")
+	}
+
+	fn.Pattern = ""
+	_, err = fn.Filter([]*yaml.RNode{})
+	assert.NoError(t, err, "release names set the resource names apart")
+}
@@ -0,0 +1,370 @@
+package functions
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/copystructure"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ValuesViolation is a value that does not match the chart's
+// values.schema.json
+type ValuesViolation struct {
+	// Path is the path of the value, e.g. ingress.hosts[0].host, empty for
+	// the values themselves
+	Path    string
+	Message string
+	// Field is the field of the value in the function config, e.g.
+	// spec.values.replicaCount, empty when it is not set inline
+	Field string
+	// Line is the line of Field in File, 0 when unknown
+	Line int
+	// location is the path of the value as JSON pointer tokens
+	location []string
+}
+
+// ValuesSchemaError is returned when the values do not match the chart's
+// values.schema.json
+type ValuesSchemaError struct {
+	Chart      string
+	File       string
+	Violations []ValuesViolation
+	resource   *kyaml.ResourceIdentifier
+	index      int
+}
+
+func (e *ValuesSchemaError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "values do not match the values.schema.json of chart %q:", e.Chart)
+	for _, violation := range e.Violations {
+		sb.WriteString("\n  ")
+		if e.File != "" && violation.Line > 0 {
+			fmt.Fprintf(&sb, "%s:%d: ", e.File, violation.Line)
+		}
+		fmt.Fprintf(&sb, "%s: %s", violation.display(), violation.Message)
+	}
+	return sb.String()
+}
+
+// Results returns a result per violation, referencing the function config and
+// the field of the value
+func (e *ValuesSchemaError) Results() framework.Results {
+	var results framework.Results
+	for _, violation := range e.Violations {
+		result := &framework.Result{
+			Message:     fmt.Sprintf("%s: %s", violation.display(), violation.Message),
+			Severity:    framework.Error,
+			ResourceRef: e.resource,
+		}
+		if violation.Field != "" {
+			result.Field = &framework.Field{Path: violation.Field}
+		}
+		if e.File != "" {
+			result.File = &framework.File{Path: e.File, Index: e.index}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (v ValuesViolation) display() string {
+	if v.Path == "" {
+		return "values"
+	}
+	return "values." + v.Path
+}
+
+// valuesSource locates the inline values in the function config so schema
+// violations can point at them
+type valuesSource struct {
+	file  string
+	index int
+	// config is the function config and section the field holding its
+	// settings, spec or data
+	config   *kyaml.RNode
+	section  string
+	field    string
+	node     *kyaml.RNode
+	resource *kyaml.ResourceIdentifier
+	// lineOffset is the line the document starts after in file, -1 when
+	// unknown. kio numbers the lines of each document from 1.
+	lineOffset int
+}
+
+// newValuesSource locates the values of the function config rn, loaded from
+// file (the path annotation is used when file is empty)
+func newValuesSource(rn *kyaml.RNode, file string) *valuesSource {
+	source := &valuesSource{
+		file:     file,
+		config:   rn,
+		section:  "spec",
+		resource: resourceRef(rn),
+	}
+	if validGVK(rn, "v1", "ConfigMap") {
+		source.section = "data"
+	}
+	if path, index, err := kioutil.GetFileAnnotations(rn); err == nil {
+		if source.file == "" {
+			source.file = path
+		}
+		source.index, _ = strconv.Atoi(index)
+	}
+	source.field = source.section + ".values"
+	source.node, _ = rn.Pipe(kyaml.Lookup(source.section, "values"))
+
+	source.lineOffset = -1
+	if source.index == 0 {
+		source.lineOffset = 0
+	} else if data, err := os.ReadFile(source.file); err == nil {
+		source.lineOffset = documentLineOffset(string(data), source.index)
+	}
+	return source
+}
+
+// documentSeparator splits documents the way kio.ByteReader does
+var documentSeparator = regexp.MustCompile(`\n---.*\n`)
+
+// documentLineOffset returns the number of lines before the document at index
+// in data, skipping empty documents like kio.ByteReader does, or -1 if there
+// is no such document
+func documentLineOffset(data string, index int) int {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	separators := documentSeparator.FindAllStringIndex(data, -1)
+	start := 0
+	for i := 0; i <= len(separators); i++ {
+		end := len(data)
+		if i < len(separators) {
+			end = separators[i][0]
+		}
+		if hasYAMLContent(data[start:end]) {
+			if index == 0 {
+				return strings.Count(data[:start], "\n")
+			}
+			index--
+		}
+		if i < len(separators) {
+			start = separators[i][1]
+		}
+	}
+	return -1
+}
+
+func hasYAMLContent(document string) bool {
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "---") {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaError locates the violations and returns them as an error
+func (s *valuesSource) schemaError(chart string, violations []ValuesViolation) *ValuesSchemaError {
+	s.locate(violations)
+	err := &ValuesSchemaError{Chart: chart, Violations: violations}
+	if s != nil {
+		err.File = s.file
+		err.resource = s.resource
+		err.index = s.index
+	}
+	return err
+}
+
+// validateValues validates the values, coalesced with the chart defaults the
+// way Helm renders them, against the values.schema.json of the chart and its
+// enabled dependencies. The dependencies are processed on a copy of the
+// chart, Helm processes them again when rendering it.
+func validateValues(chart *helmchart.Chart, values map[string]interface{}) ([]ValuesViolation, error) {
+	chart, err := copyChart(chart)
+	if err != nil {
+		return nil, err
+	}
+	if err := chartutil.ProcessDependenciesWithMerge(chart, values); err != nil {
+		return nil, errors.Wrap(err, "unable to process chart dependencies")
+	}
+	coalesced, err := chartutil.CoalesceValues(chart, values)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to coalesce values")
+	}
+	violations, err := validateChartValues(chart, coalesced, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations, nil
+}
+
+// copyChart copies the chart and its dependencies, with the metadata and
+// values changed by chartutil.ProcessDependenciesWithMerge
+func copyChart(chart *helmchart.Chart) (*helmchart.Chart, error) {
+	out := *chart
+	if chart.Metadata != nil {
+		metadata := *chart.Metadata
+		metadata.Dependencies = nil
+		for _, dependency := range chart.Metadata.Dependencies {
+			if dependency != nil {
+				copied := *dependency
+				dependency = &copied
+			}
+			metadata.Dependencies = append(metadata.Dependencies, dependency)
+		}
+		out.Metadata = &metadata
+	}
+	values, err := copystructure.Copy(chart.Values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to copy the values of chart %q", chart.Name())
+	}
+	out.Values, _ = values.(map[string]interface{})
+
+	var dependencies []*helmchart.Chart
+	for _, dependency := range chart.Dependencies() {
+		copied, err := copyChart(dependency)
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, copied)
+	}
+	out.SetDependencies(dependencies...)
+	return &out, nil
+}
+
+func validateChartValues(chart *helmchart.Chart, values map[string]interface{}, prefix []string) ([]ValuesViolation, error) {
+	var violations []ValuesViolation
+	if len(chart.Schema) > 0 {
+		schema, err := compileValuesSchema(chart.Schema)
+		if err != nil {
+			// e.g. a $ref to a remote schema, Helm still validates the values
+			log.WithError(err).WithField("chart", chart.Name()).Debug("unable to compile values.schema.json")
+		} else if err := schema.Validate(values); err != nil {
+			var validationErr *jsonschema.ValidationError
+			if !errors.As(err, &validationErr) {
+				return nil, errors.Wrapf(err, "unable to validate the values of chart %q", chart.Name())
+			}
+			violations = append(violations, schemaViolations(validationErr, values, prefix)...)
+		}
+	}
+
+	for _, dependency := range chart.Dependencies() {
+		dependencyValues, ok := values[dependency.Name()].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		dependencyPrefix := append(append([]string{}, prefix...), dependency.Name())
+		dependencyViolations, err := validateChartValues(dependency, dependencyValues, dependencyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, dependencyViolations...)
+	}
+	return violations, nil
+}
+
+func compileValuesSchema(data []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("file:///values.schema.json", doc); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("file:///values.schema.json")
+}
+
+// schemaViolations flattens a validation error into its leaf errors
+func schemaViolations(err *jsonschema.ValidationError, values map[string]interface{}, prefix []string) []ValuesViolation {
+	if len(err.Causes) > 0 {
+		var violations []ValuesViolation
+		for _, cause := range err.Causes {
+			violations = append(violations, schemaViolations(cause, values, prefix)...)
+		}
+		return violations
+	}
+	printer := message.NewPrinter(language.English)
+	return []ValuesViolation{{
+		Path:     valuesPath(values, prefix, err.InstanceLocation),
+		Message:  err.ErrorKind.LocalizedString(printer),
+		location: append(append([]string{}, prefix...), err.InstanceLocation...),
+	}}
+}
+
+// valuesPath formats the location of a value, indexing lists with brackets
+func valuesPath(values map[string]interface{}, prefix, location []string) string {
+	path := strings.Join(prefix, ".")
+	var value interface{} = values
+	for _, token := range location {
+		switch v := value.(type) {
+		case []interface{}:
+			path += "[" + token + "]"
+			if i, err := strconv.Atoi(token); err == nil && i < len(v) {
+				value = v[i]
+			}
+			continue
+		case map[string]interface{}:
+			value = v[token]
+		}
+		path = joinKey(path, token)
+	}
+	return path
+}
+
+// locate sets the field and line of the violations whose value is set in the
+// inline values
+func (s *valuesSource) locate(violations []ValuesViolation) {
+	if s == nil || s.node == nil {
+		return
+	}
+	for i := range violations {
+		violation := &violations[i]
+		node, field := s.node, s.field
+		for _, token := range violation.location {
+			switch node.YNode().Kind {
+			case kyaml.MappingNode:
+				value := node.Field(token)
+				if value == nil {
+					node = nil
+					break
+				}
+				node, field = value.Value, field+"."+token
+			case kyaml.SequenceNode:
+				index, err := strconv.Atoi(token)
+				if err != nil || index >= len(node.Content()) {
+					node = nil
+					break
+				}
+				node, field = kyaml.NewRNode(node.Content()[index]), field+"["+token+"]"
+			default:
+				node = nil
+			}
+			if node == nil {
+				break
+			}
+		}
+		if node == nil {
+			// set by a values file, --set or the chart defaults
+			continue
+		}
+		violation.Field = field
+		if s.lineOffset >= 0 {
+			violation.Line = s.lineOffset + node.YNode().Line
+		}
+	}
+}
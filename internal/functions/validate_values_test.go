@@ -0,0 +1,300 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testValuesSchema = `{
+  "type": "object",
+  "required": ["service"],
+  "properties": {
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "service": {
+      "type": "object",
+      "required": ["port"],
+      "properties": {
+        "port": {"type": "integer"}
+      }
+    },
+    "ingress": {
+      "type": "object",
+      "properties": {
+        "hosts": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {"host": {"type": "string"}}
+          }
+        }
+      }
+    }
+  }
+}`
+
+// testSchemaChart saves the local-chart example with a values.schema.json and
+// returns its directory
+func testSchemaChart(t *testing.T) string {
+	t.Helper()
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Schema = []byte(testValuesSchema)
+	dir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(chart, dir), "SaveDir")
+	return filepath.Join(dir, chart.Name())
+}
+
+func TestValidateValues(t *testing.T) {
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Schema = []byte(testValuesSchema)
+
+	var tests = []struct {
+		name     string
+		values   map[string]interface{}
+		expected []ValuesViolation
+	}{
+		{
+			name:   "valid",
+			values: map[string]interface{}{"replicaCount": 3},
+		},
+		{
+			name:   "wrong-type",
+			values: map[string]interface{}{"replicaCount": "3"},
+			expected: []ValuesViolation{
+				{Path: "replicaCount", Message: "got string, want integer"},
+			},
+		},
+		{
+			name:   "minimum",
+			values: map[string]interface{}{"replicaCount": 0},
+			expected: []ValuesViolation{
+				{Path: "replicaCount", Message: "minimum: got 0, want 1"},
+			},
+		},
+		{
+			name: "list-item",
+			values: map[string]interface{}{
+				"ingress": map[string]interface{}{
+					"hosts": []interface{}{
+						map[string]interface{}{"host": "example.com"},
+						map[string]interface{}{"host": 42},
+					},
+				},
+			},
+			expected: []ValuesViolation{
+				{Path: "ingress.hosts[1].host", Message: "got number, want string"},
+			},
+		},
+		{
+			name: "removed-default",
+			values: map[string]interface{}{
+				"service": map[string]interface{}{"port": nil},
+			},
+			expected: []ValuesViolation{
+				{Path: "service", Message: "missing property 'port'"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations, err := validateValues(chart, test.values)
+			require.NoError(t, err, test.name)
+			for i := range violations {
+				violations[i].location = nil
+			}
+			assert.Equal(t, test.expected, violations, test.name)
+		})
+	}
+}
+
+func TestValidateValuesDependencies(t *testing.T) {
+	chart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "chart", Version: "0.1.0", APIVersion: "v2"},
+		Values:   map[string]interface{}{},
+	}
+	chart.AddDependency(&helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "redis", Version: "1.0.0", APIVersion: "v2"},
+		Values:   map[string]interface{}{"port": 6379},
+		Schema:   []byte(`{"properties": {"port": {"type": "integer"}}}`),
+	})
+
+	violations, err := validateValues(chart, map[string]interface{}{
+		"redis": map[string]interface{}{"port": "6379"},
+	})
+	require.NoError(t, err, "validateValues")
+	require.Len(t, violations, 1, "violations")
+	assert.Equal(t, "redis.port", violations[0].Path, "path")
+	assert.Equal(t, []string{"redis", "port"}, violations[0].location, "location")
+}
+
+func TestValidateValuesDisabledDependency(t *testing.T) {
+	chart := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			Name:       "chart",
+			Version:    "0.1.0",
+			APIVersion: "v2",
+			Dependencies: []*helmchart.Dependency{
+				{Name: "redis", Version: "1.0.0", Condition: "redis.enabled"},
+			},
+		},
+		Values: map[string]interface{}{},
+	}
+	chart.AddDependency(&helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "redis", Version: "1.0.0", APIVersion: "v2"},
+		Values:   map[string]interface{}{"port": 6379},
+		Schema:   []byte(`{"properties": {"port": {"type": "integer"}}}`),
+	})
+
+	violations, err := validateValues(chart, map[string]interface{}{
+		"redis": map[string]interface{}{"enabled": false, "port": "6379"},
+	})
+	require.NoError(t, err, "validateValues")
+	assert.Empty(t, violations, "disabled dependencies are not validated")
+	require.Len(t, chart.Dependencies(), 1, "the chart is not changed")
+	require.Len(t, chart.Metadata.Dependencies, 1, "the chart is not changed")
+	assert.False(t, chart.Metadata.Dependencies[0].Enabled, "the chart is not changed")
+
+	violations, err = validateValues(chart, map[string]interface{}{
+		"redis": map[string]interface{}{"enabled": true, "port": "6379"},
+	})
+	require.NoError(t, err, "validateValues")
+	require.Len(t, violations, 1, "the dependency is enabled again")
+	assert.Equal(t, "redis.port", violations[0].Path, "path")
+}
+
+func TestRenderHelmChartFilterValuesSchema(t *testing.T) {
+	chartDir := testSchemaChart(t)
+	config := fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: RenderHelmChart
+metadata:
+  name: local-chart
+  annotations:
+    config.kubernetes.io/path: apps/konvert.yaml
+spec:
+  chart: %s
+  values:
+    replicaCount: "3"
+    ingress:
+      hosts:
+      - host: 42
+`, chartDir)
+
+	fnconfig, err := kyaml.Parse(config)
+	require.NoError(t, err, "Parse")
+	var fn RenderHelmChartFunction
+	require.NoError(t, fn.Config(fnconfig), "Config")
+	fn.Set = map[string]interface{}{"service.port": "http"}
+
+	_, err = fn.Filter([]*kyaml.RNode{})
+	require.Error(t, err, "Filter")
+	assert.Equal(t, `values do not match the values.schema.json of chart "`+chartDir+`":
+  apps/konvert.yaml:13: values.ingress.hosts[0].host: got number, want string
+  apps/konvert.yaml:10: values.replicaCount: got string, want integer
+  values.service.port: got string, want integer`, err.Error(), "error")
+
+	resourceList := &framework.ResourceList{FunctionConfig: fnconfig}
+	err = runFn(&RenderHelmChartFunction{}, resourceList)
+	require.Error(t, err, "runFn")
+	require.Len(t, resourceList.Results, 2, "results")
+	result := resourceList.Results[1]
+	assert.Equal(t, "values.replicaCount: got string, want integer", result.Message, "message")
+	assert.Equal(t, framework.Error, result.Severity, "severity")
+	assert.Equal(t, "spec.values.replicaCount", result.Field.Path, "field")
+	assert.Equal(t, "apps/konvert.yaml", result.File.Path, "file")
+	assert.Equal(t, "local-chart", result.ResourceRef.Name, "resource")
+}
+
+func TestKonvertFilterValuesSchemaFile(t *testing.T) {
+	chartDir := testSchemaChart(t)
+	config := fmt.Sprintf(`# a comment before the resource
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: local-chart
+spec:
+  chart: %s
+  values:
+    service:
+      port: "80"
+`, chartDir)
+
+	fnconfig, err := kyaml.Parse(config)
+	require.NoError(t, err, "Parse")
+	fn := Konvert(filepath.Join(t.TempDir(), "konvert.yaml"))
+	require.NoError(t, fn.Config(fnconfig), "Config")
+
+	_, err = fn.Filter([]*kyaml.RNode{})
+	require.Error(t, err, "Filter")
+	var schemaErr *ValuesSchemaError
+	require.ErrorAs(t, err, &schemaErr, "ValuesSchemaError")
+	require.Len(t, schemaErr.Violations, 1, "violations")
+	assert.Equal(t, fn.FilePath(), schemaErr.File, "file")
+	assert.Equal(t, "spec.values.service.port", schemaErr.Violations[0].Field, "field")
+	assert.Equal(t, 10, schemaErr.Violations[0].Line, "line")
+}
+
+func TestDocumentLineOffset(t *testing.T) {
+	data := `# header
+apiVersion: v1
+kind: ConfigMap
+---
+---
+# only a comment
+--- # separator comment
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+---
+kind: Other
+`
+	var tests = []struct {
+		index    int
+		expected int
+	}{
+		{index: 0, expected: 0},
+		{index: 1, expected: 7},
+		{index: 2, expected: 10},
+		{index: 3, expected: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.index), func(t *testing.T) {
+			assert.Equal(t, test.expected, documentLineOffset(data, test.index))
+		})
+	}
+}
+
+func TestValuesSourceForChart(t *testing.T) {
+	fnconfig, err := kyaml.Parse(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: stack
+spec:
+  charts:
+  - chart: web
+  - chart: api
+    values:
+      replicaCount: "3"
+`)
+	require.NoError(t, err, "Parse")
+	source := newValuesSource(fnconfig, "konvert.yaml").forChart(1)
+
+	violations := []ValuesViolation{{Path: "replicaCount", location: []string{"replicaCount"}}}
+	source.locate(violations)
+	assert.Equal(t, "spec.charts[1].values.replicaCount", violations[0].Field, "field")
+	assert.Equal(t, 10, violations[0].Line, "line")
+
+	violations = []ValuesViolation{{Path: "replicaCount", location: []string{"replicaCount"}}}
+	newValuesSource(fnconfig, "konvert.yaml").forChart(0).locate(violations)
+	assert.Empty(t, violations[0].Field, "no inline values")
+}
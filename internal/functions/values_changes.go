@@ -0,0 +1,320 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// RenamedValue is a values key that moved between two chart versions
+type RenamedValue struct {
+	From string
+	To   string
+}
+
+// ValuesChanges are the differences between the values (values.yaml and
+// values.schema.json) of two versions of a chart. Keys are dotted paths, e.g.
+// image.tag.
+type ValuesChanges struct {
+	From    string
+	To      string
+	Added   []string
+	Removed []string
+	Renamed []RenamedValue
+	// Unused are the configured values that do not exist in the new chart
+	// version and are silently ignored
+	Unused []string
+}
+
+// Empty returns true if the values did not change
+func (c *ValuesChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Renamed) == 0 && len(c.Unused) == 0
+}
+
+// valuesChangesSinceLock compares the values of the chart just rendered with
+// the version recorded in the lock file, or returns nil when the version did
+// not change
+func (f *KonvertFunction) valuesChangesSinceLock(nodes []*kyaml.RNode, renderHelmChart *RenderHelmChartFunction) (*ValuesChanges, error) {
+	resolved := renderHelmChart.resolved
+	if resolved == nil || resolved.chart == nil {
+		return nil, nil
+	}
+	_, locked, err := findKonvertLock(nodes, f.lockPath, f.ReleaseName())
+	if err != nil || locked == nil {
+		return nil, err
+	}
+	if locked.Repo != f.Repo || locked.Chart != f.Chart || locked.Version == resolved.version {
+		return nil, nil
+	}
+
+	previous := *renderHelmChart
+	previous.Version = locked.Version
+	// the previous version was verified when it was rendered
+	previous.Verify = false
+	previousChart, err := previous.loadChart()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load chart %q version %q", f.Chart, locked.Version)
+	}
+
+	values, err := renderHelmChart.mergeValues()
+	if err != nil {
+		return nil, err
+	}
+	return compareChartValues(previousChart, resolved.chart, values)
+}
+
+// valuesChangesResults reports the values changes of a chart version bump,
+// removed, renamed and ignored values are warnings
+func (f *KonvertFunction) valuesChangesResults(changes *ValuesChanges) framework.Results {
+	fnlog := log.WithFields(log.Fields{
+		"fn":    f.Name(),
+		"path":  f.filePath,
+		"chart": f.Chart,
+		"from":  changes.From,
+		"to":    changes.To,
+	})
+	var results framework.Results
+	report := func(severity framework.Severity, field, message string) {
+		if severity == framework.Warning {
+			fnlog.Warn(message)
+		} else {
+			fnlog.Info(message)
+		}
+		results = append(results, &framework.Result{
+			Message:  fmt.Sprintf("chart %s %s -> %s: %s", f.Chart, changes.From, changes.To, message),
+			Severity: severity,
+			Field:    &framework.Field{Path: field},
+		})
+	}
+
+	if len(changes.Added) > 0 {
+		report(framework.Info, "spec.version", fmt.Sprintf("values added: %s", strings.Join(changes.Added, ", ")))
+	}
+	if len(changes.Removed) > 0 {
+		report(framework.Warning, "spec.version", fmt.Sprintf("values removed: %s", strings.Join(changes.Removed, ", ")))
+	}
+	if len(changes.Renamed) > 0 {
+		var renamed []string
+		for _, r := range changes.Renamed {
+			renamed = append(renamed, fmt.Sprintf("%s -> %s", r.From, r.To))
+		}
+		report(framework.Warning, "spec.version", fmt.Sprintf("values renamed: %s", strings.Join(renamed, ", ")))
+	}
+	if len(changes.Unused) > 0 {
+		report(framework.Warning, "spec.values", fmt.Sprintf("configured values no longer exist in the chart and are ignored: %s", strings.Join(changes.Unused, ", ")))
+	}
+	return results
+}
+
+// compareChartValues compares the values keys of two chart versions and
+// finds the configured values that no longer exist
+func compareChartValues(from, to *helmchart.Chart, values map[string]interface{}) (*ValuesChanges, error) {
+	fromKeys, err := chartValuesKeys(from)
+	if err != nil {
+		return nil, err
+	}
+	toKeys, err := chartValuesKeys(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &ValuesChanges{
+		From: from.Metadata.Version,
+		To:   to.Metadata.Version,
+	}
+	for key := range toKeys {
+		if _, ok := fromKeys[key]; !ok {
+			changes.Added = append(changes.Added, key)
+		}
+	}
+	for key := range fromKeys {
+		if _, ok := toKeys[key]; !ok {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	findRenamedValues(changes, fromKeys, toKeys)
+
+	configured := make(map[string]interface{})
+	flattenValues("", values, configured)
+	for key := range configured {
+		if key == "global" || strings.HasPrefix(key, "global.") {
+			continue
+		}
+		if !hasValuesKey(toKeys, key) {
+			changes.Unused = append(changes.Unused, key)
+		}
+	}
+	sort.Strings(changes.Unused)
+	return changes, nil
+}
+
+// findRenamedValues pairs removed and added keys that are most likely the same
+// value under a new name: the same key under a different parent, or a key of
+// the same parent with the same default. Renamed keys are no longer reported
+// as added or removed.
+func findRenamedValues(changes *ValuesChanges, fromKeys, toKeys map[string]interface{}) {
+	added := make(map[string]bool)
+	for _, key := range changes.Added {
+		added[key] = true
+	}
+
+	var removed []string
+	for _, from := range changes.Removed {
+		var candidates []string
+		if countLastKey(changes.Removed, lastKey(from)) == 1 {
+			for to := range added {
+				if lastKey(to) == lastKey(from) {
+					candidates = append(candidates, to)
+				}
+			}
+		}
+		if len(candidates) != 1 {
+			candidates = nil
+			for to := range added {
+				if parentKey(to) == parentKey(from) && isSignificantValue(fromKeys[from]) &&
+					reflect.DeepEqual(fromKeys[from], toKeys[to]) {
+					candidates = append(candidates, to)
+				}
+			}
+		}
+		if len(candidates) != 1 {
+			removed = append(removed, from)
+			continue
+		}
+		changes.Renamed = append(changes.Renamed, RenamedValue{From: from, To: candidates[0]})
+		delete(added, candidates[0])
+	}
+
+	changes.Removed = removed
+	changes.Added = nil
+	for key := range added {
+		changes.Added = append(changes.Added, key)
+	}
+	sort.Strings(changes.Added)
+}
+
+// chartValuesKeys returns the values keys of the chart and its dependencies,
+// from values.yaml and values.schema.json, with their default value
+func chartValuesKeys(chart *helmchart.Chart) (map[string]interface{}, error) {
+	keys := make(map[string]interface{})
+	flattenValues("", chart.Values, keys)
+	if len(chart.Schema) > 0 {
+		var schema map[string]interface{}
+		if err := json.Unmarshal(chart.Schema, &schema); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse values.schema.json of chart %q", chart.Name())
+		}
+		flattenSchema("", schema, keys)
+	}
+
+	for _, dependency := range chart.Dependencies() {
+		dependencyKeys, err := chartValuesKeys(dependency)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range dependencyKeys {
+			keys[dependency.Name()+"."+key] = value
+		}
+	}
+	return keys, nil
+}
+
+// flattenValues adds the leaves of values to keys, maps are only leaves when
+// they are empty
+func flattenValues(prefix string, values map[string]interface{}, keys map[string]interface{}) {
+	for key, value := range values {
+		path := joinKey(prefix, key)
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenValues(path, nested, keys)
+			continue
+		}
+		keys[path] = value
+	}
+}
+
+// flattenSchema adds the leaf properties of a JSON schema to keys
+func flattenSchema(prefix string, schema map[string]interface{}, keys map[string]interface{}) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, property := range properties {
+		path := joinKey(prefix, key)
+		nested, ok := property.(map[string]interface{})
+		if _, hasProperties := nested["properties"]; ok && hasProperties {
+			flattenSchema(path, nested, keys)
+			continue
+		}
+		if _, ok := keys[path]; !ok {
+			keys[path] = nil
+		}
+	}
+}
+
+// hasValuesKey returns true if key, a parent or a child of key is a chart
+// values key. Values below a leaf key of the chart (e.g. an entry of
+// podAnnotations: {}) are part of that key.
+func hasValuesKey(keys map[string]interface{}, key string) bool {
+	if _, ok := keys[key]; ok {
+		return true
+	}
+	for chartKey := range keys {
+		if strings.HasPrefix(key, chartKey+".") || strings.HasPrefix(chartKey, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func lastKey(key string) string {
+	return key[strings.LastIndex(key, ".")+1:]
+}
+
+func parentKey(key string) string {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+func countLastKey(keys []string, last string) int {
+	count := 0
+	for _, key := range keys {
+		if lastKey(key) == last {
+			count++
+		}
+	}
+	return count
+}
+
+// isSignificantValue returns false for defaults that too many keys share to
+// identify a renamed key (empty strings, maps and lists, booleans, nil)
+func isSignificantValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, bool:
+		return false
+	case string:
+		return v != ""
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
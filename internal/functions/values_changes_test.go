@@ -0,0 +1,230 @@
+package functions
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestCompareChartValues(t *testing.T) {
+	newChart := func(version string, values map[string]interface{}, schema string) *helmchart.Chart {
+		return &helmchart.Chart{
+			Metadata: &helmchart.Metadata{Name: "chart", Version: version},
+			Values:   values,
+			Schema:   []byte(schema),
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		from     *helmchart.Chart
+		to       *helmchart.Chart
+		values   map[string]interface{}
+		expected ValuesChanges
+	}{
+		{
+			name: "unchanged",
+			from: newChart("0.1.0", map[string]interface{}{"replicaCount": 1}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"replicaCount": 1}, ""),
+		},
+		{
+			name: "added-removed",
+			from: newChart("0.1.0", map[string]interface{}{"replicaCount": 1, "debug": false}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"replicaCount": 1, "service": map[string]interface{}{"port": 80}}, ""),
+			expected: ValuesChanges{
+				Added:   []string{"service.port"},
+				Removed: []string{"debug"},
+			},
+		},
+		{
+			name: "renamed-parent",
+			from: newChart("0.1.0", map[string]interface{}{"image": map[string]interface{}{"tag": ""}}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"controller": map[string]interface{}{"image": map[string]interface{}{"tag": ""}}}, ""),
+			expected: ValuesChanges{
+				Renamed: []RenamedValue{{From: "image.tag", To: "controller.image.tag"}},
+			},
+		},
+		{
+			name: "renamed-same-default",
+			from: newChart("0.1.0", map[string]interface{}{"service": map[string]interface{}{"port": 80}}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"service": map[string]interface{}{"httpPort": 80}}, ""),
+			expected: ValuesChanges{
+				Renamed: []RenamedValue{{From: "service.port", To: "service.httpPort"}},
+			},
+		},
+		{
+			name: "not-renamed-common-default",
+			from: newChart("0.1.0", map[string]interface{}{"rbac": map[string]interface{}{"create": true}}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"rbac": map[string]interface{}{"enabled": true}}, ""),
+			expected: ValuesChanges{
+				Added:   []string{"rbac.enabled"},
+				Removed: []string{"rbac.create"},
+			},
+		},
+		{
+			name: "unused",
+			from: newChart("0.1.0", map[string]interface{}{"debug": false, "podAnnotations": map[string]interface{}{}}, ""),
+			to:   newChart("0.2.0", map[string]interface{}{"podAnnotations": map[string]interface{}{}}, ""),
+			values: map[string]interface{}{
+				"debug":          true,
+				"podAnnotations": map[string]interface{}{"team": "a"},
+				"global":         map[string]interface{}{"imageRegistry": "registry.example.com"},
+			},
+			expected: ValuesChanges{
+				Removed: []string{"debug"},
+				Unused:  []string{"debug"},
+			},
+		},
+		{
+			name: "schema-only",
+			from: newChart("0.1.0", map[string]interface{}{}, ""),
+			to: newChart("0.2.0", map[string]interface{}{},
+				`{"properties": {"extraArgs": {"type": "object", "properties": {"verbose": {"type": "boolean"}}}}}`),
+			values: map[string]interface{}{"extraArgs": map[string]interface{}{"verbose": true}},
+			expected: ValuesChanges{
+				Added: []string{"extraArgs.verbose"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := compareChartValues(test.from, test.to, test.values)
+			require.NoError(t, err, test.name)
+			test.expected.From = test.from.Metadata.Version
+			test.expected.To = test.to.Metadata.Version
+			assert.Equal(t, test.expected, *changes, test.name)
+		})
+	}
+}
+
+func TestCompareChartValuesDependencies(t *testing.T) {
+	from := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "chart", Version: "0.1.0"},
+		Values:   map[string]interface{}{},
+	}
+	to := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "chart", Version: "0.2.0"},
+		Values:   map[string]interface{}{},
+	}
+	to.AddDependency(&helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "redis", Version: "1.0.0"},
+		Values:   map[string]interface{}{"auth": map[string]interface{}{"enabled": true}},
+	})
+
+	changes, err := compareChartValues(from, to, map[string]interface{}{
+		"redis": map[string]interface{}{"auth": map[string]interface{}{"enabled": false}},
+	})
+	require.NoError(t, err, "compareChartValues")
+	assert.Equal(t, []string{"redis.auth.enabled"}, changes.Added, "added")
+	assert.Empty(t, changes.Unused, "unused")
+}
+
+func TestKonvertFilterValuesChanges(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+	chart, err := loader.Load("./examples/local-chart")
+	require.NoError(t, err, "Load")
+	chart.Metadata.Version = "0.2.0"
+	chart.Values["extraEnv"] = []interface{}{}
+	delete(chart.Values, "nodeSelector")
+	// archives are saved with the raw values.yaml
+	raw, err := kyaml.Marshal(chart.Values)
+	require.NoError(t, err, "Marshal")
+	for _, file := range chart.Raw {
+		if file.Name == "values.yaml" {
+			file.Data = raw
+		}
+	}
+	chartRepo.addChart(t, chart)
+
+	newFn := func(version string) *KonvertFunction {
+		var fn KonvertFunction
+		fn.ResourceMeta.Name = "local-chart"
+		fn.Repo = chartRepo.URL
+		fn.Chart = "local-chart"
+		fn.Version = version
+		fn.Values = map[string]interface{}{
+			"nodeSelector": map[string]interface{}{"disk": "ssd"},
+		}
+		fn.filePath = filepath.Join(t.TempDir(), "konvert.yaml")
+		fn.lockPath = "konvert.lock.yaml"
+		return &fn
+	}
+
+	hook := logtest.NewGlobal()
+	t.Cleanup(hook.Reset)
+
+	nodes, err := newFn("0.1.0").Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	assert.Empty(t, hook.AllEntries(), "first render")
+
+	upgrade := newFn("0.2.0")
+	_, err = upgrade.Filter(nodes)
+	require.NoError(t, err, "Filter")
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level <= log.WarnLevel || strings.HasPrefix(entry.Message, "values added") {
+			messages = append(messages, entry.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		"values added: extraEnv",
+		"values removed: nodeSelector",
+		"configured values no longer exist in the chart and are ignored: nodeSelector.disk",
+	}, messages, "messages")
+
+	var results []string
+	for _, result := range upgrade.Results() {
+		if !strings.HasPrefix(result.Message, "chart local-chart") {
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s %s: %s", result.Severity, result.Field.Path, result.Message))
+	}
+	assert.Equal(t, []string{
+		"info spec.version: chart local-chart 0.1.0 -> 0.2.0: values added: extraEnv",
+		"warning spec.version: chart local-chart 0.1.0 -> 0.2.0: values removed: nodeSelector",
+		"warning spec.values: chart local-chart 0.1.0 -> 0.2.0: configured values no longer exist in the chart and are ignored: nodeSelector.disk",
+	}, results, "results")
+
+	// a locked version that cannot be loaded is reported
+	index, locked, err := findKonvertLock(nodes, "konvert.lock.yaml", "local-chart")
+	require.NoError(t, err, "findKonvertLock")
+	require.GreaterOrEqual(t, index, 0, "lock")
+	locked.Version = "0.0.9"
+	nodes[index], err = locked.node("local-chart", "konvert.lock.yaml")
+	require.NoError(t, err, "node")
+	upgrade = newFn("0.2.0")
+	_, err = upgrade.Filter(nodes)
+	require.NoError(t, err, "Filter")
+	var warnings []string
+	for _, result := range upgrade.Results() {
+		if strings.Contains(result.Message, "unable to compare values") {
+			warnings = append(warnings, fmt.Sprintf("%s %s", result.Severity, result.Field.Path))
+		}
+	}
+	assert.Equal(t, []string{"warning spec.version"}, warnings, "comparison failed")
+}
+
+func TestValuesChangesResults(t *testing.T) {
+	var fn KonvertFunction
+	fn.Chart = "mysql"
+	results := fn.valuesChangesResults(&ValuesChanges{
+		From:    "8.6.2",
+		To:      "9.0.0",
+		Renamed: []RenamedValue{{From: "root.password", To: "auth.rootPassword"}},
+	})
+	require.Len(t, results, 1, "results")
+	assert.Equal(t, framework.Warning, results[0].Severity, "severity")
+	assert.Equal(t, "chart mysql 8.6.2 -> 9.0.0: values renamed: root.password -> auth.rootPassword", results[0].Message, "message")
+}
@@ -0,0 +1,87 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// VendorChart copies the chart archive into the vendor directory as
+// <name>-<version>.tgz, along with its provenance file when the chart is
+// verified, where rendering picks it up from now on. It returns the
+// path of the vendored archive and whether it was added. Local charts are
+// already part of the repository and return an empty path.
+func (f *RenderHelmChartFunction) VendorChart() (string, bool, error) {
+	fnlog := log.WithField("fn", f.Name())
+
+	if f.Chart == "" {
+		return "", false, fmt.Errorf("chart cannot be empty")
+	}
+	if f.Repo == "" && resolveLocalChartDirectory(f.Chart, f.BaseDirectory) != "" {
+		fnlog.WithField("chart", f.Chart).Debug("not vendoring local chart")
+		return "", false, nil
+	}
+	if archive := f.vendoredChart(f.Version); archive != "" {
+		return archive, false, nil
+	}
+
+	// without an index, a vendored archive can only be found again with an
+	// exact version
+	if !isExactVersion(f.Version) && (f.Repo == "" || registry.IsOCI(f.Repo)) {
+		return "", false, fmt.Errorf("chart %q needs an exact version to be vendored", f.Chart)
+	}
+	if vendoredChartName(f.Chart, "0.0.0") == "" {
+		return "", false, fmt.Errorf("chart %q is an archive URL and cannot be vendored", f.Chart)
+	}
+
+	tmpdir, err := os.MkdirTemp("", "konvert-helm-")
+	if err != nil {
+		return "", false, errors.Wrap(err, "unable to create temp directory for helm config and cache")
+	}
+	defer cleanupTmpDir(tmpdir, fnlog)
+
+	settings := newHelmSettings(tmpdir)
+	fetched, err := f.fetchChart(settings, tmpdir)
+	if err != nil {
+		return "", false, err
+	}
+	archive := fetched.archive
+
+	chart, err := loader.Load(archive)
+	if err != nil {
+		return "", false, errors.Wrap(err, "unable to load chart")
+	}
+
+	vendored := filepath.Join(f.vendorDirectory(), vendoredChartName(f.Chart, chart.Metadata.Version))
+	if _, err := os.Stat(vendored); err == nil {
+		return vendored, false, nil
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return "", false, errors.Wrap(err, "unable to read chart archive")
+	}
+	if err := os.MkdirAll(filepath.Dir(vendored), 0755); err != nil {
+		return "", false, errors.Wrap(err, "unable to create vendor directory")
+	}
+	if err := os.WriteFile(vendored, data, 0644); err != nil {
+		return "", false, errors.Wrap(err, "unable to write vendored chart")
+	}
+	// keep the provenance so vendored charts can still be verified
+	if fetched.provenance != "" {
+		data, err := os.ReadFile(fetched.provenance)
+		if err != nil {
+			return "", false, errors.Wrap(err, "unable to read chart provenance")
+		}
+		if err := os.WriteFile(vendored+provenanceExt, data, 0644); err != nil {
+			return "", false, errors.Wrap(err, "unable to write vendored chart provenance")
+		}
+	}
+	fnlog.WithField("archive", vendored).Debug("vendored chart")
+	return vendored, true, nil
+}
@@ -0,0 +1,122 @@
+package functions
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestRenderHelmChartVendorChart(t *testing.T) {
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0", "0.1.1")
+
+	var tests = []struct {
+		name            string
+		repo            string
+		chart           string
+		version         string
+		vendorDir       string
+		expectedArchive string
+		expectedError   string
+	}{
+		{
+			name:            "exact-version",
+			repo:            chartRepo.URL,
+			chart:           "local-chart",
+			version:         "0.1.0",
+			expectedArchive: "charts/local-chart-0.1.0.tgz",
+		},
+		{
+			name:            "version-range",
+			repo:            chartRepo.URL,
+			chart:           "local-chart",
+			version:         "~0.1.0",
+			expectedArchive: "charts/local-chart-0.1.1.tgz",
+		},
+		{
+			name:            "vendor-dir",
+			repo:            chartRepo.URL,
+			chart:           "local-chart",
+			version:         "0.1.0",
+			vendorDir:       "vendor/charts",
+			expectedArchive: "vendor/charts/local-chart-0.1.0.tgz",
+		},
+		{
+			name:  "local-chart",
+			chart: "./examples/local-chart",
+		},
+		{
+			name:          "oci-version-range",
+			repo:          "oci://registry.example.com/charts",
+			chart:         "local-chart",
+			version:       "~0.1.0",
+			expectedError: "needs an exact version to be vendored",
+		},
+		{
+			name:          "archive-url",
+			chart:         chartRepo.URL + "/local-chart-0.1.0.tgz",
+			version:       "0.1.0",
+			expectedError: "cannot be vendored",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testChartCache(t)
+			baseDir := t.TempDir()
+			if test.repo == "" && test.expectedError == "" {
+				baseDir = "."
+			}
+
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = test.repo
+			fn.Chart = test.chart
+			fn.Version = test.version
+			fn.VendorDir = test.vendorDir
+			fn.BaseDirectory = baseDir
+
+			archive, added, err := fn.VendorChart()
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			if test.expectedArchive == "" {
+				assert.Empty(t, archive, "local charts are not vendored")
+				return
+			}
+			assert.Equal(t, filepath.Join(baseDir, test.expectedArchive), archive, test.name)
+			assert.True(t, added, "added")
+			assert.FileExists(t, archive, test.name)
+
+			_, added, err = fn.VendorChart()
+			require.NoError(t, err, test.name)
+			assert.False(t, added, "already vendored")
+		})
+	}
+}
+
+func TestRenderHelmChartFilterPrefersVendoredChart(t *testing.T) {
+	testChartCache(t)
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+
+	var fn RenderHelmChartFunction
+	fn.ReleaseName = "local-chart"
+	fn.Repo = chartRepo.URL
+	fn.Chart = "local-chart"
+	fn.Version = "0.1.0"
+	fn.BaseDirectory = t.TempDir()
+	_, _, err := fn.VendorChart()
+	require.NoError(t, err, "VendorChart")
+
+	// neither the repository nor the cache are needed anymore
+	chartRepo.Close()
+	testChartCache(t)
+	output, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	assert.NotEmpty(t, output, "Filter")
+}
@@ -0,0 +1,88 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// chartSigner identifies the key a chart's provenance was signed with
+type chartSigner struct {
+	identity    string
+	fingerprint string
+}
+
+// verifyChart checks the provenance of the fetched chart against the keyring
+// and returns the signer. Helm signs charts as <name>-<version>.tgz, so the
+// archive is verified under that name whatever it is called on disk (cached
+// archives are named by their digest).
+func (f *RenderHelmChartFunction) verifyChart(fetched *fetchedChart, metadata *chart.Metadata, tmpdir string) (*chartSigner, error) {
+	ref := fmt.Sprintf("chart %q version %q", f.Chart, metadata.Version)
+	if fetched == nil || fetched.provenance == "" {
+		return nil, fmt.Errorf("%s cannot be verified: no provenance file was found", ref)
+	}
+
+	keyring, err := f.keyringPath()
+	if err != nil {
+		return nil, err
+	}
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load keyring %s", keyring)
+	}
+
+	data, err := os.ReadFile(fetched.archive)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read chart archive")
+	}
+	archive := filepath.Join(tmpdir, fmt.Sprintf("%s-%s.tgz", metadata.Name, metadata.Version))
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		return nil, errors.Wrap(err, "unable to copy chart archive")
+	}
+
+	verification, err := signatory.Verify(archive, fetched.provenance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed provenance verification", ref)
+	}
+
+	var identities []string
+	for name := range verification.SignedBy.Identities {
+		identities = append(identities, name)
+	}
+	sort.Strings(identities)
+	signer := &chartSigner{
+		identity:    strings.Join(identities, ", "),
+		fingerprint: fmt.Sprintf("%X", verification.SignedBy.PrimaryKey.Fingerprint),
+	}
+
+	log.WithFields(log.Fields{
+		"fn":          f.Name(),
+		"chart":       f.Chart,
+		"signed-by":   signer.identity,
+		"fingerprint": signer.fingerprint,
+	}).Debug("verified chart provenance")
+	return signer, nil
+}
+
+// keyringPath returns the keyring resolved against the base directory,
+// defaulting to the GnuPG public keyring like Helm
+func (f *RenderHelmChartFunction) keyringPath() (string, error) {
+	if f.Keyring != "" {
+		return resolvePath(f.Keyring, f.BaseDirectory), nil
+	}
+	if gnupgHome, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(gnupgHome, "pubring.gpg"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine the default keyring")
+	}
+	return filepath.Join(home, ".gnupg", "pubring.gpg"), nil
+}
@@ -0,0 +1,172 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // the keys Helm's provenance package works with
+	"helm.sh/helm/v3/pkg/provenance"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// testSigningKey generates a key and writes its public keyring to dir
+func testSigningKey(t *testing.T, dir, name string) (*openpgp.Entity, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	require.NoError(t, err, "NewEntity")
+
+	keyring := filepath.Join(dir, name+".gpg")
+	f, err := os.Create(keyring)
+	require.NoError(t, err, "Create")
+	defer f.Close()
+	require.NoError(t, entity.Serialize(f), "Serialize")
+	return entity, keyring
+}
+
+// sign writes the provenance file of version signed with entity
+func (r *testChartRepo) sign(t *testing.T, entity *openpgp.Entity, version string) {
+	t.Helper()
+	archive := filepath.Join(r.dir, fmt.Sprintf("local-chart-%s.tgz", version))
+	signatory := &provenance.Signatory{Entity: entity, KeyRing: openpgp.EntityList{entity}}
+	sig, err := signatory.ClearSign(archive)
+	require.NoError(t, err, "ClearSign")
+	require.NoError(t, os.WriteFile(archive+provenanceExt, []byte(sig), 0644), "WriteFile")
+}
+
+func TestRenderHelmChartFilterVerify(t *testing.T) {
+	keys := t.TempDir()
+	trusted, keyring := testSigningKey(t, keys, "trusted")
+	untrusted, _ := testSigningKey(t, keys, "untrusted")
+
+	var tests = []struct {
+		name          string
+		setup         func(t *testing.T, chartRepo *testChartRepo)
+		chart         string
+		keyring       string
+		expectedError string
+	}{
+		{
+			name: "signed",
+			setup: func(t *testing.T, chartRepo *testChartRepo) {
+				chartRepo.sign(t, trusted, "0.1.0")
+			},
+		},
+		{
+			name:          "unsigned",
+			setup:         func(t *testing.T, chartRepo *testChartRepo) {},
+			expectedError: "no provenance file was found",
+		},
+		{
+			name: "untrusted-key",
+			setup: func(t *testing.T, chartRepo *testChartRepo) {
+				chartRepo.sign(t, untrusted, "0.1.0")
+			},
+			expectedError: "failed provenance verification",
+		},
+		{
+			name: "tampered",
+			setup: func(t *testing.T, chartRepo *testChartRepo) {
+				chartRepo.sign(t, trusted, "0.1.0")
+				chartRepo.republish(t, "0.1.0")
+			},
+			expectedError: "sha256 sum does not match",
+		},
+		{
+			name: "missing-keyring",
+			setup: func(t *testing.T, chartRepo *testChartRepo) {
+				chartRepo.sign(t, trusted, "0.1.0")
+			},
+			keyring:       "missing.gpg",
+			expectedError: "unable to load keyring",
+		},
+		{
+			name:          "local-chart",
+			setup:         func(t *testing.T, chartRepo *testChartRepo) {},
+			chart:         "./examples/local-chart",
+			expectedError: "cannot be verified",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testChartCache(t)
+			chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+			test.setup(t, chartRepo)
+
+			var fn RenderHelmChartFunction
+			fn.ReleaseName = "local-chart"
+			fn.Repo = chartRepo.URL
+			fn.Chart = "local-chart"
+			fn.Version = "0.1.0"
+			fn.Verify = true
+			fn.Keyring = keyring
+			if test.keyring != "" {
+				fn.Keyring = test.keyring
+			}
+			if test.chart != "" {
+				fn.Repo = ""
+				fn.Chart = test.chart
+				fn.BaseDirectory = "."
+			}
+
+			output, err := fn.Filter([]*kyaml.RNode{})
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+			assert.NotEmpty(t, output, test.name)
+			require.NotNil(t, fn.resolved.signer, "signer")
+			assert.Equal(t, "trusted <trusted@example.com>", fn.resolved.signer.identity, "identity")
+			assert.Equal(t, fmt.Sprintf("%X", trusted.PrimaryKey.Fingerprint), fn.resolved.signer.fingerprint, "fingerprint")
+
+			// the provenance is cached and vendored with the archive
+			chartRepo.Close()
+			_, err = fn.Filter([]*kyaml.RNode{})
+			require.NoError(t, err, "cached")
+
+			fn.BaseDirectory = t.TempDir()
+			archive, _, err := fn.VendorChart()
+			require.NoError(t, err, "VendorChart")
+			assert.FileExists(t, archive+provenanceExt, "vendored provenance")
+			testChartCache(t)
+			_, err = fn.Filter([]*kyaml.RNode{})
+			require.NoError(t, err, "vendored")
+		})
+	}
+}
+
+func TestKonvertFilterSignerAnnotations(t *testing.T) {
+	testChartCache(t)
+	trusted, keyring := testSigningKey(t, t.TempDir(), "trusted")
+	chartRepo := newTestChartRepo(t, false, "", "", "0.1.0")
+	chartRepo.sign(t, trusted, "0.1.0")
+
+	var fn KonvertFunction
+	fn.ResourceMeta.Name = "local-chart"
+	fn.Repo = chartRepo.URL
+	fn.Chart = "local-chart"
+	fn.Version = "0.1.0"
+	fn.Verify = true
+	fn.Keyring = keyring
+	fn.lockPath = "konvert.lock.yaml"
+
+	output, err := fn.Filter([]*kyaml.RNode{})
+	require.NoError(t, err, "Filter")
+	var resources int
+	for _, node := range output {
+		if IsKonvertLock(node) {
+			continue
+		}
+		resources++
+		annotations := node.GetAnnotations()
+		assert.Equal(t, "trusted <trusted@example.com>", annotations[annotationKonvertSignedBy], node.GetName())
+		assert.Equal(t, fmt.Sprintf("%X", trusted.PrimaryKey.Fingerprint), annotations[annotationKonvertSigner], node.GetName())
+	}
+	assert.NotZero(t, resources, "resources")
+}
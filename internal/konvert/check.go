@@ -0,0 +1,150 @@
+package konvert
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const labelHelmChart = "helm.sh/chart"
+
+type StaleReason string
+
+const (
+	StaleChartVersion StaleReason = "chart version"
+	StaleValues       StaleReason = "values"
+	StaleManualEdit   StaleReason = "manual edits"
+)
+
+// StaleFile is a file that does not match what konvert would render
+type StaleFile struct {
+	FileDiff
+	Reason StaleReason
+	Detail string
+}
+
+// CheckResult lists the stale files for a single Konvert file
+type CheckResult struct {
+	KonvertFile string
+	Files       []StaleFile
+}
+
+// Reasons returns the distinct reasons the Konvert file is stale
+func (r CheckResult) Reasons() []StaleReason {
+	var reasons []StaleReason
+	seen := make(map[StaleReason]bool)
+	for _, f := range r.Files {
+		if !seen[f.Reason] {
+			seen[f.Reason] = true
+			reasons = append(reasons, f.Reason)
+		}
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		return reasons[i] < reasons[j]
+	})
+	return reasons
+}
+
+// Check is the read-only counterpart of Konvert: it renders every Konvert file
+// found at kpath and returns the ones whose output on disk is stale
+func Check(kpath string, options functions.Options) ([]CheckResult, error) {
+	k, err := New(kpath, options)
+	if err != nil {
+		return nil, err
+	}
+	return k.Check()
+}
+
+// Check renders each Konvert function separately so that stale files can be
+// attributed to the Konvert file responsible for them
+func (k *Konverter) Check() ([]CheckResult, error) {
+	var results []CheckResult
+	for _, fn := range k.fns {
+		konvertFile := k.path
+		if kfn, ok := fn.(*functions.KonvertFunction); ok {
+			konvertFile = kfn.FilePath()
+		}
+		log.WithField("path", konvertFile).Debug("checking Konvert fn")
+
+		single := &Konverter{path: k.path, fns: []kio.Filter{fn}}
+		diffs, err := single.Diff()
+		if err != nil {
+			return results, errors.Wrapf(err, "unable to check %s", konvertFile)
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+
+		result := CheckResult{KonvertFile: konvertFile}
+		for _, diff := range diffs {
+			stale, err := classify(diff)
+			if err != nil {
+				return results, errors.Wrapf(err, "unable to check %s", diff.Path)
+			}
+			result.Files = append(result.Files, stale)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// classify determines why a file is stale. Manual edits take precedence since
+// they are detected from the checksum recorded at render time; otherwise a
+// change in the helm.sh/chart label means the chart version changed and
+// anything else is attributed to the values (or other settings) in the spec.
+func classify(diff FileDiff) (StaleFile, error) {
+	stale := StaleFile{FileDiff: diff}
+
+	before, err := kio.ParseAll(diff.Before)
+	if err != nil {
+		return stale, errors.Wrap(err, "unable to parse file on disk")
+	}
+	after, err := kio.ParseAll(diff.After)
+	if err != nil {
+		return stale, errors.Wrap(err, "unable to parse rendered file")
+	}
+
+	for _, node := range before {
+		modified, err := functions.IsModifiedSinceRender(node)
+		if err != nil {
+			return stale, err
+		}
+		if modified {
+			stale.Reason = StaleManualEdit
+			stale.Detail = fmt.Sprintf("%s %s was edited after it was generated", node.GetKind(), node.GetName())
+			return stale, nil
+		}
+	}
+
+	oldChart, newChart := chartLabel(before), chartLabel(after)
+	if oldChart != "" && newChart != "" && oldChart != newChart {
+		stale.Reason = StaleChartVersion
+		stale.Detail = fmt.Sprintf("rendered from %s, spec wants %s", oldChart, newChart)
+		return stale, nil
+	}
+
+	stale.Reason = StaleValues
+	switch diff.Status {
+	case DiffAdded:
+		stale.Detail = "would be generated"
+	case DiffRemoved:
+		stale.Detail = "would no longer be generated"
+	default:
+		stale.Detail = "rendered content differs"
+	}
+	return stale, nil
+}
+
+func chartLabel(nodes []*kyaml.RNode) string {
+	for _, node := range nodes {
+		if chart := node.GetLabels()[labelHelmChart]; chart != "" {
+			return chart
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package konvert
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name            string
+		checksum        bool
+		modify          func(t *testing.T, baseDir, chartDir string)
+		expectedReasons map[string]StaleReason
+	}{
+		{
+			name:            "up-to-date",
+			modify:          func(t *testing.T, baseDir, chartDir string) {},
+			expectedReasons: map[string]StaleReason{},
+		},
+		{
+			name:     "manual-edit",
+			checksum: true,
+			modify: func(t *testing.T, baseDir, chartDir string) {
+				testReplaceInFile(t, filepath.Join(baseDir, "service-local-chart.yaml"), "port: 80", "port: 8080")
+			},
+			expectedReasons: map[string]StaleReason{
+				"service-local-chart.yaml": StaleManualEdit,
+			},
+		},
+		{
+			// without checksums, hand edits cannot be told from values
+			name: "manual-edit-without-checksum",
+			modify: func(t *testing.T, baseDir, chartDir string) {
+				testReplaceInFile(t, filepath.Join(baseDir, "service-local-chart.yaml"), "port: 80", "port: 8080")
+			},
+			expectedReasons: map[string]StaleReason{
+				"service-local-chart.yaml": StaleValues,
+			},
+		},
+		{
+			name: "values",
+			modify: func(t *testing.T, baseDir, chartDir string) {
+				testReplaceInFile(t, filepath.Join(baseDir, "konvert.yaml"), "  kustomize: true\n", "  kustomize: true\n  values:\n    replicaCount: 3\n")
+			},
+			expectedReasons: map[string]StaleReason{
+				"deployment-local-chart.yaml": StaleValues,
+			},
+		},
+		{
+			name: "chart-version",
+			modify: func(t *testing.T, baseDir, chartDir string) {
+				testReplaceInFile(t, filepath.Join(chartDir, "Chart.yaml"), "version: 0.1.0", "version: 0.2.0")
+			},
+			expectedReasons: map[string]StaleReason{
+				"deployment-local-chart.yaml":          StaleChartVersion,
+				"pod-local-chart-test-connection.yaml": StaleChartVersion,
+				"service-local-chart.yaml":             StaleChartVersion,
+				"serviceaccount-local-chart.yaml":      StaleChartVersion,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			chartDir := filepath.Join(t.TempDir(), "local-chart")
+			require.NoError(t, os.CopyFS(chartDir, os.DirFS(testLocalChartPath(t))), "CopyFS")
+
+			err := testWriteLocalKonvertChart(t, baseDir, "konvert.yaml", chartDir)
+			require.NoError(t, err, "testWriteLocalKonvertChart")
+			if test.checksum {
+				testReplaceInFile(t, filepath.Join(baseDir, "konvert.yaml"), "  kustomize: true\n", "  kustomize: true\n  checksum: true\n")
+			}
+			require.NoError(t, Konvert(baseDir, functions.Options{}), "Konvert")
+
+			test.modify(t, baseDir, chartDir)
+
+			results, err := Check(baseDir, functions.Options{})
+			require.NoError(t, err, "Check")
+
+			actual := make(map[string]StaleReason)
+			for _, result := range results {
+				assert.Equal(t, filepath.Join(baseDir, "konvert.yaml"), result.KonvertFile, "KonvertFile")
+				for _, file := range result.Files {
+					actual[file.Path] = file.Reason
+				}
+			}
+			assert.Equal(t, test.expectedReasons, actual, test.name)
+		})
+	}
+}
+
+func testReplaceInFile(t *testing.T, path, old, new string) {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err, "ReadFile")
+	require.Contains(t, string(content), old, path)
+	err = os.WriteFile(path, []byte(strings.Replace(string(content), old, new, 1)), 0644)
+	require.NoError(t, err, "WriteFile")
+}
@@ -0,0 +1,218 @@
+package konvert
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+)
+
+type DiffStatus string
+
+const (
+	DiffAdded    DiffStatus = "added"
+	DiffRemoved  DiffStatus = "removed"
+	DiffModified DiffStatus = "modified"
+)
+
+// FileDiff describes how a single file in the package (keyed by its
+// config.kubernetes.io/path annotation) would change if konvert was run
+type FileDiff struct {
+	Path   string
+	Status DiffStatus
+	Before string
+	After  string
+}
+
+// Unified returns the change as a unified diff
+func (d FileDiff) Unified() (string, error) {
+	fromFile, toFile := "a/"+d.Path, "b/"+d.Path
+	switch d.Status {
+	case DiffAdded:
+		fromFile = "/dev/null"
+	case DiffRemoved:
+		toFile = "/dev/null"
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(d.Before),
+		B:        splitLines(d.After),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
+
+// Diff runs the Konvert functions against the package in memory and returns
+// the files that would be added, removed or modified, sorted by path. Nothing
+// is written to disk.
+func (k *Konverter) Diff() ([]FileDiff, error) {
+	rendered, err := k.render()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []FileDiff
+	for path, after := range rendered.after {
+		before, existed := rendered.before[path]
+		switch {
+		case !existed:
+			diffs = append(diffs, FileDiff{Path: path, Status: DiffAdded, After: after})
+		case before != after:
+			diffs = append(diffs, FileDiff{Path: path, Status: DiffModified, Before: before, After: after})
+		}
+	}
+	for path, before := range rendered.before {
+		if _, ok := rendered.after[path]; !ok {
+			diffs = append(diffs, FileDiff{Path: path, Status: DiffRemoved, Before: before})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
+
+type renderedPackage struct {
+	// file contents on disk, keyed by path relative to the package
+	before map[string]string
+	// file contents as they would be written by Run
+	after map[string]string
+}
+
+// render runs the pipeline the same way Run does but writes the output to an
+// in-memory filesystem so it can be compared with what is on disk
+func (k *Konverter) render() (*renderedPackage, error) {
+	nodes, err := kio.LocalPackageReader{
+		PackagePath:    k.path,
+		MatchFilesGlob: packageFiles,
+	}.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read package %s", k.path)
+	}
+
+	rendered := &renderedPackage{
+		before: make(map[string]string),
+		after:  make(map[string]string),
+	}
+
+	for _, node := range nodes {
+		path, _, err := kioutil.GetFileAnnotations(node)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting file annotations")
+		}
+		if _, ok := rendered.before[path]; ok {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(k.path, path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s", path)
+		}
+		rendered.before[path] = string(content)
+	}
+
+	// the files generated by the previous run are removed when they are not
+	// written again
+	generated, err := functions.GeneratedFiles(nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range generated {
+		content, err := os.ReadFile(filepath.Join(k.path, path))
+		if err == nil {
+			rendered.before[path] = string(content)
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "unable to read %s", path)
+		}
+	}
+
+	memfs := filesys.MakeFsInMemory()
+	if err := memfs.MkdirAll(k.path); err != nil {
+		return nil, errors.Wrap(err, "unable to create in-memory package directory")
+	}
+
+	output := &kio.PackageBuffer{}
+	err = kio.Pipeline{
+		Inputs:  []kio.Reader{&kio.PackageBuffer{Nodes: nodes}},
+		Filters: k.fns,
+		Outputs: []kio.Writer{output},
+	}.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	// the writer clears the path annotations, so collect the paths first
+	if err := kioutil.DefaultPathAndIndexAnnotation("", output.Nodes); err != nil {
+		return nil, errors.Wrap(err, "unable to set default path annotations")
+	}
+	var paths []string
+	for _, node := range output.Nodes {
+		path, _, err := kioutil.GetFileAnnotations(node)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting file annotations")
+		}
+		paths = append(paths, path)
+		// the files written as is are not read with the package
+		if _, ok := rendered.before[path]; !ok && functions.IsFile(node) {
+			content, err := os.ReadFile(filepath.Join(k.path, path))
+			if err == nil {
+				rendered.before[path] = string(content)
+			} else if !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "unable to read %s", path)
+			}
+		}
+	}
+
+	err = filesWriter{
+		PackagePath: k.path,
+		FileSystem:  memfs,
+		Writer: kio.LocalPackageWriter{
+			PackagePath: k.path,
+			FileSystem:  filesys.FileSystemOrOnDisk{FileSystem: memfs},
+		},
+		Generated: generated,
+	}.Write(output.Nodes)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to write rendered package")
+	}
+
+	for _, path := range paths {
+		if _, ok := rendered.after[path]; ok {
+			continue
+		}
+		content, err := memfs.ReadFile(filepath.Join(k.path, path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read rendered %s", path)
+		}
+		rendered.after[path] = string(content)
+	}
+
+	log.WithFields(log.Fields{
+		"path":   k.path,
+		"before": len(rendered.before),
+		"after":  len(rendered.after),
+	}).Debug("rendered package in memory")
+
+	return rendered, nil
+}
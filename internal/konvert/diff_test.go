@@ -0,0 +1,168 @@
+package konvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKonverterDiff(t *testing.T) {
+	var tests = []struct {
+		name           string
+		modify         func(t *testing.T, baseDir string)
+		expectedStatus map[string]DiffStatus
+	}{
+		{
+			name:           "no-changes",
+			modify:         func(t *testing.T, baseDir string) {},
+			expectedStatus: map[string]DiffStatus{},
+		},
+		{
+			name: "modified",
+			modify: func(t *testing.T, baseDir string) {
+				fn := filepath.Join(baseDir, "service-local-chart.yaml")
+				content, err := os.ReadFile(fn)
+				require.NoError(t, err, "ReadFile")
+				content = append(content, []byte("  clusterIP: None\n")...)
+				require.NoError(t, os.WriteFile(fn, content, 0644), "WriteFile")
+			},
+			expectedStatus: map[string]DiffStatus{
+				"service-local-chart.yaml": DiffModified,
+			},
+		},
+		{
+			name: "added",
+			modify: func(t *testing.T, baseDir string) {
+				require.NoError(t, os.Remove(filepath.Join(baseDir, "service-local-chart.yaml")), "Remove")
+			},
+			expectedStatus: map[string]DiffStatus{
+				"service-local-chart.yaml": DiffAdded,
+			},
+		},
+		{
+			name: "removed",
+			modify: func(t *testing.T, baseDir string) {
+				stale := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: stale
+  annotations:
+    konvert.kumorilabs.io/chart: %s
+`
+				err := os.WriteFile(
+					filepath.Join(baseDir, "configmap-stale.yaml"),
+					[]byte(fmt.Sprintf(stale, testLocalChartPath(t))),
+					0644,
+				)
+				require.NoError(t, err, "WriteFile")
+			},
+			expectedStatus: map[string]DiffStatus{
+				"configmap-stale.yaml": DiffRemoved,
+			},
+		},
+		{
+			// local charts are not locked
+			name: "stale-lock",
+			modify: func(t *testing.T, baseDir string) {
+				lock := `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: KonvertLock
+metadata:
+  name: local-chart
+  annotations:
+    config.kubernetes.io/local-config: "true"
+spec:
+  chart: local-chart
+  version: 0.1.0
+  digest: sha256:0000
+`
+				err := os.WriteFile(filepath.Join(baseDir, "konvert.lock.yaml"), []byte(lock), 0644)
+				require.NoError(t, err, "WriteFile")
+			},
+			expectedStatus: map[string]DiffStatus{
+				"konvert.lock.yaml": DiffRemoved,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			err := testWriteLocalKonvert(t, baseDir, "konvert.yaml")
+			require.NoError(t, err, "testWriteLocalKonvert")
+
+			err = Konvert(baseDir, functions.Options{})
+			require.NoError(t, err, "Konvert")
+
+			test.modify(t, baseDir)
+
+			k, err := New(baseDir, functions.Options{})
+			require.NoError(t, err, "New")
+			diffs, err := k.Diff()
+			require.NoError(t, err, "Diff")
+
+			actual := make(map[string]DiffStatus)
+			for _, d := range diffs {
+				actual[d.Path] = d.Status
+				unified, err := d.Unified()
+				require.NoError(t, err, "Unified")
+				assert.Contains(t, unified, d.Path, "unified diff header")
+			}
+			assert.Equal(t, test.expectedStatus, actual, test.name)
+		})
+	}
+}
+
+func TestKonverterDiffDoesNotWrite(t *testing.T) {
+	baseDir := t.TempDir()
+	err := testWriteLocalKonvert(t, baseDir, "konvert.yaml")
+	require.NoError(t, err, "testWriteLocalKonvert")
+
+	k, err := New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err := k.Diff()
+	require.NoError(t, err, "Diff")
+	assert.NotEmpty(t, diffs, "diffs")
+
+	files, err := os.ReadDir(baseDir)
+	require.NoError(t, err, "ReadDir")
+	assert.Len(t, files, 1, "only konvert.yaml on disk")
+}
+
+func testLocalChartPath(t *testing.T) string {
+	chart, err := filepath.Abs("../functions/examples/local-chart")
+	require.NoError(t, err, "Abs")
+	return chart
+}
+
+func testWriteLocalKonvert(t *testing.T, baseDir, filename string) error {
+	return testWriteLocalKonvertChart(t, baseDir, filename, testLocalChartPath(t))
+}
+
+func testWriteLocalKonvertChart(t *testing.T, baseDir, filename, chart string) error {
+	konvertyaml := fmt.Sprintf(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: local-chart
+  annotations:
+    config.kubernetes.io/local-config: "true"
+spec:
+  chart: %s
+  kustomize: true
+  namespace: local-chart
+  kubeVersion: v1.29.0
+`,
+		chart,
+	)
+
+	fn := filepath.Join(baseDir, filename)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(fn, []byte(konvertyaml), 0644)
+}
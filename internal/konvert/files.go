@@ -0,0 +1,112 @@
+package konvert
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// filesWriter writes the files produced by the Konvert functions as is, e.g.
+// the keys of generated ConfigMaps (see functions.IsFile), and the other nodes
+// with Writer
+type filesWriter struct {
+	PackagePath string
+	FileSystem  filesys.FileSystem
+	Writer      kio.Writer
+	// Generated are the files written by the previous run, see
+	// functions.GeneratedFiles. Those not written again are removed, with
+	// their directory when it is left empty.
+	Generated []string
+}
+
+func (w filesWriter) Write(nodes []*kyaml.RNode) error {
+	var resources []*kyaml.RNode
+	files := make(map[string][]byte)
+	for _, node := range nodes {
+		if !functions.IsFile(node) {
+			resources = append(resources, node)
+			continue
+		}
+		path, _, err := kioutil.GetFileAnnotations(node)
+		if err != nil {
+			return errors.Wrap(err, "getting file annotations")
+		}
+		content, err := functions.FileContent(node)
+		if err != nil {
+			return err
+		}
+		files[path] = content
+	}
+	if err := w.writeFiles(files); err != nil {
+		return err
+	}
+	return w.Writer.Write(resources)
+}
+
+func (w filesWriter) writeFiles(files map[string][]byte) error {
+	dirs := make(map[string]bool)
+	for path := range files {
+		dirs[filepath.Dir(path)] = true
+	}
+	for _, path := range w.Generated {
+		dirs[filepath.Dir(path)] = true
+	}
+	// the directories of the files are written by konvert only, files left
+	// over from a previous run (e.g. a key removed from a ConfigMap) are
+	// removed. Files of the package are left to Writer.
+	for dir := range dirs {
+		dirPath := filepath.Join(w.PackagePath, dir)
+		if !w.FileSystem.IsDir(dirPath) {
+			continue
+		}
+		names, err := w.FileSystem.ReadDir(dirPath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read directory %s", dir)
+		}
+		for _, name := range names {
+			if _, ok := files[filepath.Join(dir, name)]; ok || isPackageFile(name) || w.FileSystem.IsDir(filepath.Join(dirPath, name)) {
+				continue
+			}
+			if err := w.FileSystem.RemoveAll(filepath.Join(dirPath, name)); err != nil {
+				return errors.Wrapf(err, "unable to remove %s", filepath.Join(dir, name))
+			}
+		}
+		// the directory of a ConfigMap no longer rendered
+		if names, err := w.FileSystem.ReadDir(dirPath); err == nil && len(names) == 0 && dir != "." {
+			if err := w.FileSystem.RemoveAll(dirPath); err != nil {
+				return errors.Wrapf(err, "unable to remove %s", dir)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fullPath := filepath.Join(w.PackagePath, path)
+		if err := w.FileSystem.MkdirAll(filepath.Dir(fullPath)); err != nil {
+			return errors.Wrapf(err, "unable to create directory of %s", path)
+		}
+		if err := w.FileSystem.WriteFile(fullPath, files[path]); err != nil {
+			return errors.Wrapf(err, "unable to write %s", path)
+		}
+	}
+	return nil
+}
+
+func isPackageFile(name string) bool {
+	for _, glob := range packageFiles {
+		if matched, _ := filepath.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package konvert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+func TestFilesWriter(t *testing.T) {
+	fs := filesys.MakeFsInMemory()
+	require.NoError(t, fs.MkdirAll("/pkg/upstream/configmap-mysql"), "MkdirAll")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-mysql/removed.cnf", []byte("stale")), "WriteFile")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-mysql/resource.yaml", []byte("kind: Other")), "WriteFile")
+
+	nodes, err := kio.ParseAll(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: File
+metadata:
+  name: upstream/configmap-mysql/my.cnf
+  annotations:
+    config.kubernetes.io/local-config: "true"
+    internal.config.kubernetes.io/path: upstream/configmap-mysql/my.cnf
+data: |
+  [mysqld]
+---
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: File
+metadata:
+  name: upstream/configmap-mysql/logo.png
+  annotations:
+    config.kubernetes.io/local-config: "true"
+    internal.config.kubernetes.io/path: upstream/configmap-mysql/logo.png
+binaryData: iVBORw0KGgo=
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: mysql
+  annotations:
+    internal.config.kubernetes.io/path: upstream/service-mysql.yaml
+`)
+	require.NoError(t, err, "ParseAll")
+
+	resources := &kio.PackageBuffer{}
+	err = filesWriter{PackagePath: "/pkg", FileSystem: fs, Writer: resources}.Write(nodes)
+	require.NoError(t, err, "Write")
+
+	require.Len(t, resources.Nodes, 1, "resources")
+	assert.Equal(t, "Service", resources.Nodes[0].GetKind(), "resources")
+
+	content, err := fs.ReadFile("/pkg/upstream/configmap-mysql/my.cnf")
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, "[mysqld]\n", string(content), "text file")
+	content, err = fs.ReadFile("/pkg/upstream/configmap-mysql/logo.png")
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, "\x89PNG\r\n\x1a\n", string(content), "binary file")
+	assert.False(t, fs.Exists("/pkg/upstream/configmap-mysql/removed.cnf"), "stale file is removed")
+	assert.True(t, fs.Exists("/pkg/upstream/configmap-mysql/resource.yaml"), "package files are left to the writer")
+}
+
+func TestFilesWriterGenerated(t *testing.T) {
+	fs := filesys.MakeFsInMemory()
+	require.NoError(t, fs.MkdirAll("/pkg/upstream/configmap-mysql"), "MkdirAll")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-mysql/my.cnf", []byte("[mysqld]")), "WriteFile")
+	require.NoError(t, fs.MkdirAll("/pkg/upstream/configmap-nginx"), "MkdirAll")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-nginx/nginx.conf", []byte("worker_processes 1;")), "WriteFile")
+	require.NoError(t, fs.MkdirAll("/pkg/upstream/configmap-redis"), "MkdirAll")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-redis/redis.conf", []byte("port 6379")), "WriteFile")
+	require.NoError(t, fs.WriteFile("/pkg/upstream/configmap-redis/kustomization.yaml", []byte("kind: Kustomization")), "WriteFile")
+
+	nodes, err := kio.ParseAll(`apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: File
+metadata:
+  name: upstream/configmap-mysql/my.cnf
+  annotations:
+    config.kubernetes.io/local-config: "true"
+    internal.config.kubernetes.io/path: upstream/configmap-mysql/my.cnf
+data: |
+  [mysqld]
+`)
+	require.NoError(t, err, "ParseAll")
+
+	err = filesWriter{
+		PackagePath: "/pkg",
+		FileSystem:  fs,
+		Writer:      &kio.PackageBuffer{},
+		Generated: []string{
+			"upstream/configmap-mysql/my.cnf",
+			"upstream/configmap-nginx/nginx.conf",
+			"upstream/configmap-redis/redis.conf",
+		},
+	}.Write(nodes)
+	require.NoError(t, err, "Write")
+
+	assert.True(t, fs.Exists("/pkg/upstream/configmap-mysql/my.cnf"), "file written again")
+	assert.False(t, fs.Exists("/pkg/upstream/configmap-nginx"), "directory of a removed ConfigMap")
+	assert.False(t, fs.Exists("/pkg/upstream/configmap-redis/redis.conf"), "file of a removed ConfigMap")
+	assert.True(t, fs.Exists("/pkg/upstream/configmap-redis/kustomization.yaml"), "package files are left to the writer")
+}
+
+func TestKonverterRunConfigMapGenerator(t *testing.T) {
+	c, err := loader.Load(testLocalChartPath(t))
+	require.NoError(t, err, "Load")
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/configmap.yaml",
+		Data: []byte(`apiVersion: v1
+kind: ConfigMap
+data:
+  nginx.conf: |
+    worker_processes 1;
+  values.yaml: |
+    replicas: 1
+metadata:
+  name: {{ .Release.Name }}-config
+`),
+	})
+	chartDir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, chartDir), "SaveDir")
+
+	baseDir := t.TempDir()
+	require.NoError(t, testWriteLocalKonvertChart(t, baseDir, "konvert.yaml", filepath.Join(chartDir, c.Name())), "testWriteLocalKonvertChart")
+	konvertFile := filepath.Join(baseDir, "konvert.yaml")
+	content, err := os.ReadFile(konvertFile)
+	require.NoError(t, err, "ReadFile")
+	require.NoError(t, os.WriteFile(konvertFile, append(content, []byte("  configMapGenerator: true\n")...), 0644), "WriteFile")
+
+	require.NoError(t, Konvert(baseDir, functions.Options{}), "Konvert")
+
+	assert.NoFileExists(t, filepath.Join(baseDir, "configmap-local-chart-config.yaml"), "configmap")
+	content, err = os.ReadFile(filepath.Join(baseDir, "configmap-local-chart-config", "nginx.conf"))
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, "worker_processes 1;\n", string(content), "nginx.conf")
+	content, err = os.ReadFile(filepath.Join(baseDir, "configmap-local-chart-config", "values.yaml.txt"))
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, "replicas: 1\n", string(content), "values.yaml")
+
+	// kustomize generates the ConfigMap as it was rendered
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), baseDir)
+	require.NoError(t, err, "kustomize build")
+	var configMap *resource.Resource
+	for _, res := range resMap.Resources() {
+		if res.GetKind() == "ConfigMap" {
+			configMap = res
+		}
+	}
+	require.NotNil(t, configMap, "generated configmap")
+	assert.Equal(t, "local-chart-config", configMap.GetName(), "name")
+	assert.Equal(t, "local-chart", configMap.GetNamespace(), "namespace")
+	assert.Equal(t, map[string]string{
+		"nginx.conf":  "worker_processes 1;\n",
+		"values.yaml": "replicas: 1\n",
+	}, configMap.GetDataMap(), "data")
+
+	// a second run has nothing to change
+	k, err := New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err := k.Diff()
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, diffs, "diffs")
+
+	// the files of a ConfigMap removed from the chart are removed with their
+	// directory
+	require.NoError(t, os.Remove(filepath.Join(chartDir, c.Name(), "templates", "configmap.yaml")), "Remove")
+	k, err = New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err = k.Diff()
+	require.NoError(t, err, "Diff")
+	removed := make(map[string]DiffStatus)
+	for _, diff := range diffs {
+		if diff.Status == DiffRemoved {
+			removed[diff.Path] = diff.Status
+		}
+	}
+	assert.Equal(t, map[string]DiffStatus{
+		"configmap-local-chart-config/nginx.conf":      DiffRemoved,
+		"configmap-local-chart-config/values.yaml.txt": DiffRemoved,
+	}, removed, "removed files")
+
+	require.NoError(t, Konvert(baseDir, functions.Options{}), "Konvert")
+	assert.NoDirExists(t, filepath.Join(baseDir, "configmap-local-chart-config"), "configmap directory")
+	k, err = New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err = k.Diff()
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, diffs, "diffs")
+}
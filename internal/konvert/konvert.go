@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 
 	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
 	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
@@ -16,14 +18,17 @@ import (
 // if path points directly to a konvert-file, load it and run
 // if it is directory, discover all konvert-files and run against each one
 
-func Konvert(kpath string) error {
-	k, err := New(kpath)
+func Konvert(kpath string, options functions.Options) error {
+	k, err := New(kpath, options)
 	if err != nil {
 		return err
 	}
 	return k.Run()
 }
 
+// packageFiles matches the files read from and written to the package
+var packageFiles = kio.DefaultMatch
+
 type Konverter struct {
 	path string
 	fns  []kio.Filter
@@ -31,16 +36,30 @@ type Konverter struct {
 
 func (k *Konverter) Run() error {
 	inout := &kio.LocalPackageReadWriter{
-		PackagePath: k.path,
+		PackagePath:    k.path,
+		MatchFilesGlob: packageFiles,
+	}
+	nodes, err := inout.Read()
+	if err != nil {
+		return errors.Wrapf(err, "unable to read package %s", k.path)
+	}
+	generated, err := functions.GeneratedFiles(nodes)
+	if err != nil {
+		return err
 	}
 	return kio.Pipeline{
-		Inputs:  []kio.Reader{inout},
+		Inputs:  []kio.Reader{&kio.PackageBuffer{Nodes: nodes}},
 		Filters: k.fns,
-		Outputs: []kio.Writer{inout},
+		Outputs: []kio.Writer{filesWriter{
+			PackagePath: k.path,
+			FileSystem:  filesys.MakeFsOnDisk(),
+			Writer:      inout,
+			Generated:   generated,
+		}},
 	}.Execute()
 }
 
-func New(kpath string) (*Konverter, error) {
+func New(kpath string, options functions.Options) (*Konverter, error) {
 	var (
 		konvertfns []kio.Filter
 		basedir    string
@@ -54,14 +73,14 @@ func New(kpath string) (*Konverter, error) {
 
 	if finfo.IsDir() {
 		basedir = kpath
-		fns, err := discoverFns(kpath)
+		fns, err := discoverFns(kpath, options)
 		if err != nil {
 			return nil, err
 		}
 		konvertfns = fns
 	} else {
 		basedir = path.Dir(kpath)
-		fn, err := loadFn(kpath)
+		fn, err := loadFn(kpath, options)
 		if err != nil {
 			return nil, err
 		}
@@ -71,20 +90,33 @@ func New(kpath string) (*Konverter, error) {
 	return &Konverter{basedir, konvertfns}, nil
 }
 
-func loadFn(kpath string) (kio.Filter, error) {
+// chartFunctions returns a Konvert function per chart, see
+// functions.KonvertFunction.ChartFunctions
+func (k *Konverter) chartFunctions() []*functions.KonvertFunction {
+	var kfns []*functions.KonvertFunction
+	for _, fn := range k.fns {
+		if kfn, ok := fn.(*functions.KonvertFunction); ok {
+			kfns = append(kfns, kfn.ChartFunctions()...)
+		}
+	}
+	return kfns
+}
+
+func loadFn(kpath string, options functions.Options) (kio.Filter, error) {
 	konvertNode, err := kyaml.ReadFile(kpath)
 	if err != nil {
 		return nil, err
 	}
 	log.WithField("path", kpath).Debug("adding Konvert fn")
 	fn := functions.Konvert(kpath)
+	fn.SetOptions(options)
 	if err := fn.Config(konvertNode); err != nil {
 		return nil, err
 	}
 	return fn, nil
 }
 
-func discoverFns(pkgpath string) ([]kio.Filter, error) {
+func discoverFns(pkgpath string, options functions.Options) ([]kio.Filter, error) {
 	var konvertfns []kio.Filter
 	reader := kio.LocalPackageReader{PackagePath: pkgpath}
 	rnodes, err := reader.Read()
@@ -102,6 +134,7 @@ func discoverFns(pkgpath string) ([]kio.Filter, error) {
 
 			log.WithField("path", path).Debug("adding Konvert fn")
 			fn := functions.Konvert(path)
+			fn.SetOptions(options)
 			if err := fn.Config(rnode); err != nil {
 				return konvertfns, err
 			}
@@ -1,13 +1,19 @@
 package konvert
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"filippo.io/age"
+	"github.com/kumorilabs/konvert/internal/functions"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 )
 
 func TestNewKonverter(t *testing.T) {
@@ -72,7 +78,7 @@ func TestNewKonverter(t *testing.T) {
 
 			path := filepath.Join(baseDir, test.path)
 
-			k, err := New(path)
+			k, err := New(path, functions.Options{})
 			require.NoError(t, err, "New")
 			assert.Equal(t, baseDir, k.path, "path")
 			assert.Equal(t, test.expectedFnCount, len(k.fns), "fns")
@@ -100,7 +106,7 @@ func TestKonverterRun(t *testing.T) {
 	)
 	require.NoError(t, err, "testWriteKonvertYAML")
 
-	err = Konvert(baseDir)
+	err = Konvert(baseDir, functions.Options{})
 	require.NoError(t, err, "Konvert")
 
 	files, err := os.ReadDir(baseDir)
@@ -145,3 +151,69 @@ spec:
 		0644,
 	)
 }
+
+func TestKonverterRunSOPS(t *testing.T) {
+	c, err := loader.Load(testLocalChartPath(t))
+	require.NoError(t, err, "Load")
+	c.Templates = append(c.Templates, &chart.File{
+		Name: "templates/secret.yaml",
+		Data: []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}-credentials
+data:
+  password: {{ "secret" | b64enc }}
+`),
+	})
+	chartDir := t.TempDir()
+	require.NoError(t, chartutil.SaveDir(c, chartDir), "SaveDir")
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err, "GenerateX25519Identity")
+	t.Setenv("SOPS_AGE_KEY", identity.String())
+
+	baseDir := t.TempDir()
+	require.NoError(t, testWriteLocalKonvertChart(t, baseDir, "konvert.yaml", filepath.Join(chartDir, c.Name())), "testWriteLocalKonvertChart")
+	konvertFile := filepath.Join(baseDir, "konvert.yaml")
+	content, err := os.ReadFile(konvertFile)
+	require.NoError(t, err, "ReadFile")
+	content = append(content, []byte(fmt.Sprintf("  sops:\n    age:\n    - %s\n", identity.Recipient()))...)
+	require.NoError(t, os.WriteFile(konvertFile, content, 0644), "WriteFile")
+
+	require.NoError(t, Konvert(baseDir, functions.Options{}), "Konvert")
+
+	secretFile := filepath.Join(baseDir, "secret-local-chart-credentials.yaml")
+	encrypted, err := os.ReadFile(secretFile)
+	require.NoError(t, err, "ReadFile")
+	assert.Contains(t, string(encrypted), "password: ENC[AES256_GCM,", "encrypted")
+	assert.NotContains(t, string(encrypted), "c2VjcmV0", "plaintext")
+
+	// a second run has nothing to change
+	k, err := New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err := k.Diff()
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, diffs, "diffs")
+	require.NoError(t, Konvert(baseDir, functions.Options{}), "Konvert")
+	content, err = os.ReadFile(secretFile)
+	require.NoError(t, err, "ReadFile")
+	assert.Equal(t, string(encrypted), string(content), "secret")
+
+	// without the identity, the unchanged Secret is not encrypted again
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err, "GenerateX25519Identity")
+	t.Setenv("SOPS_AGE_KEY", other.String())
+	diffs, err = k.Diff()
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, diffs, "diffs")
+
+	// a changed Secret is encrypted again without the identity
+	require.NoError(t, os.WriteFile(filepath.Join(chartDir, c.Name(), "templates", "secret.yaml"),
+		bytes.Replace(c.Templates[len(c.Templates)-1].Data, []byte(`"secret"`), []byte(`"changed"`), 1), 0644), "WriteFile")
+	k, err = New(baseDir, functions.Options{})
+	require.NoError(t, err, "New")
+	diffs, err = k.Diff()
+	require.NoError(t, err, "Diff")
+	require.Len(t, diffs, 1, "diffs")
+	assert.Equal(t, "secret-local-chart-credentials.yaml", diffs[0].Path, "diffs")
+}
@@ -0,0 +1,60 @@
+package konvert
+
+import (
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// OutdatedResult compares the chart version of a single Konvert file with the
+// versions available in its repository
+type OutdatedResult struct {
+	KonvertFile       string `json:"konvertFile"`
+	Name              string `json:"name"`
+	ReleaseName       string `json:"releaseName"`
+	Repo              string `json:"repo"`
+	Chart             string `json:"chart"`
+	Version           string `json:"version"`
+	VersionConstraint string `json:"versionConstraint,omitempty"`
+	functions.ChartUpdates
+}
+
+// Outdated compares the chart version of every Konvert file found at kpath
+// with the versions available in its repository
+func Outdated(kpath string, options functions.Options) ([]OutdatedResult, error) {
+	k, err := New(kpath, options)
+	if err != nil {
+		return nil, err
+	}
+	return k.Outdated()
+}
+
+// Outdated compares the chart version of each Konvert function with the
+// versions available in its repository. Charts that are not in a repository
+// are skipped.
+func (k *Konverter) Outdated() ([]OutdatedResult, error) {
+	var results []OutdatedResult
+	for _, kfn := range k.chartFunctions() {
+		log.WithField("path", kfn.FilePath()).Debug("listing chart versions for Konvert fn")
+
+		updates, err := kfn.ChartUpdates()
+		if err != nil {
+			return results, errors.Wrapf(err, "unable to list chart versions for %s", kfn.FilePath())
+		}
+		if updates == nil {
+			log.WithField("path", kfn.FilePath()).Debug("skipping chart without repository")
+			continue
+		}
+		results = append(results, OutdatedResult{
+			KonvertFile:       kfn.FilePath(),
+			Name:              kfn.ResourceMeta.Name,
+			ReleaseName:       kfn.ReleaseName(),
+			Repo:              kfn.Repo,
+			Chart:             kfn.Chart,
+			Version:           kfn.Version,
+			VersionConstraint: kfn.VersionConstraint,
+			ChartUpdates:      *updates,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,64 @@
+package konvert
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  app:
+  - name: app
+    version: 1.2.0
+    urls: [app-1.2.0.tgz]
+  - name: app
+    version: 1.1.1
+    urls: [app-1.1.1.tgz]
+  - name: app
+    version: 1.1.0
+    urls: [app-1.1.0.tgz]
+  - name: app
+    version: 2.0.0-beta.1
+    urls: [app-2.0.0-beta.1.tgz]
+  - name: app
+    version: 3.0.0
+    urls: [app-3.0.0.tgz]
+`
+
+func TestOutdated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/index.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, testIndex)
+	}))
+	defer server.Close()
+
+	baseDir := t.TempDir()
+	require.NoError(t, testWriteKonvertYAML(baseDir, "a/konvert.yaml", "app", server.URL, "1.1.0", "a"), "testWriteKonvertYAML")
+	require.NoError(t, testWriteKonvertYAML(baseDir, "b/konvert.yaml", "app", server.URL, "~1.1.0", "b"), "testWriteKonvertYAML")
+	require.NoError(t, testWriteLocalKonvert(t, baseDir, "c/konvert.yaml"), "testWriteLocalKonvert")
+
+	results, err := Outdated(baseDir, functions.Options{CacheDir: t.TempDir()})
+	require.NoError(t, err, "Outdated")
+	require.Len(t, results, 2, "local charts are skipped")
+
+	actual := make(map[string]functions.ChartUpdates)
+	for _, result := range results {
+		assert.Equal(t, "app", result.Chart, "chart")
+		assert.Equal(t, server.URL, result.Repo, "repo")
+		actual[result.KonvertFile] = result.ChartUpdates
+	}
+	assert.Equal(t, map[string]functions.ChartUpdates{
+		filepath.Join(baseDir, "a/konvert.yaml"): {Current: "1.1.0", Patch: "1.1.1", Minor: "1.2.0", Major: "3.0.0"},
+		filepath.Join(baseDir, "b/konvert.yaml"): {Current: "1.1.1", Minor: "1.2.0", Major: "3.0.0"},
+	}, actual)
+}
@@ -0,0 +1,253 @@
+package konvert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// UpgradeOptions select the Konvert files to upgrade and the version they are
+// upgraded to. By default, charts are upgraded to the latest version allowed
+// by their version constraint.
+type UpgradeOptions struct {
+	// Patch only upgrades to the latest patch version
+	Patch bool
+	// Minor only upgrades to the latest minor version
+	Minor bool
+	// To upgrades to this exact version
+	To string
+	// Name only upgrades the Konvert files with this name
+	Name string
+}
+
+func (o UpgradeOptions) validate() error {
+	set := 0
+	for _, option := range []bool{o.Patch, o.Minor, o.To != ""} {
+		if option {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of patch, minor or an exact version can be selected")
+	}
+	if o.To != "" {
+		if _, err := semver.NewVersion(o.To); err != nil {
+			return errors.Wrapf(err, "invalid version %q", o.To)
+		}
+	}
+	return nil
+}
+
+// UpgradeResult is the version change of a single Konvert file
+type UpgradeResult struct {
+	KonvertFile string
+	Name        string
+	Chart       string
+	From        string
+	To          string
+}
+
+// Upgrade rewrites the chart version of the Konvert files found at kpath and
+// re-renders the upgraded ones
+func Upgrade(kpath string, options functions.Options, opts UpgradeOptions) ([]UpgradeResult, error) {
+	k, err := New(kpath, options)
+	if err != nil {
+		return nil, err
+	}
+	results, err := k.Upgrade(opts)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+
+	// reload the Konvert files so they are rendered with their new version
+	k, err = New(kpath, options)
+	if err != nil {
+		return results, err
+	}
+	upgraded := make(map[string]bool)
+	for _, result := range results {
+		upgraded[result.KonvertFile] = true
+	}
+	var fns []kio.Filter
+	for _, fn := range k.fns {
+		if kfn, ok := fn.(*functions.KonvertFunction); ok && upgraded[kfn.FilePath()] {
+			fns = append(fns, fn)
+		}
+	}
+	k.fns = fns
+	if err := k.Run(); err != nil {
+		return results, errors.Wrap(err, "unable to render upgraded charts")
+	}
+	return results, nil
+}
+
+// Upgrade rewrites spec.version of each selected Konvert function's file to
+// the version chosen by opts. Charts that are up to date or not in a
+// repository are left alone. It does not render the charts, see Upgrade.
+func (k *Konverter) Upgrade(opts UpgradeOptions) ([]UpgradeResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var selected []*functions.KonvertFunction
+	for _, kfn := range k.chartFunctions() {
+		if opts.Name != "" && kfn.ResourceMeta.Name != opts.Name {
+			continue
+		}
+		selected = append(selected, kfn)
+	}
+	if opts.Name != "" && len(selected) == 0 {
+		return nil, fmt.Errorf("no Konvert file named %q found in %s", opts.Name, k.path)
+	}
+	if opts.To != "" && len(selected) > 1 {
+		return nil, fmt.Errorf("an exact version can only be set on a single Konvert file rendering a single chart, select it by name")
+	}
+
+	var results []UpgradeResult
+	for _, kfn := range selected {
+		log.WithField("path", kfn.FilePath()).Debug("upgrading Konvert fn")
+
+		version, err := upgradeVersion(kfn, opts)
+		if err != nil {
+			return results, errors.Wrapf(err, "unable to upgrade %s", kfn.FilePath())
+		}
+		if version == "" || version == kfn.Version {
+			log.WithField("path", kfn.FilePath()).Debug("chart is up to date")
+			continue
+		}
+
+		if err := setKonvertVersion(kfn.FilePath(), kfn.ResourceMeta.Name, kfn.ChartIndex(), version); err != nil {
+			return results, err
+		}
+		results = append(results, UpgradeResult{
+			KonvertFile: kfn.FilePath(),
+			Name:        kfn.ResourceMeta.Name,
+			Chart:       kfn.Chart,
+			From:        kfn.Version,
+			To:          version,
+		})
+	}
+	return results, nil
+}
+
+// upgradeVersion returns the version to upgrade the chart to, or an empty
+// string when there is none
+func upgradeVersion(kfn *functions.KonvertFunction, opts UpgradeOptions) (string, error) {
+	if opts.To != "" {
+		versions, err := kfn.ChartVersions()
+		if err != nil {
+			return "", err
+		}
+		if versions == nil {
+			return "", fmt.Errorf("chart %q is not in a repository", kfn.Chart)
+		}
+		to := semver.MustParse(opts.To)
+		for _, version := range versions {
+			if version.Equal(to) {
+				return version.Original(), nil
+			}
+		}
+		return "", fmt.Errorf("chart %q version %q not found", kfn.Chart, opts.To)
+	}
+
+	updates, err := kfn.ChartUpdates()
+	if err != nil || updates == nil {
+		return "", err
+	}
+	switch {
+	case opts.Patch:
+		return updates.Patch, nil
+	case opts.Minor:
+		return updates.Minor, nil
+	default:
+		return updates.Major, nil
+	}
+}
+
+// setKonvertVersion sets spec.version of the Konvert resource named name in
+// the file at path, or spec.charts[chartIndex].version when chartIndex is not
+// negative. kyaml locates the version, but only its value is replaced in the
+// file so comments and formatting are preserved.
+func setKonvertVersion(path, name string, chartIndex int, version string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := kyaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc kyaml.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to parse %s", path)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		node := kyaml.NewRNode(doc.Content[0])
+		if !functions.IsKonvertFile(node) || node.GetName() != name {
+			continue
+		}
+
+		spec := node.Field("spec")
+		if spec == nil {
+			return fmt.Errorf("%s has no spec", path)
+		}
+		chart := spec.Value
+		if chartIndex >= 0 {
+			charts := spec.Value.Field("charts")
+			if charts == nil || chartIndex >= len(charts.Value.Content()) {
+				return fmt.Errorf("%s has no spec.charts[%d]", path, chartIndex)
+			}
+			chart = kyaml.NewRNode(charts.Value.Content()[chartIndex])
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		if field := chart.Field("version"); field != nil {
+			lines = replaceScalar(lines, field.Value.YNode(), version)
+		} else if field := chart.Field("chart"); field != nil {
+			// add the version after the chart, with the same indentation
+			key := field.Key.YNode()
+			line := fmt.Sprintf("%sversion: %s\n", strings.Repeat(" ", key.Column-1), version)
+			after := field.Value.YNode().Line
+			lines = append(lines[:after], append([]string{line}, lines[after:]...)...)
+		} else {
+			return fmt.Errorf("%s has no chart", path)
+		}
+
+		return os.WriteFile(path, []byte(strings.Join(lines, "")), info.Mode().Perm())
+	}
+	return fmt.Errorf("no Konvert resource named %q found in %s", name, path)
+}
+
+// replaceScalar replaces the value of the scalar node in lines, keeping its
+// quoting style
+func replaceScalar(lines []string, node *kyaml.Node, value string) []string {
+	length := len([]rune(node.Value))
+	switch node.Style {
+	case kyaml.DoubleQuotedStyle:
+		length += 2
+		value = `"` + value + `"`
+	case kyaml.SingleQuotedStyle:
+		length += 2
+		value = `'` + value + `'`
+	}
+
+	line := []rune(lines[node.Line-1])
+	start := node.Column - 1
+	lines[node.Line-1] = string(line[:start]) + value + string(line[start+length:])
+	return lines
+}
@@ -0,0 +1,312 @@
+package konvert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// newTestChartRepo serves the local-chart example in the given versions
+func newTestChartRepo(t *testing.T, versions ...string) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+
+	index := repo.NewIndexFile()
+	for _, version := range versions {
+		chart, err := loader.Load(testLocalChartPath(t))
+		require.NoError(t, err, "Load")
+		chart.Metadata.Version = version
+		archive, err := chartutil.Save(chart, dir)
+		require.NoError(t, err, "Save")
+		digest, err := provenance.DigestFile(archive)
+		require.NoError(t, err, "DigestFile")
+		require.NoError(t, index.MustAdd(chart.Metadata, filepath.Base(archive), server.URL, digest), "MustAdd")
+	}
+	index.SortEntries()
+	require.NoError(t, index.WriteFile(filepath.Join(dir, "index.yaml"), 0644), "WriteFile")
+	return server
+}
+
+func TestSetKonvertVersion(t *testing.T) {
+	var tests = []struct {
+		name       string
+		input      string
+		chartIndex int
+		expected   string
+	}{
+		{
+			name:       "plain",
+			chartIndex: -1,
+			input: `# the database
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  chart: mysql
+  version: 9.10.1 # pinned by the dba team
+  values:
+    tags:
+      - a
+`,
+			expected: `# the database
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  chart: mysql
+  version: 9.11.0 # pinned by the dba team
+  values:
+    tags:
+      - a
+`,
+		},
+		{
+			name:       "quoted",
+			chartIndex: -1,
+			input: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+    chart: mysql
+    version: "~9.10.0"
+`,
+			expected: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+    chart: mysql
+    version: "9.11.0"
+`,
+		},
+		{
+			name:       "missing-version",
+			chartIndex: -1,
+			input: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  repo: https://charts.example.com
+  chart: mysql
+  namespace: db
+`,
+			expected: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  repo: https://charts.example.com
+  chart: mysql
+  version: 9.11.0
+  namespace: db
+`,
+		},
+		{
+			name:       "multiple-documents",
+			chartIndex: -1,
+			input: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: cache
+spec:
+  chart: redis
+  version: 1.0.0
+---
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  chart: mysql
+  version: 9.10.1
+`,
+			expected: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: cache
+spec:
+  chart: redis
+  version: 1.0.0
+---
+apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  chart: mysql
+  version: 9.11.0
+`,
+		},
+		{
+			name: "charts",
+			input: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  charts:
+  - chart: redis
+    version: 1.0.0
+  - repo: https://charts.example.com
+    chart: mysql
+    path: mysql
+`,
+			chartIndex: 1,
+			expected: `apiVersion: konvert.kumorilabs.io/v1alpha1
+kind: Konvert
+metadata:
+  name: db
+spec:
+  charts:
+  - chart: redis
+    version: 1.0.0
+  - repo: https://charts.example.com
+    chart: mysql
+    version: 9.11.0
+    path: mysql
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "konvert.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(test.input), 0644), "WriteFile")
+
+			require.NoError(t, setKonvertVersion(path, "db", test.chartIndex, "9.11.0"), "setKonvertVersion")
+			actual, err := os.ReadFile(path)
+			require.NoError(t, err, "ReadFile")
+			assert.Equal(t, test.expected, string(actual), test.name)
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "konvert.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(tests[0].input), 0644), "WriteFile")
+	err := setKonvertVersion(path, "cache", -1, "9.11.0")
+	require.NotNil(t, err, "unknown name")
+	assert.Contains(t, err.Error(), `no Konvert resource named "cache"`, "unknown name")
+}
+
+func TestUpgrade(t *testing.T) {
+	server := newTestChartRepo(t, "0.1.0", "0.1.1", "0.2.0", "1.0.0")
+
+	var tests = []struct {
+		name             string
+		options          UpgradeOptions
+		expectedVersions map[string]string
+		expectedError    string
+	}{
+		{
+			name: "latest",
+			expectedVersions: map[string]string{
+				"a/konvert.yaml": "1.0.0",
+				"b/konvert.yaml": "1.0.0",
+			},
+		},
+		{
+			name:    "patch",
+			options: UpgradeOptions{Patch: true},
+			expectedVersions: map[string]string{
+				"a/konvert.yaml": "0.1.1",
+				"b/konvert.yaml": "0.1.1",
+			},
+		},
+		{
+			name:    "minor-by-name",
+			options: UpgradeOptions{Minor: true, Name: "b"},
+			expectedVersions: map[string]string{
+				"a/konvert.yaml": "0.1.0",
+				"b/konvert.yaml": "0.2.0",
+			},
+		},
+		{
+			name:    "to",
+			options: UpgradeOptions{To: "0.2.0", Name: "a"},
+			expectedVersions: map[string]string{
+				"a/konvert.yaml": "0.2.0",
+				"b/konvert.yaml": "0.1.0",
+			},
+		},
+		{
+			name:          "to-without-name",
+			options:       UpgradeOptions{To: "0.2.0"},
+			expectedError: "single Konvert file",
+		},
+		{
+			name:          "to-unknown-version",
+			options:       UpgradeOptions{To: "0.3.0", Name: "a"},
+			expectedError: `version "0.3.0" not found`,
+		},
+		{
+			name:          "unknown-name",
+			options:       UpgradeOptions{Name: "c"},
+			expectedError: `no Konvert file named "c"`,
+		},
+		{
+			name:          "patch-and-minor",
+			options:       UpgradeOptions{Patch: true, Minor: true},
+			expectedError: "only one of",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			require.NoError(t, testWriteKonvertYAML(baseDir, "a/konvert.yaml", "local-chart", server.URL, "0.1.0", "a"), "testWriteKonvertYAML")
+			require.NoError(t, testWriteKonvertYAML(baseDir, "b/konvert.yaml", "local-chart", server.URL, "0.1.0", "b"), "testWriteKonvertYAML")
+			for _, file := range []string{"a/konvert.yaml", "b/konvert.yaml"} {
+				path := filepath.Join(baseDir, file)
+				content, err := os.ReadFile(path)
+				require.NoError(t, err, "ReadFile")
+				name := filepath.Dir(file)
+				content = []byte(strings.Replace(string(content), "name: local-chart", "name: "+name, 1))
+				require.NoError(t, os.WriteFile(path, content, 0644), "WriteFile")
+			}
+
+			results, err := Upgrade(baseDir, functions.Options{CacheDir: t.TempDir()}, test.options)
+			if test.expectedError != "" {
+				require.NotNil(t, err, test.name)
+				assert.Contains(t, err.Error(), test.expectedError, test.name)
+				return
+			}
+			require.NoError(t, err, test.name)
+
+			upgraded := make(map[string]bool)
+			for _, result := range results {
+				upgraded[result.KonvertFile] = true
+				assert.Equal(t, "0.1.0", result.From, "from")
+			}
+			for file, version := range test.expectedVersions {
+				content, err := os.ReadFile(filepath.Join(baseDir, file))
+				require.NoError(t, err, "ReadFile")
+				assert.Contains(t, string(content), "version: "+version+"\n", file)
+
+				// only the upgraded charts are rendered
+				lock, err := os.ReadFile(filepath.Join(baseDir, filepath.Dir(file), "konvert.lock.yaml"))
+				if !upgraded[filepath.Join(baseDir, file)] {
+					assert.True(t, os.IsNotExist(err), "not rendered")
+					continue
+				}
+				require.NoError(t, err, "rendered")
+				assert.Contains(t, string(lock), "version: "+version+"\n", file)
+			}
+		})
+	}
+}
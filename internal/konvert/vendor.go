@@ -0,0 +1,49 @@
+package konvert
+
+import (
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// VendorResult is the vendored chart archive of a single chart of a Konvert
+// file
+type VendorResult struct {
+	KonvertFile string
+	Chart       string
+	// Archive is empty for local charts, which are not vendored
+	Archive string
+	// Added is false when the archive was already vendored
+	Added bool
+}
+
+// Vendor downloads the chart of every Konvert file found at kpath into its
+// vendor directory
+func Vendor(kpath string, options functions.Options) ([]VendorResult, error) {
+	k, err := New(kpath, options)
+	if err != nil {
+		return nil, err
+	}
+	return k.Vendor()
+}
+
+// Vendor downloads the chart of each Konvert function into its vendor
+// directory
+func (k *Konverter) Vendor() ([]VendorResult, error) {
+	var results []VendorResult
+	for _, kfn := range k.chartFunctions() {
+		log.WithField("path", kfn.FilePath()).Debug("vendoring Konvert fn")
+
+		archive, added, err := kfn.VendorChart()
+		if err != nil {
+			return results, errors.Wrapf(err, "unable to vendor chart for %s", kfn.FilePath())
+		}
+		results = append(results, VendorResult{
+			KonvertFile: kfn.FilePath(),
+			Chart:       kfn.Chart,
+			Archive:     archive,
+			Added:       added,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,29 @@
+package konvert
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kumorilabs/konvert/internal/functions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVendor(t *testing.T) {
+	baseDir := t.TempDir()
+	require.NoError(t, testWriteLocalKonvert(t, baseDir, "a/konvert.yaml"), "testWriteLocalKonvert")
+	require.NoError(t, testWriteLocalKonvert(t, baseDir, "b/konvert.yaml"), "testWriteLocalKonvert")
+
+	results, err := Vendor(baseDir, functions.Options{})
+	require.NoError(t, err, "Vendor")
+	require.Len(t, results, 2, "one result per Konvert file")
+
+	for _, result := range results {
+		assert.Contains(t,
+			[]string{filepath.Join(baseDir, "a/konvert.yaml"), filepath.Join(baseDir, "b/konvert.yaml")},
+			result.KonvertFile,
+		)
+		assert.Empty(t, result.Archive, "local charts are not vendored")
+		assert.False(t, result.Added, "local charts are not vendored")
+	}
+}